@@ -3,34 +3,164 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io/fs"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/gin-gonic/gin"
 	"github.com/jacobtrvl/inventory-management/internal/api"
+	"github.com/jacobtrvl/inventory-management/internal/audit"
+	"github.com/jacobtrvl/inventory-management/internal/auth"
 	"github.com/jacobtrvl/inventory-management/internal/inventory"
 	"github.com/jacobtrvl/inventory-management/internal/store"
+	"github.com/jacobtrvl/inventory-management/internal/store/bolt"
+	"github.com/jacobtrvl/inventory-management/internal/store/sqlite"
+	"github.com/jacobtrvl/inventory-management/internal/tenant"
 	"github.com/jacobtrvl/inventory-management/pkg/observability"
+	"github.com/jacobtrvl/inventory-management/pkg/ratelimiter"
 )
 
 const (
-	defaultAddr = ":8080"
+	defaultAddr      = ":8080"
+	defaultStorePath = "inventory.db"
 )
 
 func main() {
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
 	ctx := context.Background()
-  
-	db := store.NewMemDb()
+
+	logger := observability.NewLogger(observability.LogConfigFromEnv(), os.Stdout)
+	slog.SetDefault(logger)
+	// Route gin debug output (route registration, warnings) through slog as well
+	gin.DebugPrintFunc = func(format string, values ...any) {
+		slog.Debug(strings.TrimSpace(fmt.Sprintf(format, values...)))
+	}
+
+	db, closeStore, err := openStore()
+	if err != nil {
+		slog.Error("Failed to open store", "error", err)
+		os.Exit(1)
+	}
+	defer closeStore()
+	db = store.Trace(db, traceStore)
 	mc := observability.NewMetricsCollector()
 
-	p := inventory.NewInventory(ctx, "products", db, mc)
+	quotas, err := tenantQuotas()
+	if err != nil {
+		slog.Error("Invalid tenant quotas", "error", err)
+		os.Exit(1)
+	}
+	p := inventory.NewInventory(ctx, inventory.ProductsTable, db, mc, quotas...)
 
-	r := api.SetupRouter(ctx, p)
+	opts := []api.Option{api.WithLogger(logger)}
+	if path := os.Getenv("RATE_LIMIT_CONFIG"); path != "" {
+		// A missing file is created on the first change through the admin API
+		limits, err := api.LoadRateLimits(path)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			slog.Error("Failed to load rate limits", "error", err)
+			os.Exit(1)
+		}
+		opts = append(opts, api.WithRateLimits(limits), api.WithRateLimitFile(path))
+	}
+	if v := os.Getenv("TRUSTED_PROXIES"); v != "" {
+		proxies := strings.Split(v, ",")
+		for i, p := range proxies {
+			proxies[i] = strings.TrimSpace(p)
+			if _, _, err := net.ParseCIDR(proxies[i]); err != nil && net.ParseIP(proxies[i]) == nil {
+				slog.Error("Invalid TRUSTED_PROXIES entry", "value", p)
+				os.Exit(1)
+			}
+		}
+		opts = append(opts, api.WithTrustedProxies(proxies))
+	}
+	if v := os.Getenv("IDEMPOTENCY_TTL_SECONDS"); v != "" {
+		ttl, err := strconv.Atoi(v)
+		if err != nil || ttl < 0 {
+			slog.Error("Invalid IDEMPOTENCY_TTL_SECONDS", "value", v)
+			os.Exit(1)
+		}
+		opts = append(opts, api.WithIdempotencyTTL(time.Duration(ttl)*time.Second))
+	}
+	if os.Getenv("AUDIT_LOG") == "true" {
+		log, err := audit.NewLog(db, audit.Table)
+		if err != nil {
+			slog.Error("Failed to create audit log", "error", err)
+			os.Exit(1)
+		}
+		opts = append(opts, api.WithAuditLog(log))
+	}
+	if os.Getenv("ADMIN_API") == "true" {
+		opts = append(opts, api.WithAdminAPI())
+	}
+	if path := os.Getenv("RBAC_POLICY"); path != "" {
+		policy, err := auth.LoadPolicy(path)
+		if err != nil {
+			slog.Error("Failed to load RBAC policy", "error", err)
+			os.Exit(1)
+		}
+		opts = append(opts, api.WithPolicy(policy))
+	}
+	if os.Getenv("API_KEY_AUTH") == "true" {
+		keys, err := auth.NewKeyStore(db, auth.KeysTable)
+		if err != nil {
+			slog.Error("Failed to create API key store", "error", err)
+			os.Exit(1)
+		}
+		// The bootstrap key is needed to issue the first keys through the admin API
+		if secret := os.Getenv("ADMIN_API_KEY"); secret != "" {
+			if _, err := keys.Bootstrap(ctx, secret, "bootstrap", []string{auth.RoleAdmin}); err != nil {
+				slog.Error("Failed to store the bootstrap API key", "error", err)
+				os.Exit(1)
+			}
+		} else {
+			slog.Warn("API key auth is enabled without ADMIN_API_KEY, no keys can be issued")
+		}
+		opts = append(opts, api.WithAPIKeys(keys))
+	}
+	if source := os.Getenv("JWKS_SOURCE"); source != "" {
+		leeway, _ := strconv.Atoi(os.Getenv("JWT_LEEWAY_SECONDS"))
+		v := auth.NewJWTValidator(auth.NewKeySet(source), auth.JWTConfig{
+			Issuer:     os.Getenv("JWT_ISSUER"),
+			Audience:   os.Getenv("JWT_AUDIENCE"),
+			Leeway:     time.Duration(leeway) * time.Second,
+			RolesClaim: os.Getenv("JWT_ROLES_CLAIM"),
+			// Tenant of the caller, default "tenant"
+			TenantClaim: os.Getenv("JWT_TENANT_CLAIM"),
+		})
+		opts = append(opts, api.WithJWT(v))
+	}
+	// Adaptive concurrency limit: "aimd" or "gradient", unset to disable
+	switch alg := os.Getenv("CONCURRENCY_LIMIT"); alg {
+	case "":
+	case "aimd":
+		opts = append(opts, api.WithConcurrencyLimiter(ratelimiter.NewAdaptiveLimiter(
+			ratelimiter.WithLimitAlgorithm(ratelimiter.NewAIMD()))))
+	case "gradient":
+		opts = append(opts, api.WithConcurrencyLimiter(ratelimiter.NewAdaptiveLimiter(
+			ratelimiter.WithLimitAlgorithm(ratelimiter.NewGradient()))))
+	default:
+		slog.Error("Unknown concurrency limit algorithm", "algorithm", alg)
+		os.Exit(1)
+	}
+	authEnabled := os.Getenv("API_KEY_AUTH") == "true" || os.Getenv("JWKS_SOURCE") != ""
+	if os.Getenv("ADMIN_API") == "true" && !authEnabled {
+		slog.Error("ADMIN_API requires API_KEY_AUTH or JWKS_SOURCE, the admin API must not be anonymous")
+		os.Exit(1)
+	}
+	if os.Getenv("AUDIT_LOG") == "true" && !authEnabled {
+		slog.Warn("Auth is disabled, the audit log is recorded but GET /audit is not served")
+	}
+	r := api.SetupRouter(ctx, p, opts...)
 	addr := os.Getenv("ADDR")
 	if addr == "" {
 		addr = defaultAddr
@@ -49,11 +179,95 @@ func main() {
 	<-stop
 	slog.Info("Shutting down server...")
 	mc.Shutdown()
+	p.Shutdown()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	if err := srv.Shutdown(ctx); err != nil {
 		slog.Error("Server shutdown error", "error", err)
 	}
+	r.Shutdown()
 	slog.Info("Server exiting")
 }
+
+// openStore opens the backend selected by STORE_BACKEND: "memory" (default),
+// or a database file at STORE_PATH (default inventory.db) for "sqlite" and
+// "bolt". STORE_CODEC selects how bolt serializes items, "json" (default) or "gob".
+func openStore() (store.Store, func() error, error) {
+	path := os.Getenv("STORE_PATH")
+	if path == "" {
+		path = defaultStorePath
+	}
+	switch backend := os.Getenv("STORE_BACKEND"); backend {
+	case "", "memory":
+		return store.NewMemDb(), func() error { return nil }, nil
+	case "sqlite":
+		s, err := sqlite.Open(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		slog.Info("Using SQLite store", "path", path)
+		return s, s.Close, nil
+	case "bolt":
+		var codec bolt.Codec
+		switch name := os.Getenv("STORE_CODEC"); name {
+		case "", "json":
+			codec = bolt.JSONCodec{}
+		case "gob":
+			codec = bolt.GobCodec{}
+		default:
+			return nil, nil, fmt.Errorf("unknown STORE_CODEC %q", name)
+		}
+		s, err := bolt.Open(path, bolt.WithCodec(codec))
+		if err != nil {
+			return nil, nil, err
+		}
+		slog.Info("Using bolt store", "path", path)
+		return s, s.Close, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown STORE_BACKEND %q", backend)
+	}
+}
+
+// traceStore logs store operations at debug level with the request scoped
+// logger, so they carry the request ID and tenant.
+func traceStore(ctx context.Context, span store.Span) {
+	l := observability.Logger(ctx)
+	if !l.Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+	attrs := []any{"op", span.Op, "table", span.Table, "duration", span.Duration}
+	if span.Err != nil {
+		attrs = append(attrs, "error", span.Err)
+	}
+	l.DebugContext(ctx, "Store operation", attrs...)
+}
+
+// tenantQuotas reads PRODUCT_QUOTA, the product quota of every tenant, and
+// TENANT_QUOTAS, overrides per tenant like "brand-a=5000,brand-b=100".
+func tenantQuotas() ([]inventory.Option, error) {
+	var opts []inventory.Option
+	if v := os.Getenv("PRODUCT_QUOTA"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("PRODUCT_QUOTA: invalid quota %q", v)
+		}
+		opts = append(opts, inventory.WithProductQuota(n))
+	}
+	if v := os.Getenv("TENANT_QUOTAS"); v != "" {
+		quotas := make(map[string]int)
+		for _, entry := range strings.Split(v, ",") {
+			id, q, _ := strings.Cut(strings.TrimSpace(entry), "=")
+			n, err := strconv.Atoi(q)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("TENANT_QUOTAS: invalid quota %q", entry)
+			}
+			if err := tenant.Validate(id); err != nil {
+				return nil, fmt.Errorf("TENANT_QUOTAS: %w", err)
+			}
+			quotas[id] = n
+		}
+		opts = append(opts, inventory.WithTenantQuotas(quotas))
+	}
+	return opts, nil
+}
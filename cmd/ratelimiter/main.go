@@ -14,14 +14,20 @@ func main() {
 	for i := 0; i < 100; i++ {
 		go func(i int) {
 			fmt.Println("Requesting operation goroutine", i+1)
-			rl.Allow()
+			if err := rl.Allow(); err != nil {
+				fmt.Println("Rejected operation goroutine", i+1, err)
+				return
+			}
 			fmt.Println("Allowed operation goroutine", i+1)
 
 		}(i)
 	}
 	for i := 0; i < 10; i++ {
 		fmt.Println("Requesting operation", i+1)
-		rl.Allow()
+		if err := rl.Allow(); err != nil {
+			fmt.Println("Rejected operation", i+1, err)
+			continue
+		}
 		fmt.Println("Allowed operation", i+1)
 	}
 
@@ -0,0 +1,151 @@
+// Copyright 2025 Jacob Philip. All rights reserved.
+package api
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jacobtrvl/inventory-management/internal/auth"
+)
+
+var (
+	errUnknownGroup = errors.New("unknown route group")
+	errNoRateLimit  = errors.New("no rate limit")
+	errInvalidLimit = errors.New("invalid rate limit")
+	errPersist      = errors.New("failed to persist rate limits")
+)
+
+// WithAdminAPI enables the /admin endpoints. They are only served if auth is
+// enabled, see WithAPIKeys and WithJWT.
+func WithAdminAPI() Option {
+	return func(c *config) {
+		c.adminAPI = true
+	}
+}
+
+// adminRoutes registers the rate limit endpoints. The limits are shared by
+// all tenants, so only operators may see and change them.
+func (r Router) adminRoutes(admin *gin.RouterGroup) {
+	limits := admin.Group("/ratelimits", operatorOnly, r.require(auth.PermRateLimitsManage))
+	limits.GET("", r.listRateLimits)
+	limits.GET("/:group", r.getRateLimit)
+	limits.PUT("/:group", r.setRateLimit)
+	limits.DELETE("/:group", r.deleteRateLimit)
+	limits.PUT("/:group/clients/:client", r.setClientLimit)
+	limits.DELETE("/:group/clients/:client", r.deleteClientLimit)
+}
+
+// rateLimitStatus maps rate limit update errors to status codes.
+func rateLimitStatus(err error) int {
+	switch {
+	case errors.Is(err, errUnknownGroup), errors.Is(err, errNoRateLimit):
+		return http.StatusNotFound
+	case errors.Is(err, errInvalidLimit):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// updateRateLimit applies f to the limit of the group in the request path.
+// The resulting config of the group is returned to the client.
+func (r Router) updateRateLimit(c *gin.Context, f func(cur RateLimitConfig, ok bool) (*RateLimitConfig, error)) {
+	group := c.Param("group")
+	if !slices.Contains(rateLimitGroups, group) {
+		c.JSON(http.StatusNotFound, ResponseFormat{Error: fmt.Sprintf("%s: %s", errUnknownGroup, group)})
+		return
+	}
+	var result *RateLimitConfig
+	err := r.rateLimits.update(group, func(cur RateLimitConfig, ok bool) (*RateLimitConfig, error) {
+		cfg, err := f(cur, ok)
+		if err == nil && cfg != nil {
+			if verr := cfg.validate(); verr != nil {
+				return nil, fmt.Errorf("%w: %w", errInvalidLimit, verr)
+			}
+		}
+		result = cfg
+		return cfg, err
+	})
+	if err != nil {
+		c.JSON(rateLimitStatus(err), ResponseFormat{Error: err.Error()})
+		return
+	}
+	if result == nil {
+		c.Status(http.StatusNoContent)
+		return
+	}
+	c.JSON(http.StatusOK, ResponseFormat{Data: result})
+}
+
+func (r Router) listRateLimits(c *gin.Context) {
+	c.JSON(http.StatusOK, ResponseFormat{Data: r.rateLimits.config()})
+}
+
+func (r Router) getRateLimit(c *gin.Context) {
+	cfg, ok := r.rateLimits.config()[c.Param("group")]
+	if !ok {
+		c.JSON(http.StatusNotFound, ResponseFormat{Error: "No rate limit for " + c.Param("group")})
+		return
+	}
+	c.JSON(http.StatusOK, ResponseFormat{Data: cfg})
+}
+
+// setRateLimit replaces the limit of a route group. Clients keep their state
+// where the algorithm allows it, e.g. a token bucket keeps its tokens.
+func (r Router) setRateLimit(c *gin.Context) {
+	var cfg RateLimitConfig
+	if err := c.ShouldBindJSON(&cfg); err != nil {
+		c.JSON(http.StatusBadRequest, ResponseFormat{Error: "Invalid input"})
+		return
+	}
+	r.updateRateLimit(c, func(RateLimitConfig, bool) (*RateLimitConfig, error) {
+		return &cfg, nil
+	})
+}
+
+func (r Router) deleteRateLimit(c *gin.Context) {
+	r.updateRateLimit(c, func(_ RateLimitConfig, ok bool) (*RateLimitConfig, error) {
+		if !ok {
+			return nil, errNoRateLimit
+		}
+		return nil, nil
+	})
+}
+
+// setClientLimit overrides the limit of a single client. The group must be limited.
+func (r Router) setClientLimit(c *gin.Context) {
+	var limit ClientLimit
+	if err := c.ShouldBindJSON(&limit); err != nil {
+		c.JSON(http.StatusBadRequest, ResponseFormat{Error: "Invalid input"})
+		return
+	}
+	client := c.Param("client")
+	r.updateRateLimit(c, func(cur RateLimitConfig, ok bool) (*RateLimitConfig, error) {
+		if !ok {
+			return nil, errNoRateLimit
+		}
+		// The current config is shared with the live limiters, do not modify it
+		cur.Clients = maps.Clone(cur.Clients)
+		if cur.Clients == nil {
+			cur.Clients = make(map[string]ClientLimit)
+		}
+		cur.Clients[client] = limit
+		return &cur, nil
+	})
+}
+
+func (r Router) deleteClientLimit(c *gin.Context) {
+	client := c.Param("client")
+	r.updateRateLimit(c, func(cur RateLimitConfig, ok bool) (*RateLimitConfig, error) {
+		if _, found := cur.Clients[client]; !ok || !found {
+			return nil, errNoRateLimit
+		}
+		cur.Clients = maps.Clone(cur.Clients)
+		delete(cur.Clients, client)
+		return &cur, nil
+	})
+}
@@ -0,0 +1,210 @@
+// Copyright 2025 Jacob Philip. All rights reserved.
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jacobtrvl/inventory-management/internal/audit"
+	"github.com/jacobtrvl/inventory-management/internal/auth"
+	"github.com/jacobtrvl/inventory-management/internal/inventory"
+	"github.com/jacobtrvl/inventory-management/internal/tenant"
+	"github.com/jacobtrvl/inventory-management/pkg/observability"
+)
+
+const (
+	// Handlers set it for resources whose ID is not in the path, e.g. issued keys
+	ctxAuditResource  = "audit_resource"
+	contentTypeNDJSON = "application/x-ndjson"
+)
+
+// WithAuditLog records every POST, PUT and DELETE call in l, and enables GET
+// /audit if auth is enabled.
+func WithAuditLog(l *audit.Log) Option {
+	return func(c *config) {
+		c.audit = l
+	}
+}
+
+// auditActions names the audited routes. Routes without an entry are
+// recorded as "<method> <route>".
+var auditActions = map[string]string{
+	"POST /products":                                  "product.create",
+	"PUT /products/:id":                               "product.update",
+	"DELETE /products/:id":                            "product.delete",
+	"POST /products/:id/stock":                        "stock.adjust",
+	"POST /products:method":                           "product.batch",
+	"POST /products/import":                           "product.import",
+	"POST /admin/keys":                                "key.issue",
+	"DELETE /admin/keys/:id":                          "key.revoke",
+	"PUT /admin/ratelimits/:group":                    "ratelimit.set",
+	"DELETE /admin/ratelimits/:group":                 "ratelimit.delete",
+	"PUT /admin/ratelimits/:group/clients/:client":    "ratelimit.client.set",
+	"DELETE /admin/ratelimits/:group/clients/:client": "ratelimit.client.delete",
+}
+
+func mutating(method string) bool {
+	return method == http.MethodPost || method == http.MethodPut || method == http.MethodDelete
+}
+
+// auditTrail records mutating calls after they are handled, including calls
+// rejected by authentication and authorization. Must run before authenticate,
+// identity and tenant are read from the request once the chain returns.
+// Returns nil if auditing is disabled.
+func (r Router) auditTrail() gin.HandlerFunc {
+	if r.cfg.audit == nil {
+		return nil
+	}
+	log := r.cfg.audit
+	return func(c *gin.Context) {
+		if !mutating(c.Request.Method) {
+			c.Next()
+			return
+		}
+		ctx, changes := inventory.TrackChanges(c.Request.Context())
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+
+		ctx = c.Request.Context()
+		record := audit.Record{
+			Tenant:    tenant.FromContext(ctx),
+			Action:    auditAction(c),
+			RequestID: observability.RequestID(ctx),
+			ClientIP:  c.ClientIP(),
+			Status:    c.Writer.Status(),
+			Outcome:   auditOutcome(c.Writer.Status()),
+		}
+		if id, ok := auth.FromContext(ctx); ok {
+			record.Actor = audit.Actor{ID: id.ID, Name: id.Name, Method: id.Method}
+		}
+		recorded := changes()
+		if len(recorded) == 0 {
+			record.ResourceID = auditResource(c)
+			log.Append(ctx, record)
+			return
+		}
+		// One record per changed product
+		for _, change := range recorded {
+			rec := record
+			if change.Before != nil {
+				rec.Before, rec.ResourceID = change.Before, change.Before.ID
+			}
+			if change.After != nil {
+				rec.After, rec.ResourceID = change.After, change.After.ID
+			}
+			log.Append(ctx, rec)
+		}
+	}
+}
+
+func auditAction(c *gin.Context) string {
+	key := c.Request.Method + " " + c.FullPath()
+	if action, ok := auditActions[key]; ok {
+		return action
+	}
+	if c.FullPath() == "" {
+		return c.Request.Method + " " + c.Request.URL.Path
+	}
+	return key
+}
+
+func auditOutcome(status int) string {
+	switch {
+	case status == http.StatusUnauthorized, status == http.StatusForbidden:
+		return audit.OutcomeDenied
+	case status >= 400:
+		return audit.OutcomeFailure
+	default:
+		return audit.OutcomeSuccess
+	}
+}
+
+func auditResource(c *gin.Context) string {
+	if id := c.GetString(ctxAuditResource); id != "" {
+		return id
+	}
+	if id := c.Param("id"); id != "" {
+		return id
+	}
+	if group := c.Param("group"); group != "" {
+		if client := c.Param("client"); client != "" {
+			return group + "/clients/" + client
+		}
+		return group
+	}
+	return ""
+}
+
+// getAudit returns the audit records matching the query parameters actor,
+// action, resource_id, outcome, since and until (RFC 3339) and limit.
+// Callers of the default tenant can select a tenant, other callers only see
+// their own. format=ndjson exports one record per line.
+func (r Router) getAudit(c *gin.Context) {
+	ctx := c.Request.Context()
+	f := audit.Filter{
+		Tenant:     tenant.FromContext(ctx),
+		ActorID:    c.Query("actor"),
+		Action:     c.Query("action"),
+		ResourceID: c.Query("resource_id"),
+		Outcome:    c.Query("outcome"),
+	}
+	if f.Tenant == tenant.Default {
+		f.Tenant = c.Query("tenant")
+	}
+	for param, t := range map[string]*time.Time{"since": &f.Since, "until": &f.Until} {
+		if v := c.Query(param); v != "" {
+			parsed, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, ResponseFormat{Error: "Invalid " + param + " parameter"})
+				return
+			}
+			*t = parsed
+		}
+	}
+	if v := c.Query("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			c.JSON(http.StatusBadRequest, ResponseFormat{Error: "Invalid limit parameter"})
+			return
+		}
+		f.Limit = limit
+	}
+
+	if c.Query("format") == "ndjson" {
+		r.streamAudit(c, f)
+		return
+	}
+	records, err := r.cfg.audit.Query(ctx, f)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ResponseFormat{Error: "Failed to query audit log"})
+		return
+	}
+	c.JSON(http.StatusOK, ResponseFormat{Data: records})
+}
+
+// streamAudit writes the matching records as one JSON record per line, like
+// streamProducts.
+func (r Router) streamAudit(c *gin.Context, f audit.Filter) {
+	ctx := c.Request.Context()
+	c.Header("Content-Type", contentTypeNDJSON)
+	c.Status(http.StatusOK)
+	enc := json.NewEncoder(c.Writer)
+	written := 0
+	for rec, err := range r.cfg.audit.Scan(ctx, f) {
+		if err == nil {
+			err = enc.Encode(rec)
+		}
+		if err != nil {
+			// The status is sent, the client sees a truncated export
+			observability.Logger(ctx).WarnContext(ctx, "Failed to write audit export", "error", err)
+			return
+		}
+		if written++; written%streamFlushInterval == 0 {
+			c.Writer.Flush()
+		}
+	}
+}
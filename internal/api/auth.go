@@ -0,0 +1,260 @@
+// Copyright 2025 Jacob Philip. All rights reserved.
+package api
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jacobtrvl/inventory-management/internal/auth"
+	"github.com/jacobtrvl/inventory-management/internal/tenant"
+	"github.com/jacobtrvl/inventory-management/pkg/observability"
+)
+
+// WithAPIKeys requires a valid API key in the "Authorization: Bearer" header
+// on all routes. Keys are checked against the given store.
+func WithAPIKeys(keys *auth.KeyStore) Option {
+	return func(c *config) {
+		c.keys = keys
+	}
+}
+
+// WithJWT accepts bearer JWTs validated by v on all routes, in addition to
+// API keys if those are enabled as well.
+func WithJWT(v *auth.JWTValidator) Option {
+	return func(c *config) {
+		c.jwt = v
+	}
+}
+
+// WithPolicy sets the policy mapping the roles of callers to permissions.
+// Defaults to auth.DefaultPolicy(). Only used if auth is enabled.
+func WithPolicy(p auth.Policy) Option {
+	return func(c *config) {
+		c.policy = p
+	}
+}
+
+func (cfg config) authEnabled() bool {
+	return cfg.keys != nil || cfg.jwt != nil
+}
+
+// authenticator picks the authenticator for a bearer token by its shape.
+func (cfg config) authenticator(token string) auth.Authenticator {
+	if cfg.jwt != nil && auth.IsJWT(token) {
+		return cfg.jwt
+	}
+	if cfg.keys != nil {
+		return cfg.keys
+	}
+	return nil
+}
+
+// authenticate rejects requests without a valid API key or JWT with 401, and
+// attaches the caller identity to the request context. Returns nil if auth is disabled.
+func (r Router) authenticate() gin.HandlerFunc {
+	if !r.cfg.authEnabled() {
+		return nil
+	}
+	cfg := r.cfg
+	return func(c *gin.Context) {
+		token := bearerToken(c)
+		if token == "" {
+			unauthorized(c, "Missing bearer token")
+			return
+		}
+		if cfg.authenticator(token) == nil {
+			unauthorized(c, "Unsupported bearer token")
+			return
+		}
+		ctx := c.Request.Context()
+		id, err := cfg.identify(c, token)
+		if err != nil {
+			observability.Logger(ctx).DebugContext(ctx, "Authentication failed", "error", err)
+			unauthorized(c, authError(err))
+			return
+		}
+		ctx = auth.ContextWithIdentity(ctx, id)
+		ctx = observability.ContextWithLogger(ctx, observability.Logger(ctx).With("client_id", id.ID))
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// identityKey caches the identity of a token in the gin context, so that
+// rate limiting by API key and authentication check a token once per request.
+const identityKey = "api.identity"
+
+type cachedIdentity struct {
+	token string
+	id    auth.Identity
+}
+
+// identify authenticates token, the caller checked that it has an authenticator.
+func (cfg config) identify(c *gin.Context, token string) (auth.Identity, error) {
+	if v, ok := c.Get(identityKey); ok {
+		if cached := v.(cachedIdentity); cached.token == token {
+			return cached.id, nil
+		}
+	}
+	id, err := cfg.authenticator(token).Authenticate(c.Request.Context(), token)
+	if err != nil {
+		return auth.Identity{}, err
+	}
+	c.Set(identityKey, cachedIdentity{token: token, id: id})
+	return id, nil
+}
+
+// knownKey reports whether key is a valid API key, see rateLimits. Only the
+// local key store is asked: verifying a JWT may fetch the JWKS, which is too
+// slow for requests that are not limited yet, so JWT callers are limited by
+// IP. Always false without API key auth.
+func (cfg config) knownKey(c *gin.Context, key string) bool {
+	if cfg.keys == nil || (cfg.jwt != nil && auth.IsJWT(key)) {
+		return false
+	}
+	_, err := cfg.identify(c, key)
+	return err == nil
+}
+
+// authError returns the message for a failed authentication. Token validation
+// details stay in the logs, they help attackers more than clients.
+func authError(err error) string {
+	if errors.Is(err, auth.ErrInvalidToken) {
+		return "Invalid token"
+	}
+	return err.Error()
+}
+
+func unauthorized(c *gin.Context, msg string) {
+	c.Header("WWW-Authenticate", `Bearer realm="inventory"`)
+	c.AbortWithStatusJSON(http.StatusUnauthorized, ResponseFormat{Error: msg})
+}
+
+// require rejects callers whose roles do not grant perm with 403.
+// It lets every request through if auth is disabled.
+func (r Router) require(perm auth.Permission) gin.HandlerFunc {
+	if !r.cfg.authEnabled() {
+		return func(c *gin.Context) { c.Next() }
+	}
+	return func(c *gin.Context) {
+		if !r.allowed(c, perm) {
+			forbidden(c, perm)
+			return
+		}
+		c.Next()
+	}
+}
+
+// allowed reports whether the caller has perm. Always true if auth is disabled.
+func (r Router) allowed(c *gin.Context, perm auth.Permission) bool {
+	if !r.cfg.authEnabled() {
+		return true
+	}
+	id, ok := auth.FromContext(c.Request.Context())
+	return ok && r.cfg.policy.Allows(id, perm)
+}
+
+func forbidden(c *gin.Context, perm auth.Permission) {
+	c.AbortWithStatusJSON(http.StatusForbidden, ResponseFormat{Error: "Missing permission " + string(perm)})
+}
+
+// bearerToken returns the token of an "Authorization: Bearer <token>" header.
+func bearerToken(c *gin.Context) string {
+	if h := c.GetHeader("Authorization"); len(h) > 7 && strings.EqualFold(h[:7], "bearer ") {
+		return strings.TrimSpace(h[7:])
+	}
+	return ""
+}
+
+type issueKeyRequest struct {
+	Name  string   `json:"name" binding:"required"`
+	Roles []string `json:"roles" binding:"required"`
+	// Defaults to the tenant of the caller
+	Tenant string `json:"tenant"`
+	// Zero never expires
+	TTLSeconds int `json:"ttl_seconds"`
+}
+
+type issueKeyResponse struct {
+	auth.APIKey
+	// The secret is returned once, only its hash is stored
+	Key string `json:"key"`
+}
+
+func (r Router) keyRoutes(admin *gin.RouterGroup) {
+	manage := r.require(auth.PermKeysManage)
+	admin.GET("/keys", manage, r.listKeys)
+	admin.POST("/keys", manage, r.issueKey)
+	admin.DELETE("/keys/:id", manage, r.revokeKey)
+}
+
+func (r Router) issueKey(c *gin.Context) {
+	var req issueKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.TTLSeconds < 0 {
+		c.JSON(http.StatusBadRequest, ResponseFormat{Error: "Invalid input"})
+		return
+	}
+	// Keys with roles the policy does not know would be useless
+	if err := r.cfg.policy.ValidateRoles(req.Roles); err != nil {
+		c.JSON(http.StatusBadRequest, ResponseFormat{Error: "Failed to issue API key: " + err.Error()})
+		return
+	}
+	ctx := c.Request.Context()
+	owner, ok := keyTenant(tenant.FromContext(ctx), req.Tenant)
+	if !ok {
+		c.JSON(http.StatusForbidden, ResponseFormat{Error: "Cannot issue API keys for tenant " + req.Tenant})
+		return
+	}
+	secret, key, err := r.cfg.keys.Issue(ctx, req.Name, owner, req.Roles, time.Duration(req.TTLSeconds)*time.Second)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, tenant.ErrInvalid) {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, ResponseFormat{Error: "Failed to issue API key: " + err.Error()})
+		return
+	}
+	c.Set(ctxAuditResource, key.ID)
+	c.JSON(http.StatusCreated, ResponseFormat{Data: issueKeyResponse{APIKey: key, Key: secret}})
+}
+
+func (r Router) listKeys(c *gin.Context) {
+	ctx := c.Request.Context()
+	keys, err := r.cfg.keys.List(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ResponseFormat{Error: "Failed to list API keys"})
+		return
+	}
+	caller := tenant.FromContext(ctx)
+	keys = slices.DeleteFunc(keys, func(k auth.APIKey) bool { return !ownsKey(caller, k) })
+	c.JSON(http.StatusOK, ResponseFormat{Data: keys})
+}
+
+func (r Router) revokeKey(c *gin.Context) {
+	ctx := c.Request.Context()
+	keys, err := r.cfg.keys.List(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ResponseFormat{Error: "Failed to revoke API key: " + err.Error()})
+		return
+	}
+	// Keys of other tenants are reported as missing, not as forbidden
+	caller := tenant.FromContext(ctx)
+	if !slices.ContainsFunc(keys, func(k auth.APIKey) bool { return k.ID == c.Param("id") && ownsKey(caller, k) }) {
+		c.JSON(http.StatusNotFound, ResponseFormat{Error: "Failed to revoke API key: " + auth.ErrKeyNotFound.Error()})
+		return
+	}
+	key, err := r.cfg.keys.Revoke(ctx, c.Param("id"))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, auth.ErrKeyNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, ResponseFormat{Error: "Failed to revoke API key: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, ResponseFormat{Data: key})
+}
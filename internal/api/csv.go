@@ -0,0 +1,83 @@
+// Copyright 2025 Jacob Philip. All rights reserved.
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jacobtrvl/inventory-management/internal/auth"
+	"github.com/jacobtrvl/inventory-management/internal/inventory"
+	"github.com/jacobtrvl/inventory-management/pkg/observability"
+)
+
+const (
+	contentTypeCSV = "text/csv"
+	// Upper bound on the body of an import
+	maxImportBytes = 32 << 20
+)
+
+// exportProducts streams the products of the tenant. Only format=csv is
+// supported, and it is the default.
+func (r Router) exportProducts(c *gin.Context) {
+	if format := c.DefaultQuery("format", "csv"); format != "csv" {
+		c.JSON(http.StatusBadRequest, ResponseFormat{Error: "Unsupported format " + format})
+		return
+	}
+	c.Header("Content-Type", contentTypeCSV+"; charset=utf-8")
+	c.Header("Content-Disposition", `attachment; filename="products.csv"`)
+	c.Status(http.StatusOK)
+	ctx := c.Request.Context()
+	if err := r.i.ExportCSV(ctx, c.Writer); err != nil {
+		// The status is sent, the client sees a truncated export
+		observability.Logger(ctx).WarnContext(ctx, "Failed to write product export", "error", err)
+	}
+}
+
+// importProducts creates or updates products from a CSV body. Query
+// parameters are mode (insert or upsert), dry_run and mapping, a list of
+// header:field pairs such as "SKU:id,Title:name".
+func (r Router) importProducts(c *gin.Context) {
+	opts := inventory.ImportOptions{
+		Mode: c.DefaultQuery("mode", inventory.ImportInsert),
+		// Setting the stock of existing products is a stock adjustment
+		AllowStockUpdates: r.allowed(c, auth.PermStockAdjust),
+	}
+	if v := c.Query("dry_run"); v != "" {
+		dryRun, err := strconv.ParseBool(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ResponseFormat{Error: "Invalid dry_run parameter"})
+			return
+		}
+		opts.DryRun = dryRun
+	}
+	if v := c.Query("mapping"); v != "" {
+		opts.Mapping = make(map[string]string)
+		for _, pair := range strings.Split(v, ",") {
+			header, field, ok := strings.Cut(pair, ":")
+			if !ok || strings.TrimSpace(header) == "" {
+				c.JSON(http.StatusBadRequest, ResponseFormat{Error: "Invalid mapping parameter"})
+				return
+			}
+			opts.Mapping[header] = strings.TrimSpace(field)
+		}
+	}
+
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, maxImportBytes)
+	report, status, err := r.i.ImportCSV(c.Request.Context(), body, opts)
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, ResponseFormat{Error: "Import too large"})
+	case errors.Is(err, inventory.ErrStockUpdate):
+		forbidden(c, auth.PermStockAdjust)
+	case status == http.StatusUnprocessableEntity:
+		c.JSON(status, ResponseFormat{Error: "Import failed: " + err.Error(), Data: report})
+	case err != nil:
+		c.JSON(status, ResponseFormat{Error: "Import failed: " + err.Error()})
+	default:
+		c.JSON(http.StatusOK, ResponseFormat{Data: report})
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright 2025 Jacob Philip. All rights reserved.
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jacobtrvl/inventory-management/internal/auth"
+	"github.com/jacobtrvl/inventory-management/internal/tenant"
+)
+
+const (
+	headerIdempotencyKey = "Idempotency-Key"
+	headerReplayed       = "Idempotent-Replayed"
+	// Upper bound on client supplied keys, a UUID is 36 characters
+	maxIdempotencyKeyLength = 255
+	defaultIdempotencyTTL   = 24 * time.Hour
+	// Expired keys are removed at most this often, on the next new key
+	idempotencySweepInterval = time.Minute
+)
+
+// WithIdempotencyTTL sets how long responses to POST requests with an
+// Idempotency-Key header are kept for replay. Defaults to 24 hours, zero
+// disables idempotency keys.
+func WithIdempotencyTTL(ttl time.Duration) Option {
+	return func(c *config) {
+		c.idempotencyTTL = ttl
+	}
+}
+
+// idempotencyCache holds the responses of POST requests by idempotency key.
+// Keys are scoped to the tenant and the caller, so that clients cannot see
+// each other's responses.
+type idempotencyCache struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu        sync.Mutex
+	entries   map[string]*idempotentResponse
+	lastSweep time.Time
+}
+
+type idempotentResponse struct {
+	fingerprint [sha256.Size]byte
+	// false while the first request is in flight
+	done        bool
+	status      int
+	contentType string
+	body        []byte
+	expires     time.Time
+}
+
+func newIdempotencyCache(ttl time.Duration) *idempotencyCache {
+	return &idempotencyCache{
+		ttl:     ttl,
+		now:     time.Now,
+		entries: make(map[string]*idempotentResponse),
+	}
+}
+
+// begin returns the entry of a key seen before, or reserves the key and returns nil.
+func (ic *idempotencyCache) begin(key string, fingerprint [sha256.Size]byte) *idempotentResponse {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	now := ic.now()
+	if e, ok := ic.entries[key]; ok && (!e.done || now.Before(e.expires)) {
+		// Copy, the caller reads it without the lock
+		cp := *e
+		return &cp
+	}
+	if now.Sub(ic.lastSweep) >= idempotencySweepInterval {
+		for k, e := range ic.entries {
+			if e.done && !now.Before(e.expires) {
+				delete(ic.entries, k)
+			}
+		}
+		ic.lastSweep = now
+	}
+	ic.entries[key] = &idempotentResponse{fingerprint: fingerprint}
+	return nil
+}
+
+// finish stores the response of a reserved key. Server errors are not
+// stored, so that the client can retry.
+func (ic *idempotencyCache) finish(key string, status int, contentType string, body []byte) {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	if status >= 500 {
+		delete(ic.entries, key)
+		return
+	}
+	e := ic.entries[key]
+	e.done = true
+	e.status = status
+	e.contentType = contentType
+	e.body = body
+	e.expires = ic.now().Add(ic.ttl)
+}
+
+// requestFingerprint tells apart requests reusing an idempotency key.
+func requestFingerprint(path string, body []byte) [sha256.Size]byte {
+	h := sha256.New()
+	io.WriteString(h, path+"\x00")
+	h.Write(body)
+	var sum [sha256.Size]byte
+	h.Sum(sum[:0])
+	return sum
+}
+
+// captureWriter keeps a copy of the response body.
+type captureWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *captureWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *captureWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// idempotency replays the response of a POST request retried with the same
+// Idempotency-Key header. A key reused with another request gets 422, a retry
+// while the first request is in flight gets 409. Requests without the header
+// are not affected. Must run after tenantScope. Returns nil if disabled.
+func (r Router) idempotency() gin.HandlerFunc {
+	ic := r.idempotencyKeys
+	if ic == nil {
+		return nil
+	}
+	return func(c *gin.Context) {
+		key := c.GetHeader(headerIdempotencyKey)
+		if c.Request.Method != http.MethodPost || key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			c.AbortWithStatusJSON(http.StatusBadRequest, ResponseFormat{Error: "Idempotency-Key too long"})
+			return
+		}
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, ResponseFormat{Error: "Invalid input"})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		ctx := c.Request.Context()
+		scope := tenant.FromContext(ctx) + "\x00"
+		if id, ok := auth.FromContext(ctx); ok {
+			scope += id.ID
+		}
+		scoped := scope + "\x00" + key
+		fingerprint := requestFingerprint(c.Request.URL.Path, body)
+
+		if prev := ic.begin(scoped, fingerprint); prev != nil {
+			switch {
+			case prev.fingerprint != fingerprint:
+				c.AbortWithStatusJSON(http.StatusUnprocessableEntity,
+					ResponseFormat{Error: "Idempotency-Key reused with a different request"})
+			case !prev.done:
+				c.AbortWithStatusJSON(http.StatusConflict,
+					ResponseFormat{Error: "A request with this Idempotency-Key is in progress"})
+			default:
+				c.Header(headerReplayed, "true")
+				c.Data(prev.status, prev.contentType, prev.body)
+				c.Abort()
+			}
+			return
+		}
+
+		w := &captureWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		// Handlers that panic are recovered further up, release the key in any case
+		status := http.StatusInternalServerError
+		defer func() {
+			ic.finish(scoped, status, w.Header().Get("Content-Type"), w.body.Bytes())
+		}()
+		c.Next()
+		status = w.Status()
+	}
+}
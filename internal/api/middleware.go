@@ -0,0 +1,87 @@
+// Copyright 2025 Jacob Philip. All rights reserved.
+package api
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jacobtrvl/inventory-management/internal/auth"
+	"github.com/jacobtrvl/inventory-management/pkg/observability"
+)
+
+const (
+	headerRequestID = "X-Request-ID"
+	// Upper bound on client supplied request IDs, longer values are replaced
+	maxRequestIDLength = 128
+)
+
+// requestID accepts the X-Request-ID header from the client or generates one.
+// The ID is stored in the request context and echoed back in the response.
+func requestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(headerRequestID)
+		if !validRequestID(id) {
+			id = uuid.New().String()
+		}
+		ctx := observability.ContextWithRequestID(c.Request.Context(), id)
+		c.Request = c.Request.WithContext(ctx)
+		c.Header(headerRequestID, id)
+		c.Next()
+	}
+}
+
+// Request IDs end up in logs and response headers, so only printable ASCII is accepted
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// accessLog replaces the gin default logger, so that access logs and
+// application logs share the same slog handler and structure.
+// A request scoped logger is attached to the request context, which the
+// inventory layer picks up through observability.Logger.
+// Request ID is not added here, the logger's ContextHandler picks it up from
+// the context. Must run after requestID.
+func accessLog(logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		ctx := observability.ContextWithLogger(c.Request.Context(), logger)
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case status >= 400:
+			level = slog.LevelWarn
+		}
+		// Size is -1 when nothing was written
+		bytes := max(c.Writer.Size(), 0)
+		attrs := []slog.Attr{
+			slog.String("method", c.Request.Method),
+			slog.String("route", c.FullPath()),
+			slog.String("path", c.Request.URL.Path),
+			slog.Int("status", status),
+			slog.Duration("latency", time.Since(start)),
+			slog.String("client_ip", c.ClientIP()),
+			slog.Int("bytes", bytes),
+		}
+		// Set by authenticate on the request passed down the chain
+		if id, ok := auth.FromContext(c.Request.Context()); ok {
+			attrs = append(attrs, slog.String("client_id", id.ID))
+		}
+		logger.LogAttrs(ctx, level, "request", attrs...)
+	}
+}
@@ -0,0 +1,478 @@
+// Copyright 2025 Jacob Philip. All rights reserved.
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jacobtrvl/inventory-management/pkg/ratelimiter"
+)
+
+// Route groups that can be rate limited separately.
+const (
+	GroupProducts = "products"
+	GroupMetrics  = "metrics"
+	GroupAdmin    = "admin"
+	GroupAudit    = "audit"
+)
+
+var rateLimitGroups = []string{GroupProducts, GroupMetrics, GroupAdmin, GroupAudit}
+
+// Client key sources for RateLimitConfig.Key.
+const (
+	KeyByIP     = "ip"
+	KeyByAPIKey = "api_key"
+	// KeyByHeaderPrefix is followed by the header name, e.g. "header:X-Client-ID"
+	KeyByHeaderPrefix = "header:"
+)
+
+const defaultIdleTimeout = 10 * time.Minute
+
+// RateLimitConfig is the per client limit of a route group.
+type RateLimitConfig struct {
+	// Requests per second per client
+	Rate int `json:"rate"`
+	// Bucket size, defaults to Rate
+	Burst int `json:"burst,omitempty"`
+	// How clients are told apart: "ip" (default), "api_key" or "header:<name>".
+	// Requests without an API key or the header fall back to the client IP.
+	Key string `json:"key,omitempty"`
+	// Limiters of clients idle for longer than this are evicted. Defaults to 10 minutes.
+	IdleTimeoutSeconds int `json:"idle_timeout_seconds,omitempty"`
+	// token_bucket (default), sliding_window_log, sliding_window_counter or gcra
+	Algorithm string `json:"algorithm,omitempty"`
+	// Limits of individual clients overriding Rate and Burst. Clients are
+	// identified by their key: "ip:<address>", "header:<value>" or
+	// "key:<hex sha256 of the API key>".
+	Clients map[string]ClientLimit `json:"clients,omitempty"`
+}
+
+// ClientLimit overrides the limit of a route group for a single client.
+type ClientLimit struct {
+	Rate  int `json:"rate"`
+	Burst int `json:"burst,omitempty"`
+}
+
+func (cfg RateLimitConfig) validate() error {
+	if cfg.Rate <= 0 {
+		return errors.New("rate must be positive")
+	}
+	if cfg.Burst < 0 || cfg.IdleTimeoutSeconds < 0 {
+		return errors.New("burst and idle timeout must not be negative")
+	}
+	if _, err := ratelimiter.ParseAlgorithm(cfg.Algorithm); err != nil {
+		return err
+	}
+	for client, l := range cfg.Clients {
+		if err := l.validate(); err != nil {
+			return fmt.Errorf("client %s: %w", client, err)
+		}
+	}
+	return nil
+}
+
+func (l ClientLimit) validate() error {
+	if l.Rate <= 0 || l.Burst < 0 {
+		return errors.New("rate must be positive and burst must not be negative")
+	}
+	return nil
+}
+
+// LoadRateLimits reads per route group limits from a JSON file, e.g.
+//
+//	{"products": {"rate": 100, "burst": 200, "key": "api_key"}}
+func LoadRateLimits(path string) (map[string]RateLimitConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	limits := make(map[string]RateLimitConfig)
+	if err := json.Unmarshal(data, &limits); err != nil {
+		return nil, fmt.Errorf("invalid rate limit config %s: %w", path, err)
+	}
+	for group, cfg := range limits {
+		// Groups without a rate are not limited
+		if cfg.Rate <= 0 {
+			continue
+		}
+		if err := cfg.validate(); err != nil {
+			return nil, fmt.Errorf("invalid rate limit config for %s: %w", group, err)
+		}
+	}
+	return limits, nil
+}
+
+// SaveRateLimits writes limits in the format read by LoadRateLimits.
+// The file is replaced atomically, a crash never leaves half a config behind.
+func SaveRateLimits(path string, limits map[string]RateLimitConfig) error {
+	data, err := json.MarshalIndent(limits, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// WithRateLimits enables per client rate limiting for the given route groups.
+// Groups without an entry are not limited.
+func WithRateLimits(limits map[string]RateLimitConfig) Option {
+	return func(c *config) {
+		c.rateLimits = limits
+	}
+}
+
+// WithRateLimitFile persists rate limits changed through the admin API to path,
+// so that they survive a restart. Without it changes are kept in memory only.
+func WithRateLimitFile(path string) Option {
+	return func(c *config) {
+		c.rateLimitFile = path
+	}
+}
+
+// rateLimits holds the limiters of all route groups. Limits can change at
+// runtime through the admin API, hence the lock.
+type rateLimits struct {
+	mu     sync.RWMutex
+	groups map[string]*groupLimiter
+	// persisted config, empty to keep changes in memory
+	path   string
+	logger *slog.Logger
+	known  knownKey
+}
+
+// knownKey reports whether a presented API key or token authenticates.
+// Limiting by API key only uses keys it accepts, so that made up keys cannot
+// get a fresh limit on every request.
+type knownKey func(c *gin.Context, key string) bool
+
+// groupLimiter limits the clients of one route group.
+type groupLimiter struct {
+	cfg       RateLimitConfig
+	algorithm ratelimiter.Algorithm
+	key       func(c *gin.Context) string
+	known     knownKey
+	limiters  *ratelimiter.KeyedLimiter
+	// limiters of clients with their own limit, never evicted
+	clients map[string]ratelimiter.Limiter
+}
+
+func newRateLimits(limits map[string]RateLimitConfig, path string, logger *slog.Logger, known knownKey) *rateLimits {
+	rl := &rateLimits{
+		groups: make(map[string]*groupLimiter),
+		path:   path,
+		logger: logger,
+		known:  known,
+	}
+	for group, cfg := range limits {
+		if cfg.Rate <= 0 {
+			continue
+		}
+		if err := cfg.validate(); err != nil {
+			logger.Error("Ignoring invalid rate limit", "group", group, "error", err)
+			continue
+		}
+		rl.groups[group] = newGroupLimiter(cfg, known)
+	}
+	return rl
+}
+
+func newGroupLimiter(cfg RateLimitConfig, known knownKey) *groupLimiter {
+	idle := defaultIdleTimeout
+	if cfg.IdleTimeoutSeconds > 0 {
+		idle = time.Duration(cfg.IdleTimeoutSeconds) * time.Second
+	}
+	// Validated by the caller
+	algorithm, _ := ratelimiter.ParseAlgorithm(cfg.Algorithm)
+	g := &groupLimiter{
+		cfg:       cfg,
+		algorithm: algorithm,
+		key:       clientKey(cfg.Key, known),
+		known:     known,
+		limiters:  ratelimiter.NewKeyedLimiter(limiterFactory(algorithm, cfg.Rate, cfg.Burst), idle),
+		clients:   make(map[string]ratelimiter.Limiter),
+	}
+	for client, l := range cfg.Clients {
+		g.clients[client] = limiterFactory(algorithm, l.Rate, l.Burst)()
+	}
+	return g
+}
+
+func limiterFactory(algorithm ratelimiter.Algorithm, rate, burst int) func() ratelimiter.Limiter {
+	return func() ratelimiter.Limiter {
+		// Algorithm is validated by the caller
+		l, _ := ratelimiter.NewLimiter(algorithm, rate, ratelimiter.WithBurst(burst))
+		return l
+	}
+}
+
+// tune changes the limits of l in place, if its algorithm supports that.
+func tune(rate, burst int) func(ratelimiter.Limiter) bool {
+	if burst <= 0 {
+		burst = rate
+	}
+	return func(l ratelimiter.Limiter) bool {
+		t, ok := l.(ratelimiter.Tunable)
+		if ok {
+			t.SetRate(rate)
+			t.SetBurst(burst)
+		}
+		return ok
+	}
+}
+
+// update applies a new config. Clients keep their limiter state where the
+// algorithm allows changing limits in place. A different key source,
+// algorithm or idle timeout starts from scratch.
+func (g *groupLimiter) update(cfg RateLimitConfig) *groupLimiter {
+	if cfg.Key != g.cfg.Key || cfg.Algorithm != g.cfg.Algorithm ||
+		cfg.IdleTimeoutSeconds != g.cfg.IdleTimeoutSeconds {
+		g.shutdown()
+		return newGroupLimiter(cfg, g.known)
+	}
+	g.limiters.Update(limiterFactory(g.algorithm, cfg.Rate, cfg.Burst), tune(cfg.Rate, cfg.Burst))
+	for client, l := range g.clients {
+		limit, ok := cfg.Clients[client]
+		if !ok || !tune(limit.Rate, limit.Burst)(l) {
+			l.Shutdown()
+			delete(g.clients, client)
+		}
+	}
+	for client, limit := range cfg.Clients {
+		if _, ok := g.clients[client]; !ok {
+			g.clients[client] = limiterFactory(g.algorithm, limit.Rate, limit.Burst)()
+		}
+	}
+	g.cfg = cfg
+	return g
+}
+
+func (g *groupLimiter) shutdown() {
+	g.limiters.Shutdown()
+	for _, l := range g.clients {
+		l.Shutdown()
+	}
+}
+
+func (g *groupLimiter) take(key string) ratelimiter.Decision {
+	if l, ok := g.clients[key]; ok {
+		return l.Take(1)
+	}
+	return g.limiters.Get(key).Take(1)
+}
+
+// config returns the current limits of all groups.
+func (rl *rateLimits) config() map[string]RateLimitConfig {
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
+	return rl.configLocked()
+}
+
+func (rl *rateLimits) configLocked() map[string]RateLimitConfig {
+	limits := make(map[string]RateLimitConfig, len(rl.groups))
+	for group, g := range rl.groups {
+		limits[group] = g.cfg
+	}
+	return limits
+}
+
+// update changes the limit of a group to the config returned by f, nil removes
+// the limit. f gets the current config and whether the group is limited, and
+// runs under the lock so that concurrent changes are not lost. The new config
+// is persisted before it is applied, so a failed write changes nothing.
+func (rl *rateLimits) update(group string, f func(cur RateLimitConfig, ok bool) (*RateLimitConfig, error)) error {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	g, ok := rl.groups[group]
+	var cur RateLimitConfig
+	if ok {
+		cur = g.cfg
+	}
+	cfg, err := f(cur, ok)
+	if err != nil {
+		return err
+	}
+	if rl.path != "" {
+		limits := rl.configLocked()
+		if cfg != nil {
+			limits[group] = *cfg
+		} else {
+			delete(limits, group)
+		}
+		if err := SaveRateLimits(rl.path, limits); err != nil {
+			return fmt.Errorf("%w: %w", errPersist, err)
+		}
+	}
+
+	switch {
+	case cfg == nil && ok:
+		g.shutdown()
+		delete(rl.groups, group)
+	case cfg == nil:
+	case ok:
+		rl.groups[group] = g.update(*cfg)
+	default:
+		rl.groups[group] = newGroupLimiter(*cfg, rl.known)
+	}
+	rl.logger.Info("Rate limit changed", "group", group, "limit", cfg)
+	return nil
+}
+
+func (rl *rateLimits) shutdown() {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	for _, g := range rl.groups {
+		g.shutdown()
+	}
+}
+
+// middleware rate limits a route group. Groups without a limit pass through,
+// the limit can be set later through the admin API.
+// Over limit requests are rejected with 429 rather than queued.
+func (rl *rateLimits) middleware(group string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rl.mu.RLock()
+		g, ok := rl.groups[group]
+		rl.mu.RUnlock()
+		if !ok {
+			c.Next()
+			return
+		}
+		// Looking up an API key reads the key store, which must not block
+		// updates of the limits
+		key := g.key(c)
+
+		// The read lock keeps the limiter from being shut down by an update
+		rl.mu.RLock()
+		cur, ok := rl.groups[group]
+		var d ratelimiter.Decision
+		if ok {
+			if cur.cfg.Key != g.cfg.Key {
+				// The key source changed meanwhile, fall back to the IP
+				key = "ip:" + c.ClientIP()
+			}
+			d = cur.take(key)
+		}
+		rl.mu.RUnlock()
+		if !ok {
+			c.Next()
+			return
+		}
+
+		c.Header("RateLimit-Limit", strconv.Itoa(d.Limit))
+		c.Header("RateLimit-Remaining", strconv.Itoa(d.Remaining))
+		c.Header("RateLimit-Reset", strconv.Itoa(ceilSeconds(d.Reset)))
+		if !d.Allowed {
+			if d.RetryAfter > 0 {
+				c.Header("Retry-After", strconv.Itoa(ceilSeconds(d.RetryAfter)))
+			}
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, ResponseFormat{Error: "Rate limit exceeded"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// WithConcurrencyLimiter sheds requests with 503 while the adaptive limiter
+// is saturated. It applies to all routes.
+func WithConcurrencyLimiter(l *ratelimiter.AdaptiveLimiter) Option {
+	return func(c *config) {
+		c.concurrency = l
+	}
+}
+
+// concurrencyLimit admits requests while fewer than the adaptive limit are in
+// flight. Latency of completed requests adjusts the limit. The slot is
+// released in a defer, a panicking handler unwinds past this middleware to
+// gin.Recovery and counts as dropped.
+func concurrencyLimit(l *ratelimiter.AdaptiveLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, ok := l.Acquire()
+		if !ok {
+			c.Header("Retry-After", "1")
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, ResponseFormat{Error: "Server is overloaded"})
+			return
+		}
+		completed := false
+		defer func() {
+			status := c.Writer.Status()
+			if !completed || status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout {
+				token.OnDropped()
+				return
+			}
+			token.OnSuccess()
+		}()
+		c.Next()
+		completed = true
+	}
+}
+
+// clientKey tells clients apart by source. Keys that are not valid API keys,
+// JWTs and all keys if auth is disabled are limited by IP address like
+// requests without a key. IP addresses are only taken from X-Forwarded-For and similar
+// headers behind trusted proxies, see WithTrustedProxies.
+func clientKey(source string, known knownKey) func(c *gin.Context) string {
+	switch {
+	case source == KeyByAPIKey:
+		return func(c *gin.Context) string {
+			if k := apiKey(c); k != "" && known != nil && known(c, k) {
+				// Keys are kept hashed, limiter map should not hold secrets
+				sum := sha256.Sum256([]byte(k))
+				return "key:" + hex.EncodeToString(sum[:])
+			}
+			return "ip:" + c.ClientIP()
+		}
+	case strings.HasPrefix(source, KeyByHeaderPrefix):
+		header := strings.TrimPrefix(source, KeyByHeaderPrefix)
+		return func(c *gin.Context) string {
+			if v := c.GetHeader(header); v != "" {
+				return "header:" + v
+			}
+			return "ip:" + c.ClientIP()
+		}
+	default:
+		return func(c *gin.Context) string {
+			return "ip:" + c.ClientIP()
+		}
+	}
+}
+
+// apiKey returns the key from "Authorization: Bearer <key>" or the X-API-Key header.
+func apiKey(c *gin.Context) string {
+	if token := bearerToken(c); token != "" {
+		return token
+	}
+	return c.GetHeader("X-API-Key")
+}
+
+// Rate limit headers are in whole seconds, rounded up so clients do not retry too early
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
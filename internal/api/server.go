@@ -3,17 +3,29 @@ package api
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jacobtrvl/inventory-management/internal/audit"
+	"github.com/jacobtrvl/inventory-management/internal/auth"
 	"github.com/jacobtrvl/inventory-management/internal/inventory"
+	"github.com/jacobtrvl/inventory-management/pkg/observability"
+	"github.com/jacobtrvl/inventory-management/pkg/ratelimiter"
 )
 
 type Router struct {
-	e *gin.Engine
-	i *inventory.Inventory
+	e   *gin.Engine
+	i   *inventory.Inventory
+	cfg config
+	// per client limiters of the rate limited route groups
+	rateLimits *rateLimits
+	// responses by Idempotency-Key, nil if disabled
+	idempotencyKeys *idempotencyCache
 }
 
 type ResponseFormat struct {
@@ -22,25 +34,138 @@ type ResponseFormat struct {
 	Meta  any    `json:"meta,omitempty"`
 }
 
-func SetupRouter(ctx context.Context, i *inventory.Inventory) Router {
-	router := gin.Default()
+// Option customizes the router created by SetupRouter.
+type Option func(*config)
+
+type config struct {
+	logger        *slog.Logger
+	rateLimits    map[string]RateLimitConfig
+	rateLimitFile string
+	concurrency   *ratelimiter.AdaptiveLimiter
+	adminAPI      bool
+	keys          *auth.KeyStore
+	jwt           *auth.JWTValidator
+	policy        auth.Policy
+	audit         *audit.Log
+	// zero disables idempotency keys
+	idempotencyTTL time.Duration
+	trustedProxies []string
+}
+
+// WithTrustedProxies sets the addresses or CIDR ranges of proxies whose
+// X-Forwarded-For header gives the client IP, used by rate limits by IP and
+// in logs. By default no proxy is trusted and the client IP is the address
+// of the connection, so that clients cannot choose their own.
+func WithTrustedProxies(proxies []string) Option {
+	return func(c *config) {
+		c.trustedProxies = proxies
+	}
+}
+
+// WithLogger sets the logger used for access logs and request scoped logging.
+// Defaults to slog.Default().
+func WithLogger(l *slog.Logger) Option {
+	return func(c *config) {
+		c.logger = l
+	}
+}
+
+func SetupRouter(ctx context.Context, i *inventory.Inventory, opts ...Option) Router {
+	cfg := config{
+		logger:         slog.Default(),
+		policy:         auth.DefaultPolicy(),
+		idempotencyTTL: defaultIdempotencyTTL,
+	}
+	for _, o := range opts {
+		o(&cfg)
+	}
+
+	// gin.Default() is not used, since its logger does not match the slog format
+	router := gin.New()
+	if err := router.SetTrustedProxies(cfg.trustedProxies); err != nil {
+		cfg.logger.Error("Invalid trusted proxies, trusting none", "error", err)
+		router.SetTrustedProxies(nil)
+	}
+	router.Use(requestID(), accessLog(cfg.logger), gin.Recovery())
+	if cfg.concurrency != nil {
+		router.Use(concurrencyLimit(cfg.concurrency))
+	}
 	r := Router{
-		e: router,
-		i: i,
+		e:          router,
+		i:          i,
+		cfg:        cfg,
+		rateLimits: newRateLimits(cfg.rateLimits, cfg.rateLimitFile, cfg.logger, cfg.knownKey),
+	}
+	if cfg.idempotencyTTL > 0 {
+		r.idempotencyKeys = newIdempotencyCache(cfg.idempotencyTTL)
 	}
 
-	router.GET("/products", r.ListProducts)
-	router.GET("/products/:id", r.getProducts)
-	router.POST("/products", r.addProduct)
-	router.PUT("/products/:id", r.updateProduct)
-	router.DELETE("/products/:id", r.deleteProduct)
+	products := router.Group("/products", r.groupMiddleware(GroupProducts)...)
+	products.GET("", r.require(auth.PermProductsRead), r.ListProducts)
+	products.GET("/export", r.require(auth.PermProductsRead), r.exportProducts)
+	products.GET("/:id", r.require(auth.PermProductsRead), r.getProducts)
+	products.POST("", r.require(auth.PermProductsWrite), r.addProduct)
+	products.PUT("/:id", r.require(auth.PermProductsWrite), r.updateProduct)
+	products.DELETE("/:id", r.require(auth.PermProductsWrite), r.deleteProduct)
+	products.POST("/import", r.require(auth.PermProductsWrite), r.importProducts)
+	products.POST("/:id/stock", r.require(auth.PermStockAdjust), r.adjustStock)
+	// Custom methods like /products:batch, see customMethod
+	batch := append([]gin.HandlerFunc{customMethod("batch")}, r.groupMiddleware(GroupProducts)...)
+	router.POST("/products:method", append(batch, r.require(auth.PermProductsWrite), r.batchProducts)...)
 	// Basic metrics endpoint returning JSON format
 	// In production system, should be replaced with Prometheus Instrumentation
-	router.GET("/metrics", r.metricsHandler)
+	metrics := router.Group("/metrics", r.groupMiddleware(GroupMetrics)...)
+	metrics.GET("", r.require(auth.PermMetricsRead), r.metricsHandler)
+	// Without auth, require lets every caller through. The admin API and the
+	// audit log are never served to anonymous callers.
+	if cfg.audit != nil && !cfg.authEnabled() {
+		cfg.logger.Warn("Auth is disabled, not serving GET /audit")
+	}
+	if cfg.adminAPI && !cfg.authEnabled() {
+		cfg.logger.Error("Auth is disabled, not serving the admin API")
+	}
+	if cfg.audit != nil && cfg.authEnabled() {
+		auditGroup := router.Group("/audit", r.groupMiddleware(GroupAudit)...)
+		auditGroup.GET("", r.require(auth.PermAuditRead), r.getAudit)
+	}
+	if cfg.adminAPI && cfg.authEnabled() {
+		admin := router.Group("/admin", r.groupMiddleware(GroupAdmin)...)
+		r.adminRoutes(admin)
+		if cfg.keys != nil {
+			r.keyRoutes(admin)
+		}
+	}
 
 	return r
 }
 
+// groupMiddleware returns the handlers configured for a route group.
+// Rate limiting comes first, so that unauthenticated floods are limited too.
+// Auditing wraps authentication, so that rejected calls are recorded as well.
+// The tenant is resolved after authentication, it depends on the caller
+// identity. Idempotency keys are scoped to both, and only apply to the
+// products group: responses of the admin API, such as issued API keys, are
+// never stored.
+func (r *Router) groupMiddleware(group string) []gin.HandlerFunc {
+	handlers := []gin.HandlerFunc{r.rateLimits.middleware(group)}
+	if h := r.auditTrail(); h != nil {
+		handlers = append(handlers, h)
+	}
+	if h := r.authenticate(); h != nil {
+		handlers = append(handlers, h)
+	}
+	handlers = append(handlers, r.tenantScope())
+	if h := r.idempotency(); h != nil && group == GroupProducts {
+		handlers = append(handlers, h)
+	}
+	return handlers
+}
+
+// Shutdown releases resources held by the router, such as rate limiters.
+func (r Router) Shutdown() {
+	r.rateLimits.shutdown()
+}
+
 func (r Router) Run(addr ...string) error {
 	return r.e.Run(addr...)
 }
@@ -59,7 +184,7 @@ func (r Router) metricsHandler(c *gin.Context) {
 		return
 	}
 
-	stats := i.GetStats()
+	stats := i.GetStats(c.Request.Context())
 	if len(stats) == 0 {
 		c.JSON(http.StatusOK, ResponseFormat{Data: "No metrics available yet"})
 		return
@@ -105,6 +230,10 @@ func (r Router) ListProducts(c *gin.Context) {
 		Page:  page,
 		Limit: limit,
 	}
+	if limit == nil && page == nil && c.NegotiateFormat(gin.MIMEJSON, contentTypeNDJSON) == contentTypeNDJSON {
+		r.streamProducts(c)
+		return
+	}
 
 	products, meta, status, err := i.List(c.Request.Context(), params)
 	if err != nil {
@@ -117,7 +246,6 @@ func (r Router) ListProducts(c *gin.Context) {
 		return
 	}
 
-
 	c.JSON(http.StatusOK, ResponseFormat{
 		Data: products,
 		Meta: meta,
@@ -125,6 +253,32 @@ func (r Router) ListProducts(c *gin.Context) {
 
 }
 
+// Lines written between flushes of a streamed list or export
+const streamFlushInterval = 100
+
+// streamProducts writes the full list as one JSON product per line, reading
+// the products incrementally instead of building the list in memory.
+func (r Router) streamProducts(c *gin.Context) {
+	ctx := c.Request.Context()
+	c.Header("Content-Type", contentTypeNDJSON)
+	c.Status(http.StatusOK)
+	enc := json.NewEncoder(c.Writer)
+	written := 0
+	for product, err := range r.i.All(ctx) {
+		if err == nil {
+			err = enc.Encode(product)
+		}
+		if err != nil {
+			// The status is sent, the client sees a truncated list
+			observability.Logger(ctx).WarnContext(ctx, "Failed to stream products", "error", err)
+			return
+		}
+		if written++; written%streamFlushInterval == 0 {
+			c.Writer.Flush()
+		}
+	}
+}
+
 func (r Router) addProduct(c *gin.Context) {
 	i := r.i
 	var productReq inventory.CreateRequest
@@ -154,6 +308,11 @@ func (r Router) updateProduct(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, ResponseFormat{Error: "Invalid input"})
 		return
 	}
+	// Setting the stock directly is a stock adjustment as well
+	if updatedProduct.Stock != nil && !r.allowed(c, auth.PermStockAdjust) {
+		forbidden(c, auth.PermStockAdjust)
+		return
+	}
 	if status, err := i.Update(c.Request.Context(), id, updatedProduct); err != nil {
 		errMessage := "Failed to update product: " + err.Error()
 		c.JSON(status, ResponseFormat{Error: errMessage})
@@ -167,6 +326,73 @@ func (r Router) updateProduct(c *gin.Context) {
 	})
 }
 
+func (r Router) adjustStock(c *gin.Context) {
+	var req inventory.StockRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ResponseFormat{Error: "Invalid input"})
+		return
+	}
+	product, status, err := r.i.AdjustStock(c.Request.Context(), c.Param("id"), req.Delta)
+	if err != nil {
+		c.JSON(status, ResponseFormat{Error: "Failed to adjust stock: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, ResponseFormat{Data: product})
+}
+
+type batchRequest struct {
+	// All or nothing, the first failing operation rolls back the batch
+	Atomic     bool                `json:"atomic"`
+	Operations []inventory.BatchOp `json:"operations" binding:"required"`
+}
+
+type batchSummary struct {
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
+}
+
+// customMethod routes POST /products:<name>. Gin has no literal colons in
+// paths, so "/products:method" is a parameter route, and any other name is
+// not found.
+func customMethod(name string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Param("method") != ":"+name {
+			c.AbortWithStatusJSON(http.StatusNotFound, ResponseFormat{Error: "Not found"})
+			return
+		}
+		c.Next()
+	}
+}
+
+func (r Router) batchProducts(c *gin.Context) {
+	var req batchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ResponseFormat{Error: "Invalid input"})
+		return
+	}
+	for _, op := range req.Operations {
+		updates := op.Op == inventory.BatchUpdate || op.Op == inventory.BatchUpsert
+		if updates && op.Stock != nil && !r.allowed(c, auth.PermStockAdjust) {
+			forbidden(c, auth.PermStockAdjust)
+			return
+		}
+	}
+	results, status, err := r.i.Batch(c.Request.Context(), req.Operations, req.Atomic)
+	if err != nil {
+		c.JSON(status, ResponseFormat{Error: "Batch failed: " + err.Error(), Data: results})
+		return
+	}
+	var summary batchSummary
+	for _, res := range results {
+		if res.Error == "" {
+			summary.Succeeded++
+		} else {
+			summary.Failed++
+		}
+	}
+	c.JSON(http.StatusOK, ResponseFormat{Data: results, Meta: summary})
+}
+
 func (r Router) deleteProduct(c *gin.Context) {
 	i := r.i
 	id := c.Param("id")
@@ -2,14 +2,32 @@
 package api
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/jacobtrvl/inventory-management/internal/audit"
+	"github.com/jacobtrvl/inventory-management/internal/auth"
 	"github.com/jacobtrvl/inventory-management/internal/inventory"
 	"github.com/jacobtrvl/inventory-management/internal/store"
 	"github.com/jacobtrvl/inventory-management/pkg/observability"
+	"github.com/jacobtrvl/inventory-management/pkg/ratelimiter"
 )
 
 func TestAddAndGet(t *testing.T) {
@@ -38,7 +56,6 @@ func TestAddAndGet(t *testing.T) {
 		t.Fatalf("Expected response to contain product id 1, got %s", respBody)
 	}
 
-
 	req = httptest.NewRequest("GET", "/products", nil)
 	w = httptest.NewRecorder()
 	r.e.ServeHTTP(w, req)
@@ -52,3 +69,912 @@ func TestAddAndGet(t *testing.T) {
 	}
 
 }
+
+func TestAccessLog(t *testing.T) {
+	i := inventory.NewInventory(context.Background(),
+		"products", store.NewMemDb(), observability.NewMetricsCollector())
+	var buf bytes.Buffer
+	logger := observability.NewLogger(observability.LogConfig{Level: slog.LevelInfo}, &buf)
+	r := SetupRouter(context.Background(), i, WithLogger(logger))
+
+	req := httptest.NewRequest("GET", "/products/missing", nil)
+	req.Header.Set("X-Request-ID", "req-1")
+	w := httptest.NewRecorder()
+	r.e.ServeHTTP(w, req)
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("Expected a single JSON log line, got %q: %v", buf.String(), err)
+	}
+	if entry["request_id"] != "req-1" {
+		t.Fatalf("Expected request_id req-1, got %v", entry["request_id"])
+	}
+	if entry["route"] != "/products/:id" {
+		t.Fatalf("Expected route /products/:id, got %v", entry["route"])
+	}
+	if entry["status"] != float64(404) {
+		t.Fatalf("Expected status 404, got %v", entry["status"])
+	}
+	if w.Header().Get("X-Request-ID") != "req-1" {
+		t.Fatalf("Expected X-Request-ID req-1 echoed, got %q", w.Header().Get("X-Request-ID"))
+	}
+	if entry["level"] != "WARN" {
+		t.Fatalf("Expected level WARN, got %v", entry["level"])
+	}
+	for _, k := range []string{"latency", "client_ip", "bytes"} {
+		if _, ok := entry[k]; !ok {
+			t.Fatalf("Expected %s in access log, got %v", k, entry)
+		}
+	}
+}
+
+func TestRequestIDPropagation(t *testing.T) {
+	i := inventory.NewInventory(context.Background(),
+		"products", store.NewMemDb(), observability.NewMetricsCollector())
+	var buf bytes.Buffer
+	logger := observability.NewLogger(observability.LogConfig{Level: slog.LevelDebug}, &buf)
+	r := SetupRouter(context.Background(), i, WithLogger(logger))
+
+	body := `{"id":"1","name":"Test Product","price":9.99,"stock":100}`
+	req := httptest.NewRequest("POST", "/products", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	r.e.ServeHTTP(w, req)
+
+	id := w.Header().Get("X-Request-ID")
+	if id == "" {
+		t.Fatalf("Expected generated X-Request-ID in response")
+	}
+
+	// Both the inventory log and the access log must carry the request ID
+	found := map[string]bool{}
+	sc := bufio.NewScanner(&buf)
+	for sc.Scan() {
+		var entry map[string]any
+		if err := json.Unmarshal(sc.Bytes(), &entry); err != nil {
+			continue
+		}
+		if entry["request_id"] == id {
+			found[entry["msg"].(string)] = true
+		}
+	}
+	if !found["Product added"] || !found["request"] {
+		t.Fatalf("Expected request ID %s in inventory and access logs, got %s", id, buf.String())
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	i := inventory.NewInventory(context.Background(),
+		"products", store.NewMemDb(), observability.NewMetricsCollector())
+	r := SetupRouter(context.Background(), i, WithRateLimits(map[string]RateLimitConfig{
+		GroupProducts: {Rate: 1, Burst: 2, Key: "header:X-Client-ID"},
+	}))
+	defer r.Shutdown()
+
+	get := func(client, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("X-Client-ID", client)
+		w := httptest.NewRecorder()
+		r.e.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("a", "/products")
+	if w.Code != 200 || w.Header().Get("RateLimit-Limit") != "2" || w.Header().Get("RateLimit-Remaining") != "1" {
+		t.Fatalf("Expected 200 with limit 2 and 1 remaining, got %d %v", w.Code, w.Header())
+	}
+	if w = get("a", "/products"); w.Code != 200 {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	w = get("a", "/products")
+	if w.Code != 429 {
+		t.Fatalf("Expected status 429, got %d", w.Code)
+	}
+	if w.Header().Get("Retry-After") != "1" || w.Header().Get("RateLimit-Remaining") != "0" {
+		t.Fatalf("Expected Retry-After 1 and 0 remaining, got %v", w.Header())
+	}
+
+	// Other clients and route groups without limits are not affected
+	if w = get("b", "/products"); w.Code != 200 {
+		t.Fatalf("Expected status 200 for another client, got %d", w.Code)
+	}
+	if w = get("a", "/metrics"); w.Code != 200 {
+		t.Fatalf("Expected status 200 for metrics, got %d", w.Code)
+	}
+}
+
+func TestRateLimitClientKey(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMemDb()
+	i := inventory.NewInventory(ctx, "products", db, observability.NewMetricsCollector())
+	keys, err := auth.NewKeyStore(db, auth.KeysTable)
+	if err != nil {
+		t.Fatalf("Failed to create key store: %v", err)
+	}
+	if _, err := keys.Bootstrap(ctx, "secret", "bootstrap", []string{auth.RoleAdmin}); err != nil {
+		t.Fatalf("Failed to bootstrap key: %v", err)
+	}
+	r := SetupRouter(ctx, i, WithAPIKeys(keys), WithRateLimits(map[string]RateLimitConfig{
+		GroupProducts: {Rate: 1, Burst: 1, Key: KeyByAPIKey},
+	}))
+	defer r.Shutdown()
+
+	get := func(key, forwardedFor string) int {
+		req := httptest.NewRequest("GET", "/products", nil)
+		req.Header.Set("Authorization", "Bearer "+key)
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		w := httptest.NewRecorder()
+		r.e.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Made up keys and forwarded addresses share the limit of the connection IP
+	if code := get("random-1", "10.0.0.1"); code != 401 {
+		t.Fatalf("Expected status 401, got %d", code)
+	}
+	if code := get("random-2", "10.0.0.2"); code != 429 {
+		t.Fatalf("Expected status 429 for another made up key, got %d", code)
+	}
+	// A valid key has its own limit
+	if code := get("secret", "10.0.0.3"); code != 200 {
+		t.Fatalf("Expected status 200 for a valid key, got %d", code)
+	}
+	if code := get("secret", "10.0.0.4"); code != 429 {
+		t.Fatalf("Expected status 429 for the second request of a valid key, got %d", code)
+	}
+
+	// JWTs are not verified before the limit, which could fetch the JWKS.
+	// Their callers share the limit of the IP.
+	v, sign := testJWT(t)
+	r = SetupRouter(ctx, i, WithAPIKeys(keys), WithJWT(v), WithRateLimits(map[string]RateLimitConfig{
+		GroupProducts: {Rate: 1, Burst: 1, Key: KeyByAPIKey},
+	}))
+	defer r.Shutdown()
+	if code := get(sign("user-1", auth.RoleViewer), ""); code != 200 {
+		t.Fatalf("Expected status 200 for a JWT, got %d", code)
+	}
+	if code := get(sign("user-2", auth.RoleViewer), ""); code != 429 {
+		t.Fatalf("Expected status 429 for another JWT from the same IP, got %d", code)
+	}
+	if code := get("secret", ""); code != 200 {
+		t.Fatalf("Expected status 200 for a valid key, got %d", code)
+	}
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	i := inventory.NewInventory(context.Background(),
+		"products", store.NewMemDb(), observability.NewMetricsCollector())
+	l := ratelimiter.NewAdaptiveLimiter(ratelimiter.WithInitialLimit(1), ratelimiter.WithLimitBounds(1, 1))
+	r := SetupRouter(context.Background(), i, WithConcurrencyLimiter(l))
+
+	// Occupy the only slot, as a slow request would
+	token, ok := l.Acquire()
+	if !ok {
+		t.Fatal("Expected to acquire the only slot")
+	}
+	w := httptest.NewRecorder()
+	r.e.ServeHTTP(w, httptest.NewRequest("GET", "/products", nil))
+	if w.Code != 503 || w.Header().Get("Retry-After") != "1" {
+		t.Fatalf("Expected 503 with Retry-After, got %d %v", w.Code, w.Header())
+	}
+
+	token.OnSuccess()
+	w = httptest.NewRecorder()
+	r.e.ServeHTTP(w, httptest.NewRequest("GET", "/products", nil))
+	if w.Code != 200 {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if l.InFlight() != 0 {
+		t.Fatalf("Expected the slot to be released, %d in flight", l.InFlight())
+	}
+
+	// Panicking handlers release their slot as well
+	r.e.GET("/panic", func(*gin.Context) { panic("handler") })
+	w = httptest.NewRecorder()
+	r.e.ServeHTTP(w, httptest.NewRequest("GET", "/panic", nil))
+	if w.Code != 500 || l.InFlight() != 0 {
+		t.Fatalf("Expected 500 and the slot released, got %d with %d in flight", w.Code, l.InFlight())
+	}
+	w = httptest.NewRecorder()
+	r.e.ServeHTTP(w, httptest.NewRequest("GET", "/products", nil))
+	if w.Code != 200 {
+		t.Fatalf("Expected status 200 after a panic, got %d", w.Code)
+	}
+}
+
+func TestAdminRateLimits(t *testing.T) {
+	i := inventory.NewInventory(context.Background(),
+		"products", store.NewMemDb(), observability.NewMetricsCollector())
+	path := filepath.Join(t.TempDir(), "ratelimits.json")
+	keys, err := auth.NewKeyStore(store.NewMemDb(), auth.KeysTable)
+	if err != nil {
+		t.Fatalf("Failed to create key store: %v", err)
+	}
+	if _, err := keys.Bootstrap(context.Background(), "admin-secret", "bootstrap", []string{auth.RoleAdmin}); err != nil {
+		t.Fatalf("Failed to bootstrap admin key: %v", err)
+	}
+	r := SetupRouter(context.Background(), i, WithAdminAPI(), WithAPIKeys(keys), WithRateLimitFile(path),
+		WithRateLimits(map[string]RateLimitConfig{
+			GroupProducts: {Rate: 1, Key: "header:X-Client-ID"},
+		}))
+	defer r.Shutdown()
+
+	do := func(method, path, client, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("X-Client-ID", client)
+		req.Header.Set("Authorization", "Bearer admin-secret")
+		w := httptest.NewRecorder()
+		r.e.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := do("GET", "/products", "a", ""); w.Code != 200 {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if w := do("GET", "/products", "a", ""); w.Code != 429 {
+		t.Fatalf("Expected status 429, got %d", w.Code)
+	}
+
+	// Raising the burst applies to the existing limiter of client a
+	w := do("PUT", "/admin/ratelimits/products", "", `{"rate":1,"burst":3,"key":"header:X-Client-ID"}`)
+	if w.Code != 200 {
+		t.Fatalf("Expected status 200, got %d %s", w.Code, w.Body.String())
+	}
+	if w = do("GET", "/products", "a", ""); w.Header().Get("RateLimit-Limit") != "3" {
+		t.Fatalf("Expected limit 3, got %v", w.Header())
+	}
+
+	// Client b gets its own limit
+	if w = do("PUT", "/admin/ratelimits/products/clients/header:b", "", `{"rate":100}`); w.Code != 200 {
+		t.Fatalf("Expected status 200, got %d %s", w.Code, w.Body.String())
+	}
+	for n := 0; n < 10; n++ {
+		if w = do("GET", "/products", "b", ""); w.Code != 200 {
+			t.Fatalf("Expected status 200 for request %d of client b, got %d", n, w.Code)
+		}
+	}
+
+	// Changes are persisted
+	limits, err := LoadRateLimits(path)
+	if err != nil {
+		t.Fatalf("Failed to load persisted limits: %v", err)
+	}
+	if limits[GroupProducts].Burst != 3 || limits[GroupProducts].Clients["header:b"].Rate != 100 {
+		t.Fatalf("Unexpected persisted limits %+v", limits)
+	}
+
+	if w = do("PUT", "/admin/ratelimits/unknown", "", `{"rate":1}`); w.Code != 404 {
+		t.Fatalf("Expected status 404 for an unknown group, got %d", w.Code)
+	}
+	if w = do("PUT", "/admin/ratelimits/metrics", "", `{"rate":0}`); w.Code != 400 {
+		t.Fatalf("Expected status 400 for an invalid limit, got %d", w.Code)
+	}
+	if w = do("DELETE", "/admin/ratelimits/metrics", "", ""); w.Code != 404 {
+		t.Fatalf("Expected status 404 for a group without limit, got %d", w.Code)
+	}
+
+	// Removing the limit lifts it right away
+	if w = do("DELETE", "/admin/ratelimits/products", "", ""); w.Code != 204 {
+		t.Fatalf("Expected status 204, got %d", w.Code)
+	}
+	if w = do("GET", "/products", "a", ""); w.Code != 200 || w.Header().Get("RateLimit-Limit") != "" {
+		t.Fatalf("Expected status 200 without rate limit headers, got %d %v", w.Code, w.Header())
+	}
+	if limits, _ = LoadRateLimits(path); len(limits) != 0 {
+		t.Fatalf("Expected no persisted limits, got %+v", limits)
+	}
+}
+
+func TestAdminRequiresAuth(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMemDb()
+	i := inventory.NewInventory(ctx, "products", db, observability.NewMetricsCollector())
+	log, err := audit.NewLog(db, audit.Table)
+	if err != nil {
+		t.Fatalf("Failed to create audit log: %v", err)
+	}
+	r := SetupRouter(ctx, i, WithAdminAPI(), WithAuditLog(log),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	defer r.Shutdown()
+
+	for _, tt := range []struct{ method, path string }{
+		{"GET", "/admin/ratelimits"},
+		{"PUT", "/admin/ratelimits/products"},
+		{"GET", "/audit"},
+	} {
+		w := httptest.NewRecorder()
+		r.e.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, strings.NewReader(`{"rate":1}`)))
+		if w.Code != 404 {
+			t.Fatalf("Expected status 404 for %s %s without auth, got %d", tt.method, tt.path, w.Code)
+		}
+	}
+}
+
+func TestAPIKeyAuth(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMemDb()
+	i := inventory.NewInventory(ctx, "products", db, observability.NewMetricsCollector())
+	keys, err := auth.NewKeyStore(db, auth.KeysTable)
+	if err != nil {
+		t.Fatalf("Failed to create key store: %v", err)
+	}
+	if _, err := keys.Bootstrap(ctx, "admin-secret", "bootstrap", []string{auth.RoleAdmin}); err != nil {
+		t.Fatalf("Failed to bootstrap admin key: %v", err)
+	}
+	r := SetupRouter(ctx, i, WithAPIKeys(keys), WithAdminAPI())
+	defer r.Shutdown()
+
+	do := func(method, path, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		w := httptest.NewRecorder()
+		r.e.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("GET", "/products", "", "")
+	if w.Code != 401 || w.Header().Get("WWW-Authenticate") == "" {
+		t.Fatalf("Expected 401 with WWW-Authenticate, got %d %v", w.Code, w.Header())
+	}
+	if w = do("GET", "/products", "wrong", ""); w.Code != 401 {
+		t.Fatalf("Expected status 401 for an invalid key, got %d", w.Code)
+	}
+
+	// Issue a read only key
+	w = do("POST", "/admin/keys", "admin-secret", `{"name":"reader","roles":["viewer"]}`)
+	if w.Code != 201 {
+		t.Fatalf("Expected status 201, got %d %s", w.Code, w.Body.String())
+	}
+	var issued struct {
+		Data struct {
+			ID  string `json:"id"`
+			Key string `json:"key"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &issued); err != nil || issued.Data.Key == "" {
+		t.Fatalf("Expected the issued key in the response, got %s", w.Body.String())
+	}
+	reader := issued.Data.Key
+
+	if w = do("GET", "/products", reader, ""); w.Code != 200 {
+		t.Fatalf("Expected status 200 for a read key, got %d", w.Code)
+	}
+	if w = do("POST", "/products", reader, `{"id":"1","name":"P","price":1,"stock":1}`); w.Code != 403 {
+		t.Fatalf("Expected status 403 for a write with a read key, got %d", w.Code)
+	}
+	if w = do("GET", "/admin/keys", reader, ""); w.Code != 403 {
+		t.Fatalf("Expected status 403 for admin endpoints, got %d", w.Code)
+	}
+	if w = do("GET", "/admin/keys", "admin-secret", ""); w.Code != 200 || strings.Contains(w.Body.String(), reader) {
+		t.Fatalf("Expected key list without secrets, got %d %s", w.Code, w.Body.String())
+	}
+	// Issued keys are never stored for replay
+	for range 2 {
+		req := httptest.NewRequest("POST", "/admin/keys", strings.NewReader(`{"name":"retry","roles":["viewer"]}`))
+		req.Header.Set("Authorization", "Bearer admin-secret")
+		req.Header.Set("Idempotency-Key", "k1")
+		w = httptest.NewRecorder()
+		r.e.ServeHTTP(w, req)
+		if w.Code != 201 || w.Header().Get("Idempotent-Replayed") != "" {
+			t.Fatalf("Expected a new key for every request, got %d %v", w.Code, w.Header())
+		}
+	}
+	if w = do("POST", "/admin/keys", "admin-secret", `{"name":"x","roles":["root"]}`); w.Code != 400 {
+		t.Fatalf("Expected status 400 for an unknown role, got %d", w.Code)
+	}
+
+	// Revoked keys are rejected right away
+	if w = do("DELETE", "/admin/keys/"+issued.Data.ID, "admin-secret", ""); w.Code != 200 {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if w = do("GET", "/products", reader, ""); w.Code != 401 {
+		t.Fatalf("Expected status 401 for a revoked key, got %d", w.Code)
+	}
+}
+
+// testJWT returns a validator of tokens for the audience "inventory", and a
+// function signing tokens for it.
+func testJWT(t *testing.T) (*auth.JWTValidator, func(sub string, roles ...string) string) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	set := fmt.Sprintf(`{"keys":[{"kty":"OKP","crv":"Ed25519","kid":"k1","x":"%s"}]}`,
+		base64.RawURLEncoding.EncodeToString(pub))
+	if err := os.WriteFile(path, []byte(set), 0o600); err != nil {
+		t.Fatalf("Failed to write JWKS: %v", err)
+	}
+	v := auth.NewJWTValidator(auth.NewKeySet(path), auth.JWTConfig{Audience: "inventory"})
+	return v, func(sub string, roles ...string) string {
+		tok := jwt.NewWithClaims(jwt.SigningMethodEdDSA, jwt.MapClaims{
+			"sub":   sub,
+			"aud":   "inventory",
+			"exp":   time.Now().Add(time.Minute).Unix(),
+			"roles": roles,
+		})
+		tok.Header["kid"] = "k1"
+		s, err := tok.SignedString(priv)
+		if err != nil {
+			t.Fatalf("Failed to sign token: %v", err)
+		}
+		return s
+	}
+}
+
+func TestJWTAuth(t *testing.T) {
+	ctx := context.Background()
+	i := inventory.NewInventory(ctx, "products", store.NewMemDb(), observability.NewMetricsCollector())
+	v, sign := testJWT(t)
+	r := SetupRouter(ctx, i, WithJWT(v))
+	defer r.Shutdown()
+
+	token := func(roles ...string) string {
+		return sign("user-1", roles...)
+	}
+	do := func(method, path, token string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(`{"id":"1","name":"P","price":1,"stock":1}`))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.e.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	reader := token(auth.RoleViewer)
+	if code := do("GET", "/products", reader); code != 200 {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	if code := do("POST", "/products", reader); code != 403 {
+		t.Fatalf("Expected status 403 without the write role, got %d", code)
+	}
+	if code := do("POST", "/products", token(auth.RoleEditor)); code != 201 {
+		t.Fatalf("Expected status 201, got %d", code)
+	}
+	if code := do("GET", "/products", reader+"x"); code != 401 {
+		t.Fatalf("Expected status 401 for a bad signature, got %d", code)
+	}
+	// API keys are not enabled
+	if code := do("GET", "/products", "imk_key"); code != 401 {
+		t.Fatalf("Expected status 401 for an API key, got %d", code)
+	}
+}
+
+func TestRBAC(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMemDb()
+	i := inventory.NewInventory(ctx, "products", db, observability.NewMetricsCollector())
+	keys, err := auth.NewKeyStore(db, auth.KeysTable)
+	if err != nil {
+		t.Fatalf("Failed to create key store: %v", err)
+	}
+	issue := func(name string, roles ...string) string {
+		secret, _, err := keys.Issue(ctx, name, "", roles, 0)
+		if err != nil {
+			t.Fatalf("Failed to issue key: %v", err)
+		}
+		return secret
+	}
+	editor := issue("editor", auth.RoleEditor)
+	manager := issue("manager", auth.RoleInventoryManager)
+	auditor := issue("auditor", "auditor")
+	unknown := issue("unknown", "root")
+
+	policy := auth.DefaultPolicy()
+	policy.Roles["auditor"] = []auth.Permission{auth.PermMetricsRead}
+	r := SetupRouter(ctx, i, WithAPIKeys(keys), WithPolicy(policy))
+	defer r.Shutdown()
+
+	do := func(method, path, key, body string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+key)
+		w := httptest.NewRecorder()
+		r.e.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	tests := []struct {
+		name         string
+		method, path string
+		key, body    string
+		want         int
+	}{
+		{"editor adds", "POST", "/products", editor, `{"id":"1","name":"P","price":1,"stock":5}`, 201},
+		{"editor renames", "PUT", "/products/1", editor, `{"name":"Q"}`, 200},
+		{"editor cannot set stock", "PUT", "/products/1", editor, `{"stock":50}`, 403},
+		{"editor cannot adjust stock", "POST", "/products/1/stock", editor, `{"delta":1}`, 403},
+		{"manager sets stock", "PUT", "/products/1", manager, `{"stock":50}`, 200},
+		{"manager adjusts stock", "POST", "/products/1/stock", manager, `{"delta":-20}`, 200},
+		{"stock cannot drop below zero", "POST", "/products/1/stock", manager, `{"delta":-31}`, 409},
+		{"editor cannot upsert stock", "POST", "/products/import?mode=upsert", editor, "id,stock\n1,50\n", 403},
+		{"editor imports new products with stock", "POST", "/products/import", editor, "id,stock\n2,50\n", 200},
+		{"custom role reads metrics", "GET", "/metrics", auditor, "", 200},
+		{"custom role has nothing else", "GET", "/products", auditor, "", 403},
+		{"unknown role is denied", "GET", "/products", unknown, "", 403},
+	}
+	for _, tt := range tests {
+		if got := do(tt.method, tt.path, tt.key, tt.body); got != tt.want {
+			t.Errorf("%s: expected status %d, got %d", tt.name, tt.want, got)
+		}
+	}
+	product, _, err := i.Get(ctx, "1")
+	if err != nil || product.Stock != 30 {
+		t.Fatalf("Expected stock 30, got %d %v", product.Stock, err)
+	}
+}
+
+func TestTenantIsolation(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMemDb()
+	i := inventory.NewInventory(ctx, "products", db, observability.NewMetricsCollector())
+	defer i.Shutdown()
+	keys, err := auth.NewKeyStore(db, auth.KeysTable)
+	if err != nil {
+		t.Fatalf("Failed to create key store: %v", err)
+	}
+	if _, err := keys.Bootstrap(ctx, "operator", "bootstrap", []string{auth.RoleAdmin}); err != nil {
+		t.Fatalf("Failed to bootstrap admin key: %v", err)
+	}
+	r := SetupRouter(ctx, i, WithAPIKeys(keys), WithAdminAPI())
+	defer r.Shutdown()
+
+	do := func(method, path, key, tenantID, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+key)
+		if tenantID != "" {
+			req.Header.Set("X-Tenant-ID", tenantID)
+		}
+		w := httptest.NewRecorder()
+		r.e.ServeHTTP(w, req)
+		return w
+	}
+	issue := func(key, body string) string {
+		w := do("POST", "/admin/keys", key, "", body)
+		var issued struct {
+			Data struct {
+				Key string `json:"key"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &issued); w.Code != 201 || err != nil {
+			t.Fatalf("Expected status 201, got %d %s", w.Code, w.Body.String())
+		}
+		return issued.Data.Key
+	}
+	// The operator issues keys for the tenants
+	adminA := issue("operator", `{"name":"a","roles":["admin"],"tenant":"brand-a"}`)
+	editorB := issue("operator", `{"name":"b","roles":["editor"],"tenant":"brand-b"}`)
+
+	if w := do("POST", "/products", adminA, "", `{"id":"1","name":"A"}`); w.Code != 201 {
+		t.Fatalf("Expected status 201, got %d", w.Code)
+	}
+	if w := do("GET", "/products/1", editorB, "", ""); w.Code != 404 {
+		t.Fatalf("Expected status 404 for a product of another tenant, got %d", w.Code)
+	}
+	if w := do("GET", "/products/1", editorB, "brand-a", ""); w.Code != 403 {
+		t.Fatalf("Expected status 403 for another tenant in the header, got %d", w.Code)
+	}
+	if w := do("GET", "/products/1", adminA, "brand-a", ""); w.Code != 200 {
+		t.Fatalf("Expected status 200 with the own tenant in the header, got %d", w.Code)
+	}
+	if w := do("GET", "/products", "operator", "", ""); w.Code != 200 || strings.Contains(w.Body.String(), `"id":"1"`) {
+		t.Fatalf("Expected no products in the default tenant, got %d %s", w.Code, w.Body.String())
+	}
+
+	// Tenant admins manage the keys of their tenant only
+	if w := do("POST", "/admin/keys", adminA, "", `{"name":"x","roles":["viewer"],"tenant":"brand-b"}`); w.Code != 403 {
+		t.Fatalf("Expected status 403 for a key of another tenant, got %d", w.Code)
+	}
+	issue(adminA, `{"name":"a2","roles":["viewer"]}`)
+	w := do("GET", "/admin/keys", adminA, "", "")
+	var list struct {
+		Data []auth.APIKey `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil || len(list.Data) != 2 {
+		t.Fatalf("Expected the 2 keys of brand-a, got %s", w.Body.String())
+	}
+	for _, k := range list.Data {
+		if k.Tenant != "brand-a" {
+			t.Fatalf("Expected only keys of brand-a, got %+v", k)
+		}
+	}
+
+	// Rate limits are shared by all tenants, only the operator manages them
+	for _, tt := range []struct{ method, path string }{
+		{"GET", "/admin/ratelimits"},
+		{"PUT", "/admin/ratelimits/products"},
+		{"DELETE", "/admin/ratelimits/products"},
+		{"PUT", "/admin/ratelimits/products/clients/x"},
+	} {
+		if w := do(tt.method, tt.path, adminA, "", `{"rate":1}`); w.Code != 403 {
+			t.Fatalf("Expected status 403 for %s %s by a tenant admin, got %d", tt.method, tt.path, w.Code)
+		}
+	}
+	if w := do("GET", "/admin/ratelimits", "operator", "", ""); w.Code != 200 {
+		t.Fatalf("Expected status 200 for the operator, got %d", w.Code)
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMemDb()
+	i := inventory.NewInventory(ctx, "products", db, observability.NewMetricsCollector())
+	keys, err := auth.NewKeyStore(db, auth.KeysTable)
+	if err != nil {
+		t.Fatalf("Failed to create key store: %v", err)
+	}
+	log, err := audit.NewLog(db, audit.Table)
+	if err != nil {
+		t.Fatalf("Failed to create audit log: %v", err)
+	}
+	editor, editorKey, _ := keys.Issue(ctx, "editor", "", []string{auth.RoleEditor}, 0)
+	admin, _, _ := keys.Issue(ctx, "admin", "", []string{auth.RoleAdmin}, 0)
+	r := SetupRouter(ctx, i, WithAPIKeys(keys), WithAuditLog(log))
+	defer r.Shutdown()
+
+	do := func(method, path, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+key)
+		req.Header.Set("X-Request-ID", "req-"+method)
+		w := httptest.NewRecorder()
+		r.e.ServeHTTP(w, req)
+		return w
+	}
+	do("POST", "/products", editor, `{"id":"1","name":"A","stock":5}`)
+	do("PUT", "/products/1", editor, `{"name":"B"}`)
+	do("POST", "/products/1/stock", editor, `{"delta":1}`)
+	do("DELETE", "/products/1", "wrong", "")
+	do("GET", "/products/1", editor, "")
+
+	w := do("GET", "/audit", editor, "")
+	if w.Code != 403 {
+		t.Fatalf("Expected status 403 without audit:read, got %d", w.Code)
+	}
+	var resp struct {
+		Data []struct {
+			audit.Record
+			Before *inventory.Product `json:"before"`
+			After  *inventory.Product `json:"after"`
+		} `json:"data"`
+	}
+	w = do("GET", "/audit?resource_id=1", admin, "")
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || len(resp.Data) != 4 {
+		t.Fatalf("Expected 4 audit records for product 1, got %d %s", w.Code, w.Body.String())
+	}
+	create, update, adjust, del := resp.Data[0], resp.Data[1], resp.Data[2], resp.Data[3]
+	if create.Action != "product.create" || create.Before != nil || create.After == nil || create.After.Name != "A" ||
+		create.Actor.ID != editorKey.ID || create.Tenant != "default" || create.RequestID != "req-POST" ||
+		create.ClientIP == "" || create.Outcome != audit.OutcomeSuccess {
+		t.Fatalf("Unexpected create record %+v", create)
+	}
+	if update.Action != "product.update" || update.Before.Name != "A" || update.After.Name != "B" {
+		t.Fatalf("Expected before and after snapshots, got %+v %+v", update.Before, update.After)
+	}
+	if adjust.Action != "stock.adjust" || adjust.Outcome != audit.OutcomeDenied || adjust.After != nil {
+		t.Fatalf("Expected a denied stock adjustment, got %+v", adjust)
+	}
+	if del.Action != "product.delete" || del.Outcome != audit.OutcomeDenied || del.Actor.ID != "" || del.Status != 401 {
+		t.Fatalf("Expected an anonymous denied delete, got %+v", del)
+	}
+
+	w = do("GET", "/audit?format=ndjson&outcome=success", admin, "")
+	if w.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("Expected NDJSON, got %q", w.Header().Get("Content-Type"))
+	}
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 successful records, got %q", w.Body.String())
+	}
+	for _, line := range lines {
+		var rec audit.Record
+		if err := json.Unmarshal([]byte(line), &rec); err != nil || rec.Outcome != audit.OutcomeSuccess {
+			t.Fatalf("Expected a successful record per line, got %q", line)
+		}
+	}
+	if w = do("GET", "/audit?since=yesterday", admin, ""); w.Code != 400 {
+		t.Fatalf("Expected status 400 for an invalid time, got %d", w.Code)
+	}
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	ctx := context.Background()
+	i := inventory.NewInventory(ctx, "products", store.NewMemDb(), observability.NewMetricsCollector())
+	r := SetupRouter(ctx, i, WithIdempotencyTTL(time.Hour))
+	defer r.Shutdown()
+	now := time.Unix(1000, 0)
+	r.idempotencyKeys.now = func() time.Time { return now }
+
+	post := func(key, tenantID, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/products", strings.NewReader(body))
+		req.Header.Set("Idempotency-Key", key)
+		req.Header.Set("X-Tenant-ID", tenantID)
+		w := httptest.NewRecorder()
+		r.e.ServeHTTP(w, req)
+		return w
+	}
+	count := func() int {
+		products, _, _, _ := i.List(ctx, inventory.ListParams{})
+		return len(products)
+	}
+
+	first := post("k1", "", `{"name":"A"}`)
+	if first.Code != 201 {
+		t.Fatalf("Expected status 201, got %d", first.Code)
+	}
+	retry := post("k1", "", `{"name":"A"}`)
+	if retry.Code != 201 || retry.Body.String() != first.Body.String() || retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("Expected the first response replayed, got %d %s", retry.Code, retry.Body.String())
+	}
+	if n := count(); n != 1 {
+		t.Fatalf("Expected a single product, got %d", n)
+	}
+	if w := post("k1", "", `{"name":"B"}`); w.Code != 422 {
+		t.Fatalf("Expected status 422 for a reused key, got %d", w.Code)
+	}
+	// Keys are scoped to the tenant
+	if w := post("k1", "brand-a", `{"name":"A"}`); w.Code != 201 || w.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("Expected a new product in another tenant, got %d", w.Code)
+	}
+
+	// A duplicate of a request still in flight, reserved as the first request would
+	r.idempotencyKeys.begin("default\x00\x00k2", requestFingerprint("/products", []byte(`{"name":"C"}`)))
+	if w := post("k2", "", `{"name":"C"}`); w.Code != 409 {
+		t.Fatalf("Expected status 409 while in flight, got %d", w.Code)
+	}
+
+	// Expired keys create a new product
+	now = now.Add(time.Hour)
+	if w := post("k1", "", `{"name":"A"}`); w.Code != 201 || w.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("Expected a new product after expiry, got %d", w.Code)
+	}
+	if n := count(); n != 2 {
+		t.Fatalf("Expected 2 products, got %d", n)
+	}
+}
+
+func TestBatchProducts(t *testing.T) {
+	ctx := context.Background()
+	i := inventory.NewInventory(ctx, "products", store.NewMemDb(), observability.NewMetricsCollector())
+	r := SetupRouter(ctx, i)
+	defer r.Shutdown()
+	post := func(path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.e.ServeHTTP(w, httptest.NewRequest("POST", path, strings.NewReader(body)))
+		return w
+	}
+
+	w := post("/products:batch", `{"operations":[
+		{"op":"create","id":"1","name":"A","stock":1},
+		{"op":"create","id":"1","name":"A"},
+		{"op":"update","id":"1","price":2.5}]}`)
+	var resp struct {
+		Data []inventory.BatchResult `json:"data"`
+		Meta batchSummary            `json:"meta"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); w.Code != 200 || err != nil {
+		t.Fatalf("Expected status 200, got %d %s", w.Code, w.Body.String())
+	}
+	if resp.Meta != (batchSummary{Succeeded: 2, Failed: 1}) || resp.Data[1].Status != 409 {
+		t.Fatalf("Expected the duplicate to fail, got %s", w.Body.String())
+	}
+
+	w = post("/products:batch", `{"atomic":true,"operations":[
+		{"op":"delete","id":"1"},
+		{"op":"delete","id":"1"}]}`)
+	if w.Code != 404 || !strings.Contains(w.Body.String(), `"index":1`) {
+		t.Fatalf("Expected the failing operation with status 404, got %d %s", w.Code, w.Body.String())
+	}
+	if p, _, err := i.Get(ctx, "1"); err != nil || p.Price != 2.5 {
+		t.Fatalf("Expected the product to survive the rolled back batch, got %+v %v", p, err)
+	}
+
+	if w = post("/products:batch", `{"operations":[]}`); w.Code != 400 {
+		t.Fatalf("Expected status 400 for an empty batch, got %d", w.Code)
+	}
+	if w = post("/products:unknown", `{}`); w.Code != 404 {
+		t.Fatalf("Expected status 404 for an unknown method, got %d", w.Code)
+	}
+	if w = post("/products", `{"id":"2","name":"B"}`); w.Code != 201 {
+		t.Fatalf("Expected single creates to keep working, got %d", w.Code)
+	}
+}
+
+func TestCSVImportExport(t *testing.T) {
+	ctx := context.Background()
+	i := inventory.NewInventory(ctx, "products", store.NewMemDb(), observability.NewMetricsCollector())
+	r := SetupRouter(ctx, i)
+	defer r.Shutdown()
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.e.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+
+	w := do("POST", "/products/import?mapping=SKU:id,Title:name,Qty:stock", "SKU,Title,Qty,Color\n1,Pen,10,red\n2,Ink,x,blue\n")
+	var resp struct {
+		Data inventory.ImportReport `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); w.Code != 422 || err != nil {
+		t.Fatalf("Expected status 422, got %d %s", w.Code, w.Body.String())
+	}
+	if len(resp.Data.Errors) != 1 || resp.Data.Errors[0].Line != 3 {
+		t.Fatalf("Expected an error on line 3, got %s", w.Body.String())
+	}
+	if _, _, err := i.Get(ctx, "1"); err == nil {
+		t.Fatal("Expected a failed import to write nothing")
+	}
+
+	if w = do("POST", "/products/import?dry_run=true", "id,name\n1,Pen\n"); w.Code != 200 || !strings.Contains(w.Body.String(), `"created":1`) {
+		t.Fatalf("Expected a dry run report, got %d %s", w.Code, w.Body.String())
+	}
+	if _, _, err := i.Get(ctx, "1"); err == nil {
+		t.Fatal("Expected a dry run to write nothing")
+	}
+	if w = do("POST", "/products/import", "id,name,price\n1,Pen,1.5\n2,Ink,3\n"); w.Code != 200 {
+		t.Fatalf("Expected status 200, got %d %s", w.Code, w.Body.String())
+	}
+	if w = do("POST", "/products/import?mode=upsert", "id,price\n1,2\n3,4\n"); w.Code != 200 || !strings.Contains(w.Body.String(), `"updated":1`) {
+		t.Fatalf("Expected an upsert report, got %d %s", w.Code, w.Body.String())
+	}
+	if w = do("POST", "/products/import", "id,name\n1,Pen\n"); w.Code != 422 || !strings.Contains(w.Body.String(), `"status":409`) {
+		t.Fatalf("Expected insert only to reject existing products, got %d %s", w.Code, w.Body.String())
+	}
+	if w = do("POST", "/products/import", "color\nred\n"); w.Code != 400 {
+		t.Fatalf("Expected status 400 without product columns, got %d", w.Code)
+	}
+
+	w = do("GET", "/products/export?format=csv", "")
+	if w.Code != 200 || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/csv") {
+		t.Fatalf("Expected a CSV export, got %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if len(lines) != 4 || lines[0] != "id,name,price,stock,created_at,updated_at" || !strings.HasPrefix(lines[1], "1,Pen,2,0,") {
+		t.Fatalf("Unexpected export %q", w.Body.String())
+	}
+	if w = do("GET", "/products/export?format=xml", ""); w.Code != 400 {
+		t.Fatalf("Expected status 400 for an unknown format, got %d", w.Code)
+	}
+}
+
+func TestListNDJSON(t *testing.T) {
+	ctx := context.Background()
+	i := inventory.NewInventory(ctx, "products", store.NewMemDb(), observability.NewMetricsCollector())
+	r := SetupRouter(ctx, i)
+	defer r.Shutdown()
+	for _, id := range []string{"1", "2", "3"} {
+		if _, _, err := i.Add(ctx, inventory.CreateRequest{ID: id, Name: "P" + id}); err != nil {
+			t.Fatalf("Failed to add product: %v", err)
+		}
+	}
+	list := func(path, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		r.e.ServeHTTP(w, req)
+		return w
+	}
+
+	w := list("/products", contentTypeNDJSON)
+	if w.Code != 200 || w.Header().Get("Content-Type") != contentTypeNDJSON {
+		t.Fatalf("Expected an NDJSON response, got %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	dec := json.NewDecoder(w.Body)
+	var ids []string
+	for dec.More() {
+		var p inventory.Product
+		if err := dec.Decode(&p); err != nil {
+			t.Fatalf("Failed to decode line: %v", err)
+		}
+		ids = append(ids, p.ID)
+	}
+	if strings.Join(ids, ",") != "1,2,3" {
+		t.Fatalf("Expected products 1,2,3, got %v", ids)
+	}
+
+	if w = list("/products", "application/json"); !strings.HasPrefix(w.Body.String(), `{"data":[`) {
+		t.Fatalf("Expected a JSON document, got %s", w.Body.String())
+	}
+	if w = list("/products?page=1&limit=2", contentTypeNDJSON); !strings.Contains(w.Body.String(), `"next_page":2`) {
+		t.Fatalf("Expected pages to stay JSON, got %s", w.Body.String())
+	}
+}
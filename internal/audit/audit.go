@@ -0,0 +1,170 @@
+// Copyright 2025 Jacob Philip. All rights reserved.
+// Package audit records who changed what. Records are appended to a store
+// table and never updated or deleted.
+package audit
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jacobtrvl/inventory-management/internal/store"
+	"github.com/jacobtrvl/inventory-management/pkg/observability"
+)
+
+// Table is the default table of audit records.
+const Table = "audit_log"
+
+// Outcomes of audited calls.
+const (
+	OutcomeSuccess = "success"
+	// Rejected by authentication or authorization
+	OutcomeDenied  = "denied"
+	OutcomeFailure = "failure"
+)
+
+// Actor is the caller of an audited call. ID is empty for anonymous callers.
+type Actor struct {
+	ID     string `json:"id,omitempty"`
+	Name   string `json:"name,omitempty"`
+	Method string `json:"method,omitempty"`
+}
+
+// Record is one audited call. Before and After hold snapshots of the changed
+// resource, nil when it did not exist or nothing was changed.
+type Record struct {
+	ID         string    `json:"id"`
+	Time       time.Time `json:"time"`
+	Actor      Actor     `json:"actor"`
+	Tenant     string    `json:"tenant"`
+	Action     string    `json:"action"`
+	ResourceID string    `json:"resource_id,omitempty"`
+	Before     any       `json:"before,omitempty"`
+	After      any       `json:"after,omitempty"`
+	RequestID  string    `json:"request_id,omitempty"`
+	ClientIP   string    `json:"client_ip,omitempty"`
+	Outcome    string    `json:"outcome"`
+	Status     int       `json:"status"`
+}
+
+// Filter selects records. Empty fields match everything.
+type Filter struct {
+	Tenant     string
+	ActorID    string
+	Action     string
+	ResourceID string
+	Outcome    string
+	// Since is inclusive, Until is exclusive
+	Since time.Time
+	Until time.Time
+	// Limit caps the number of records, the most recent ones are kept. Zero is unlimited.
+	Limit int
+}
+
+func (f Filter) match(r Record) bool {
+	switch {
+	case f.Tenant != "" && r.Tenant != f.Tenant,
+		f.ActorID != "" && r.Actor.ID != f.ActorID,
+		f.Action != "" && r.Action != f.Action,
+		f.ResourceID != "" && r.ResourceID != f.ResourceID,
+		f.Outcome != "" && r.Outcome != f.Outcome,
+		!f.Since.IsZero() && r.Time.Before(f.Since),
+		!f.Until.IsZero() && !r.Time.Before(f.Until):
+		return false
+	}
+	return true
+}
+
+// Log appends records to a store table. The table is only written through
+// Append, with fresh keys, so existing records are never overwritten.
+type Log struct {
+	records store.Table[string, Record]
+	now     func() time.Time
+}
+
+// NewLog stores records in the given table, creating it if needed.
+func NewLog(db store.Store, table string) (*Log, error) {
+	records, err := store.NewTable[string, Record](db, table)
+	if err != nil {
+		return nil, err
+	}
+	return &Log{records: records, now: time.Now}, nil
+}
+
+// Append stores a record, setting its ID and time.
+func (l *Log) Append(ctx context.Context, r Record) (Record, error) {
+	r.ID = uuid.New().String()
+	r.Time = l.now().UTC()
+	if err := l.records.Write(r.ID, r); err != nil {
+		observability.Logger(ctx).ErrorContext(ctx, "Failed to write audit record",
+			"action", r.Action, "resource_id", r.ResourceID, "error", err)
+		return Record{}, fmt.Errorf("writing audit record: %w", err)
+	}
+	return r, nil
+}
+
+// Query returns the matching records, oldest first. The log is scanned from
+// the newest record, so that a limit stops the scan early.
+func (l *Log) Query(ctx context.Context, f Filter) ([]Record, error) {
+	records := make([]Record, 0)
+	for entry, err := range l.records.Scan(store.ScanOptions{Reverse: true}) {
+		if err != nil {
+			return nil, err
+		}
+		if r := entry.Value; f.match(r) {
+			records = append(records, r)
+			if len(records) == f.Limit {
+				break
+			}
+		}
+	}
+	slices.Reverse(records)
+	return records, nil
+}
+
+// Scan iterates over the matching records like Query, without holding them
+// in memory. With a limit, a first pass from the newest record finds the
+// oldest one to return.
+func (l *Log) Scan(ctx context.Context, f Filter) iter.Seq2[Record, error] {
+	return func(yield func(Record, error) bool) {
+		var opts store.ScanOptions
+		if f.Limit > 0 {
+			n := 0
+			for entry, err := range l.records.ScanContext(ctx, store.ScanOptions{Reverse: true}) {
+				if err != nil {
+					yield(Record{}, err)
+					return
+				}
+				if f.match(entry.Value) {
+					opts.StartKey = entry.Key
+					if n++; n == f.Limit {
+						break
+					}
+				}
+			}
+			if n == 0 {
+				return
+			}
+		}
+		n := 0
+		for entry, err := range l.records.ScanContext(ctx, opts) {
+			if err != nil {
+				yield(Record{}, err)
+				return
+			}
+			if !f.match(entry.Value) {
+				continue
+			}
+			if !yield(entry.Value, nil) {
+				return
+			}
+			// Records appended since the first pass are left out
+			if n++; n == f.Limit {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2025 Jacob Philip. All rights reserved.
+package audit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jacobtrvl/inventory-management/internal/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLog(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMemDb()
+	l, err := NewLog(db, Table)
+	assert.NoError(t, err)
+	now := time.Unix(1000, 0)
+	l.now = func() time.Time { return now }
+
+	appendRecord := func(r Record) Record {
+		rec, err := l.Append(ctx, r)
+		assert.NoError(t, err)
+		now = now.Add(time.Minute)
+		return rec
+	}
+	first := appendRecord(Record{Tenant: "a", Actor: Actor{ID: "k1"}, Action: "product.create", ResourceID: "1", Outcome: OutcomeSuccess})
+	appendRecord(Record{Tenant: "a", Actor: Actor{ID: "k2"}, Action: "product.delete", ResourceID: "1", Outcome: OutcomeDenied})
+	last := appendRecord(Record{Tenant: "b", Actor: Actor{ID: "k1"}, Action: "product.create", ResourceID: "2", Outcome: OutcomeSuccess})
+	assert.NotEmpty(t, first.ID)
+	assert.Equal(t, time.Unix(1000, 0).UTC(), first.Time)
+
+	all, err := l.Query(ctx, Filter{})
+	assert.NoError(t, err)
+	assert.Len(t, all, 3)
+	assert.Equal(t, first, all[0], "oldest first")
+
+	tests := map[string]struct {
+		filter Filter
+		want   int
+	}{
+		"tenant":         {Filter{Tenant: "a"}, 2},
+		"actor":          {Filter{ActorID: "k1"}, 2},
+		"action":         {Filter{Action: "product.delete"}, 1},
+		"resource":       {Filter{ResourceID: "2"}, 1},
+		"outcome":        {Filter{Outcome: OutcomeDenied}, 1},
+		"since":          {Filter{Since: last.Time}, 1},
+		"until":          {Filter{Until: last.Time}, 2},
+		"combined":       {Filter{Tenant: "a", ActorID: "k1"}, 1},
+		"no match":       {Filter{Tenant: "c"}, 0},
+		"limit":          {Filter{Limit: 2}, 2},
+		"limit over all": {Filter{Limit: 10}, 3},
+	}
+	for name, tt := range tests {
+		records, err := l.Query(ctx, tt.filter)
+		assert.NoError(t, err, name)
+		assert.Len(t, records, tt.want, name)
+		scanned := make([]Record, 0)
+		for r, err := range l.Scan(ctx, tt.filter) {
+			assert.NoError(t, err, name)
+			scanned = append(scanned, r)
+		}
+		assert.Equal(t, records, scanned, name)
+	}
+	records, _ := l.Query(ctx, Filter{Limit: 1})
+	assert.Equal(t, last, records[0], "limit keeps the most recent")
+}
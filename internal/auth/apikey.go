@@ -0,0 +1,182 @@
+// Copyright 2025 Jacob Philip. All rights reserved.
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jacobtrvl/inventory-management/internal/store"
+	"github.com/jacobtrvl/inventory-management/internal/tenant"
+	"github.com/jacobtrvl/inventory-management/pkg/observability"
+)
+
+const (
+	// KeysTable is the default table of API keys
+	KeysTable = "api_keys"
+	// keyPrefix tells API keys apart from other bearer tokens
+	keyPrefix = "imk_"
+)
+
+var (
+	ErrInvalidKey  = errors.New("invalid API key")
+	ErrKeyExpired  = errors.New("API key expired")
+	ErrKeyRevoked  = errors.New("API key revoked")
+	ErrKeyNotFound = errors.New("API key not found")
+)
+
+// APIKey is the stored form of an API key. The key itself is never stored,
+// only its SHA-256 hash. Keys are random 256 bit values, so a plain hash
+// without salt is enough: it cannot be brute forced like a password.
+type APIKey struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Hash      string     `json:"-"`
+	Roles     []string   `json:"roles"`
+	Tenant    string     `json:"tenant,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// KeyStore issues and verifies API keys. Keys are stored in a table keyed by
+// their hash, so that verifying a key is a single lookup.
+type KeyStore struct {
+	keys store.Table[string, APIKey]
+	now  func() time.Time
+}
+
+// NewKeyStore stores keys in the given table, creating it if needed.
+func NewKeyStore(db store.Store, table string) (*KeyStore, error) {
+	keys, err := store.NewTable[string, APIKey](db, table)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyStore{keys: keys, now: time.Now}, nil
+}
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Issue creates a new key with the given roles, bound to a tenant (empty for
+// the default tenant). A ttl of zero never expires.
+// The returned secret is shown to the caller once and cannot be recovered.
+func (s *KeyStore) Issue(ctx context.Context, name, tenantID string, roles []string, ttl time.Duration) (string, APIKey, error) {
+	if tenantID != "" {
+		if err := tenant.Validate(tenantID); err != nil {
+			return "", APIKey{}, err
+		}
+	}
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", APIKey{}, err
+	}
+	secret := keyPrefix + base64.RawURLEncoding.EncodeToString(buf)
+	key, err := s.add(ctx, secret, name, tenantID, roles, ttl)
+	return secret, key, err
+}
+
+// Bootstrap stores a key chosen by the operator, e.g. from the environment, so
+// that the first keys can be issued. Storing the same secret again is a no-op.
+func (s *KeyStore) Bootstrap(ctx context.Context, secret, name string, roles []string) (APIKey, error) {
+	if secret == "" {
+		return APIKey{}, ErrInvalidKey
+	}
+	if key, err := s.keys.Read(hashKey(secret)); err == nil {
+		return key, nil
+	}
+	return s.add(ctx, secret, name, "", roles, 0)
+}
+
+func (s *KeyStore) add(ctx context.Context, secret, name, tenantID string, roles []string, ttl time.Duration) (APIKey, error) {
+	now := s.now()
+	key := APIKey{
+		ID:        uuid.New().String(),
+		Name:      name,
+		Hash:      hashKey(secret),
+		Roles:     slices.Clone(roles),
+		Tenant:    tenantID,
+		CreatedAt: now,
+	}
+	if ttl > 0 {
+		expires := now.Add(ttl)
+		key.ExpiresAt = &expires
+	}
+	if err := s.keys.Write(key.Hash, key); err != nil {
+		return APIKey{}, err
+	}
+	observability.Logger(ctx).InfoContext(ctx, "API key issued", "key_id", key.ID, "name", name, "roles", roles, "tenant", tenantID)
+	return key, nil
+}
+
+// Authenticate verifies a key presented by a client.
+func (s *KeyStore) Authenticate(ctx context.Context, secret string) (Identity, error) {
+	if secret == "" {
+		return Identity{}, ErrInvalidKey
+	}
+	key, err := s.keys.Read(hashKey(secret))
+	if err != nil {
+		return Identity{}, ErrInvalidKey
+	}
+	now := s.now()
+	if key.RevokedAt != nil {
+		return Identity{}, ErrKeyRevoked
+	}
+	if key.ExpiresAt != nil && !now.Before(*key.ExpiresAt) {
+		return Identity{}, ErrKeyExpired
+	}
+	return Identity{
+		ID:     key.ID,
+		Name:   key.Name,
+		Roles:  key.Roles,
+		Tenant: key.Tenant,
+		Method: MethodAPIKey,
+	}, nil
+}
+
+// List returns all keys, including expired and revoked ones.
+func (s *KeyStore) List(ctx context.Context) ([]APIKey, error) {
+	keys := make([]APIKey, 0)
+	for entry, err := range s.keys.Scan(store.ScanOptions{}) {
+		if err != nil {
+			return nil, err
+		}
+		// The hash is not serialized, stores that encode items drop it
+		key := entry.Value
+		key.Hash = entry.Key
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// Revoke disables a key by ID. Revoked keys are kept for auditing.
+// Revocation is a rare admin operation, a scan over all keys is fine.
+func (s *KeyStore) Revoke(ctx context.Context, id string) (APIKey, error) {
+	keys, err := s.List(ctx)
+	if err != nil {
+		return APIKey{}, err
+	}
+	for _, key := range keys {
+		if key.ID != id {
+			continue
+		}
+		if key.RevokedAt == nil {
+			now := s.now()
+			key.RevokedAt = &now
+			if err := s.keys.Write(key.Hash, key); err != nil {
+				return APIKey{}, err
+			}
+			observability.Logger(ctx).InfoContext(ctx, "API key revoked", "key_id", key.ID)
+		}
+		return key, nil
+	}
+	return APIKey{}, ErrKeyNotFound
+}
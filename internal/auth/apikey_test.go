@@ -0,0 +1,110 @@
+// Copyright 2025 Jacob Philip. All rights reserved.
+package auth
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jacobtrvl/inventory-management/internal/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyStore(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMemDb()
+	keys, err := NewKeyStore(db, KeysTable)
+	assert.NoError(t, err)
+	now := time.Unix(1000, 0)
+	keys.now = func() time.Time { return now }
+
+	secret, key, err := keys.Issue(ctx, "ci", "brand-a", []string{RoleViewer}, time.Hour)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(secret, keyPrefix))
+
+	// Only the hash is stored
+	items, _ := db.ReadAll(KeysTable)
+	assert.Len(t, items, 1)
+	assert.NotContains(t, items[0].(APIKey).Hash, secret)
+	assert.Equal(t, hashKey(secret), items[0].(APIKey).Hash)
+
+	id, err := keys.Authenticate(ctx, secret)
+	assert.NoError(t, err)
+	assert.Equal(t, key.ID, id.ID)
+	assert.Equal(t, []string{RoleViewer}, id.Roles)
+	assert.Equal(t, "brand-a", id.Tenant)
+	assert.Equal(t, MethodAPIKey, id.Method)
+
+	_, _, err = keys.Issue(ctx, "bad", "../products", nil, 0)
+	assert.Error(t, err, "tenant IDs end up in table names")
+
+	_, err = keys.Authenticate(ctx, secret+"x")
+	assert.ErrorIs(t, err, ErrInvalidKey)
+	_, err = keys.Authenticate(ctx, "")
+	assert.ErrorIs(t, err, ErrInvalidKey)
+
+	now = now.Add(time.Hour)
+	_, err = keys.Authenticate(ctx, secret)
+	assert.ErrorIs(t, err, ErrKeyExpired)
+}
+
+func TestKeyStoreRevoke(t *testing.T) {
+	ctx := context.Background()
+	keys, _ := NewKeyStore(store.NewMemDb(), KeysTable)
+
+	admin, err := keys.Bootstrap(ctx, "operator-secret", "bootstrap", []string{RoleAdmin})
+	assert.NoError(t, err)
+	again, err := keys.Bootstrap(ctx, "operator-secret", "bootstrap", []string{RoleAdmin})
+	assert.NoError(t, err)
+	assert.Equal(t, admin.ID, again.ID, "bootstrapping twice keeps the key")
+
+	id, err := keys.Authenticate(ctx, "operator-secret")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{RoleAdmin}, id.Roles)
+
+	revoked, err := keys.Revoke(ctx, admin.ID)
+	assert.NoError(t, err)
+	assert.NotNil(t, revoked.RevokedAt)
+	_, err = keys.Authenticate(ctx, "operator-secret")
+	assert.ErrorIs(t, err, ErrKeyRevoked)
+
+	_, err = keys.Revoke(ctx, "missing")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	list, err := keys.List(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, list, 1)
+}
+
+func TestPolicy(t *testing.T) {
+	p := DefaultPolicy()
+	viewer := Identity{Roles: []string{RoleViewer}}
+	manager := Identity{Roles: []string{"unknown", RoleInventoryManager}}
+
+	assert.True(t, p.Allows(viewer, PermProductsRead))
+	assert.False(t, p.Allows(viewer, PermProductsWrite))
+	assert.True(t, p.Allows(manager, PermStockAdjust), "any role can grant")
+	assert.False(t, p.Allows(manager, PermKeysManage))
+	assert.False(t, p.Allows(Identity{}, PermProductsRead), "deny by default")
+	for _, perm := range Permissions {
+		assert.True(t, p.Allows(Identity{Roles: []string{RoleAdmin}}, perm))
+	}
+
+	assert.NoError(t, p.ValidateRoles([]string{RoleViewer, RoleAdmin}))
+	assert.ErrorIs(t, p.ValidateRoles([]string{"root"}), ErrUnknownRole)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "policy.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"roles":{"auditor":["products:read","metrics:read"]}}`), 0o600))
+	p, err := LoadPolicy(path)
+	assert.NoError(t, err)
+	assert.True(t, p.Allows(Identity{Roles: []string{"auditor"}}, PermMetricsRead))
+	assert.False(t, p.Allows(Identity{Roles: []string{RoleViewer}}, PermProductsRead), "roles come from the file only")
+
+	assert.NoError(t, os.WriteFile(path, []byte(`{"roles":{"viewer":["products:reed"]}}`), 0o600))
+	_, err = LoadPolicy(path)
+	assert.Error(t, err)
+}
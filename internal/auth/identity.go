@@ -0,0 +1,41 @@
+// Copyright 2025 Jacob Philip. All rights reserved.
+// Package auth authenticates API clients and carries their identity through
+// the request context.
+package auth
+
+import (
+	"context"
+)
+
+// MethodAPIKey is the authentication method of identities from API keys.
+const MethodAPIKey = "api_key"
+
+// Authenticator verifies a bearer token.
+type Authenticator interface {
+	Authenticate(ctx context.Context, token string) (Identity, error)
+}
+
+// Identity is an authenticated caller.
+type Identity struct {
+	// ID is stable for the caller, e.g. the ID of the API key
+	ID   string
+	Name string
+	// Roles granted to the caller, a Policy maps them to permissions
+	Roles []string
+	// Tenant the caller is bound to, empty for the default tenant
+	Tenant string
+	Method string
+}
+
+type identityKey struct{}
+
+// ContextWithIdentity returns a copy of ctx carrying the caller identity.
+func ContextWithIdentity(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// FromContext returns the caller identity, if the request was authenticated.
+func FromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(Identity)
+	return id, ok
+}
@@ -0,0 +1,300 @@
+// Copyright 2025 Jacob Philip. All rights reserved.
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jacobtrvl/inventory-management/pkg/observability"
+)
+
+const (
+	defaultJWKSCacheTTL   = 15 * time.Minute
+	defaultJWKSMinRefresh = 30 * time.Second
+	// Upper bound on a JWKS document, a key set is a few kilobytes
+	maxJWKSSize = 1 << 20
+)
+
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// KeySet holds the public keys of a JWKS document, loaded from a local file
+// or an http(s) URL. Keys are cached for the cache TTL and reloaded on demand,
+// there is no background refresh. A token signed with an unknown key ID
+// triggers an early reload, so that rotated keys are picked up, but at most
+// once per minimum refresh interval so that bogus key IDs cannot hammer the source.
+type KeySet struct {
+	source     string
+	client     *http.Client
+	ttl        time.Duration
+	minRefresh time.Duration
+	now        func() time.Time
+
+	// serializes reloads, readers use the cache without locking
+	mu    sync.Mutex
+	cache atomic.Pointer[jwkCache]
+}
+
+type jwkCache struct {
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+// KeySetOption customizes a KeySet.
+type KeySetOption func(*KeySet)
+
+// WithCacheTTL sets how long loaded keys are used before they are reloaded. Defaults to 15 minutes.
+func WithCacheTTL(d time.Duration) KeySetOption {
+	return func(s *KeySet) {
+		if d > 0 {
+			s.ttl = d
+		}
+	}
+}
+
+// WithMinRefresh sets the minimum time between reloads caused by unknown key IDs. Defaults to 30 seconds.
+func WithMinRefresh(d time.Duration) KeySetOption {
+	return func(s *KeySet) {
+		if d >= 0 {
+			s.minRefresh = d
+		}
+	}
+}
+
+// WithHTTPClient replaces the client used for JWKS URLs.
+func WithHTTPClient(c *http.Client) KeySetOption {
+	return func(s *KeySet) {
+		if c != nil {
+			s.client = c
+		}
+	}
+}
+
+// NewKeySet loads keys from source, a file path or an http(s) URL.
+// Nothing is loaded until the first key is needed.
+func NewKeySet(source string, opts ...KeySetOption) *KeySet {
+	s := &KeySet{
+		source:     source,
+		client:     &http.Client{Timeout: 10 * time.Second},
+		ttl:        defaultJWKSCacheTTL,
+		minRefresh: defaultJWKSMinRefresh,
+		now:        time.Now,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Key returns the public key with the given key ID. An empty ID matches the
+// only key of a set with a single key.
+func (s *KeySet) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	c := s.cache.Load()
+	if c != nil && s.now().Sub(c.fetched) < s.ttl {
+		if key, ok := c.lookup(kid); ok {
+			return key, nil
+		}
+		if s.now().Sub(c.fetched) < s.minRefresh {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownKey, kid)
+		}
+	}
+
+	c, err := s.reload(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	if key, ok := c.lookup(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnknownKey, kid)
+}
+
+func (c *jwkCache) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(c.keys) == 1 {
+		for _, key := range c.keys {
+			return key, true
+		}
+	}
+	key, ok := c.keys[kid]
+	return key, ok
+}
+
+// reload fetches the key set, unless another caller did so since seen was loaded.
+// If the source is not reachable, keys loaded before stay in use.
+func (s *KeySet) reload(ctx context.Context, seen *jwkCache) (*jwkCache, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c := s.cache.Load(); c != seen {
+		return c, nil
+	}
+
+	keys, err := s.fetch(ctx)
+	if err != nil {
+		if seen != nil {
+			observability.Logger(ctx).WarnContext(ctx, "Failed to reload JWKS, using cached keys",
+				"source", s.source, "error", err)
+			// Do not retry on every request
+			c := &jwkCache{keys: seen.keys, fetched: s.now()}
+			s.cache.Store(c)
+			return c, nil
+		}
+		return nil, fmt.Errorf("loading JWKS from %s: %w", s.source, err)
+	}
+	c := &jwkCache{keys: keys, fetched: s.now()}
+	s.cache.Store(c)
+	observability.Logger(ctx).DebugContext(ctx, "JWKS loaded", "source", s.source, "keys", len(keys))
+	return c, nil
+}
+
+func (s *KeySet) fetch(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	var data []byte
+	if strings.HasPrefix(s.source, "http://") || strings.HasPrefix(s.source, "https://") {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.source, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := s.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unexpected status %s", resp.Status)
+		}
+		if data, err = io.ReadAll(io.LimitReader(resp.Body, maxJWKSSize)); err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		if data, err = os.ReadFile(s.source); err != nil {
+			return nil, err
+		}
+	}
+	return ParseJWKS(data)
+}
+
+// jwk is a JSON Web Key (RFC 7517). Only public keys for signatures are used.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC and OKP
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// ParseJWKS parses a JWKS document. Keys that are not for signatures or of
+// an unsupported type are skipped, an invalid key fails the whole set.
+func ParseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("invalid JWKS: %w", err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWK %q: %w", k.Kid, err)
+		}
+		if key != nil {
+			keys[k.Kid] = key
+		}
+	}
+	return keys, nil
+}
+
+// publicKey returns nil for key types that are not supported.
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		if n.BitLen() < 2048 {
+			return nil, errors.New("RSA key shorter than 2048 bits")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, nil
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if x.BitLen() > 256 || y.BitLen() > 256 {
+			return nil, errors.New("invalid P-256 coordinates")
+		}
+		// crypto/ecdh rejects points that are not on the curve
+		point := make([]byte, 65)
+		point[0] = 4
+		x.FillBytes(point[1:33])
+		y.FillBytes(point[33:])
+		if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, nil
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key size")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, errors.New("empty value")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
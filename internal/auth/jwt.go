@@ -0,0 +1,156 @@
+// Copyright 2025 Jacob Philip. All rights reserved.
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jacobtrvl/inventory-management/internal/tenant"
+)
+
+// MethodJWT is the authentication method of identities from bearer JWTs.
+const MethodJWT = "jwt"
+
+// Only asymmetric algorithms are accepted. HS256 with a public key as the
+// secret is the classic algorithm confusion attack.
+var jwtAlgorithms = []string{"RS256", "ES256", "EdDSA"}
+
+var ErrInvalidToken = errors.New("invalid token")
+
+// JWTConfig configures the checks on bearer JWTs.
+type JWTConfig struct {
+	// Expected "iss" claim, not checked when empty
+	Issuer string
+	// Expected "aud" claim, not checked when empty
+	Audience string
+	// Clock skew tolerated on exp, nbf and iat
+	Leeway time.Duration
+	// Claim holding the roles of the caller, either a list of strings or a
+	// space separated string. Nested claims are separated by dots, e.g.
+	// "realm_access.roles". Defaults to "roles".
+	RolesClaim string
+	// Maps roles of the issuer to roles of the Policy, e.g.
+	// "inventory-editor" to "editor". Roles without an entry are kept as is.
+	RoleMap map[string][]string
+	// Claim holding the tenant of the caller, a dotted path like RolesClaim.
+	// Defaults to "tenant". Tokens without it belong to the default tenant.
+	TenantClaim string
+}
+
+// JWTValidator authenticates bearer JWTs signed by a key of a JWKS.
+type JWTValidator struct {
+	keys   *KeySet
+	cfg    JWTConfig
+	parser *jwt.Parser
+}
+
+// NewJWTValidator validates tokens against the keys of a JWKS.
+func NewJWTValidator(keys *KeySet, cfg JWTConfig) *JWTValidator {
+	if cfg.RolesClaim == "" {
+		cfg.RolesClaim = "roles"
+	}
+	if cfg.TenantClaim == "" {
+		cfg.TenantClaim = "tenant"
+	}
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods(jwtAlgorithms),
+		jwt.WithLeeway(cfg.Leeway),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithTimeFunc(func() time.Time { return keys.now() }),
+	}
+	if cfg.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		opts = append(opts, jwt.WithAudience(cfg.Audience))
+	}
+	return &JWTValidator{keys: keys, cfg: cfg, parser: jwt.NewParser(opts...)}
+}
+
+// IsJWT tells bearer JWTs apart from API keys.
+func IsJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// Authenticate verifies the signature and the claims of a token.
+func (v *JWTValidator) Authenticate(ctx context.Context, token string) (Identity, error) {
+	claims := jwt.MapClaims{}
+	_, err := v.parser.ParseWithClaims(token, claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		return v.keys.Key(ctx, kid)
+	})
+	if err != nil {
+		return Identity{}, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+	sub, err := claims.GetSubject()
+	if err != nil || sub == "" {
+		return Identity{}, fmt.Errorf("%w: missing subject", ErrInvalidToken)
+	}
+
+	roles := v.mapRoles(claimStrings(claims, v.cfg.RolesClaim))
+	var tenantID string
+	if t := claimStrings(claims, v.cfg.TenantClaim); len(t) > 0 {
+		tenantID = t[0]
+		if err := tenant.Validate(tenantID); err != nil {
+			return Identity{}, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+		}
+	}
+	name, _ := claims["name"].(string)
+	if name == "" {
+		name = sub
+	}
+	return Identity{
+		ID:     sub,
+		Name:   name,
+		Roles:  roles,
+		Tenant: tenantID,
+		Method: MethodJWT,
+	}, nil
+}
+
+func (v *JWTValidator) mapRoles(claimed []string) []string {
+	var roles []string
+	for _, role := range claimed {
+		mapped, ok := v.cfg.RoleMap[role]
+		if !ok {
+			mapped = []string{role}
+		}
+		for _, r := range mapped {
+			if !slices.Contains(roles, r) {
+				roles = append(roles, r)
+			}
+		}
+	}
+	return roles
+}
+
+// claimStrings reads a list of strings or a space separated string at a dotted path.
+func claimStrings(claims jwt.MapClaims, path string) []string {
+	var value any = map[string]any(claims)
+	for _, part := range strings.Split(path, ".") {
+		m, ok := value.(map[string]any)
+		if !ok {
+			return nil
+		}
+		value = m[part]
+	}
+	switch v := value.(type) {
+	case string:
+		return strings.Fields(v)
+	case []any:
+		var out []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+// Copyright 2025 Jacob Philip. All rights reserved.
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+type testKey struct {
+	kid    string
+	method jwt.SigningMethod
+	signer crypto.Signer
+}
+
+func newTestKeys(t *testing.T) []testKey {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	return []testKey{
+		{kid: "rsa", method: jwt.SigningMethodRS256, signer: rsaKey},
+		{kid: "ec", method: jwt.SigningMethodES256, signer: ecKey},
+		{kid: "ed", method: jwt.SigningMethodEdDSA, signer: edKey},
+	}
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// jwks encodes the public keys as a JWKS document.
+func jwks(keys ...testKey) []byte {
+	var set struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	for _, k := range keys {
+		switch pub := k.signer.Public().(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, map[string]string{
+				"kty": "RSA", "kid": k.kid, "use": "sig",
+				"n": b64(pub.N.Bytes()), "e": b64(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		case *ecdsa.PublicKey:
+			set.Keys = append(set.Keys, map[string]string{
+				"kty": "EC", "kid": k.kid, "crv": "P-256",
+				"x": b64(pub.X.FillBytes(make([]byte, 32))), "y": b64(pub.Y.FillBytes(make([]byte, 32))),
+			})
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, map[string]string{
+				"kty": "OKP", "kid": k.kid, "crv": "Ed25519", "x": b64(pub),
+			})
+		}
+	}
+	data, _ := json.Marshal(set)
+	return data
+}
+
+func sign(t *testing.T, k testKey, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(k.method, claims)
+	token.Header["kid"] = k.kid
+	s, err := token.SignedString(k.signer)
+	assert.NoError(t, err)
+	return s
+}
+
+// jwksServer serves the current key set and counts the requests.
+type jwksServer struct {
+	mu       sync.Mutex
+	keys     []byte
+	requests int
+}
+
+func (s *jwksServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+	w.Write(s.keys)
+}
+
+func (s *jwksServer) set(keys []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = keys
+}
+
+func (s *jwksServer) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+func TestJWTValidator(t *testing.T) {
+	ctx := context.Background()
+	keys := newTestKeys(t)
+	srv := &jwksServer{keys: jwks(keys...)}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	now := time.Unix(1_700_000_000, 0)
+	set := NewKeySet(ts.URL)
+	set.now = func() time.Time { return now }
+	v := NewJWTValidator(set, JWTConfig{
+		Issuer:     "https://issuer.example",
+		Audience:   "inventory",
+		Leeway:     30 * time.Second,
+		RolesClaim: "realm_access.roles",
+		RoleMap:    map[string][]string{"inventory-editor": {RoleEditor}},
+	})
+	claims := func(mod func(jwt.MapClaims)) jwt.MapClaims {
+		c := jwt.MapClaims{
+			"sub":          "user-1",
+			"iss":          "https://issuer.example",
+			"aud":          []string{"inventory"},
+			"iat":          now.Unix(),
+			"exp":          now.Add(time.Minute).Unix(),
+			"realm_access": map[string]any{"roles": []string{"inventory-editor", "offline_access"}},
+		}
+		if mod != nil {
+			mod(c)
+		}
+		return c
+	}
+
+	for _, k := range keys {
+		id, err := v.Authenticate(ctx, sign(t, k, claims(func(c jwt.MapClaims) { c["tenant"] = "brand-a" })))
+		assert.NoError(t, err, k.method.Alg())
+		assert.Equal(t, "user-1", id.ID)
+		assert.Equal(t, MethodJWT, id.Method)
+		assert.Equal(t, []string{RoleEditor, "offline_access"}, id.Roles, "mapped and unmapped roles")
+		assert.Equal(t, "brand-a", id.Tenant)
+	}
+	assert.Equal(t, 1, srv.count(), "keys are cached")
+
+	rejected := map[string]jwt.MapClaims{
+		"expired beyond leeway": claims(func(c jwt.MapClaims) { c["exp"] = now.Add(-time.Minute).Unix() }),
+		"not valid yet":         claims(func(c jwt.MapClaims) { c["nbf"] = now.Add(time.Minute).Unix() }),
+		"wrong audience":        claims(func(c jwt.MapClaims) { c["aud"] = "other" }),
+		"wrong issuer":          claims(func(c jwt.MapClaims) { c["iss"] = "https://evil.example" }),
+		"no expiry":             claims(func(c jwt.MapClaims) { delete(c, "exp") }),
+		"no subject":            claims(func(c jwt.MapClaims) { delete(c, "sub") }),
+		"invalid tenant":        claims(func(c jwt.MapClaims) { c["tenant"] = "Brand/A" }),
+	}
+	for name, c := range rejected {
+		_, err := v.Authenticate(ctx, sign(t, keys[0], c))
+		assert.ErrorIs(t, err, ErrInvalidToken, name)
+	}
+
+	// Clock skew within the leeway is tolerated
+	_, err := v.Authenticate(ctx, sign(t, keys[1], claims(func(c jwt.MapClaims) {
+		c["exp"] = now.Add(-10 * time.Second).Unix()
+		c["nbf"] = now.Add(10 * time.Second).Unix()
+	})))
+	assert.NoError(t, err)
+
+	// Symmetric algorithms are never accepted
+	hs := jwt.NewWithClaims(jwt.SigningMethodHS256, claims(nil))
+	hs.Header["kid"] = "rsa"
+	token, _ := hs.SignedString([]byte("secret"))
+	_, err = v.Authenticate(ctx, token)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	// A token signed by a key of another kid is rejected
+	forged := keys[1]
+	forged.kid = "rsa"
+	forged.method = jwt.SigningMethodES256
+	_, err = v.Authenticate(ctx, sign(t, forged, claims(nil)))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestKeySetRotation(t *testing.T) {
+	ctx := context.Background()
+	keys := newTestKeys(t)
+	srv := &jwksServer{keys: jwks(keys[0])}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	now := time.Unix(1_700_000_000, 0)
+	set := NewKeySet(ts.URL, WithCacheTTL(time.Hour), WithMinRefresh(time.Minute))
+	set.now = func() time.Time { return now }
+
+	_, err := set.Key(ctx, "rsa")
+	assert.NoError(t, err)
+
+	// The issuer rotates to a new key
+	srv.set(jwks(keys[1]))
+	_, err = set.Key(ctx, "ec")
+	assert.ErrorIs(t, err, ErrUnknownKey, "reloads are limited right after a load")
+	assert.Equal(t, 1, srv.count())
+
+	now = now.Add(time.Minute)
+	_, err = set.Key(ctx, "ec")
+	assert.NoError(t, err, "unknown key ID triggers a reload")
+	_, err = set.Key(ctx, "rsa")
+	assert.ErrorIs(t, err, ErrUnknownKey, "rotated out")
+	assert.Equal(t, 2, srv.count())
+
+	// Stale keys stay in use while the source is down
+	ts.Close()
+	now = now.Add(2 * time.Hour)
+	_, err = set.Key(ctx, "ec")
+	assert.NoError(t, err)
+}
+
+func TestKeySetFile(t *testing.T) {
+	keys := newTestKeys(t)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	assert.NoError(t, os.WriteFile(path, jwks(keys[2]), 0o600))
+
+	set := NewKeySet(path)
+	// A single key matches tokens without a key ID
+	key, err := set.Key(context.Background(), "")
+	assert.NoError(t, err)
+	assert.Equal(t, keys[2].signer.Public(), key)
+
+	_, err = ParseJWKS([]byte(`{"keys":[{"kty":"EC","kid":"bad","crv":"P-256","x":"AQ","y":"AQ"}]}`))
+	assert.Error(t, err, "point not on the curve")
+	_, err = ParseJWKS([]byte(`{"keys":[{"kty":"RSA","kid":"short","n":"AQAB","e":"AQAB"}]}`))
+	assert.Error(t, err, "short RSA key")
+}
@@ -0,0 +1,107 @@
+// Copyright 2025 Jacob Philip. All rights reserved.
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+)
+
+// Permission allows one kind of operation.
+type Permission string
+
+const (
+	PermProductsRead     Permission = "products:read"
+	PermProductsWrite    Permission = "products:write"
+	PermStockAdjust      Permission = "stock:adjust"
+	PermMetricsRead      Permission = "metrics:read"
+	PermRateLimitsManage Permission = "ratelimits:manage"
+	PermKeysManage       Permission = "keys:manage"
+	PermAuditRead        Permission = "audit:read"
+)
+
+// Permissions lists the known permissions.
+var Permissions = []Permission{
+	PermProductsRead,
+	PermProductsWrite,
+	PermStockAdjust,
+	PermMetricsRead,
+	PermRateLimitsManage,
+	PermKeysManage,
+	PermAuditRead,
+}
+
+// Roles of the default policy.
+const (
+	RoleViewer           = "viewer"
+	RoleEditor           = "editor"
+	RoleInventoryManager = "inventory-manager"
+	RoleAdmin            = "admin"
+)
+
+var ErrUnknownRole = errors.New("unknown role")
+
+// Policy maps roles to permissions. It denies by default: a caller is only
+// allowed what one of its roles grants, unknown roles grant nothing.
+type Policy struct {
+	Roles map[string][]Permission `json:"roles"`
+}
+
+// DefaultPolicy is used when no policy is configured.
+func DefaultPolicy() Policy {
+	viewer := []Permission{PermProductsRead, PermMetricsRead}
+	editor := append(slices.Clone(viewer), PermProductsWrite)
+	manager := append(slices.Clone(editor), PermStockAdjust)
+	return Policy{Roles: map[string][]Permission{
+		RoleViewer:           viewer,
+		RoleEditor:           editor,
+		RoleInventoryManager: manager,
+		RoleAdmin:            slices.Clone(Permissions),
+	}}
+}
+
+// LoadPolicy reads a policy from a JSON file, e.g.
+//
+//	{"roles": {"viewer": ["products:read"], "auditor": ["products:read", "metrics:read"]}}
+//
+// Unknown permissions are rejected, a typo must not silently deny or grant access.
+func LoadPolicy(path string) (Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Policy{}, err
+	}
+	var p Policy
+	if err := json.Unmarshal(data, &p); err != nil {
+		return Policy{}, fmt.Errorf("invalid policy %s: %w", path, err)
+	}
+	for role, perms := range p.Roles {
+		for _, perm := range perms {
+			if !slices.Contains(Permissions, perm) {
+				return Policy{}, fmt.Errorf("invalid policy %s: role %s: unknown permission %q", path, role, perm)
+			}
+		}
+	}
+	return p, nil
+}
+
+// Allows reports whether one of the roles of the caller grants perm.
+func (p Policy) Allows(id Identity, perm Permission) bool {
+	for _, role := range id.Roles {
+		if slices.Contains(p.Roles[role], perm) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateRoles returns ErrUnknownRole for roles the policy does not define.
+func (p Policy) ValidateRoles(roles []string) error {
+	for _, role := range roles {
+		if _, ok := p.Roles[role]; !ok {
+			return fmt.Errorf("%w: %s", ErrUnknownRole, role)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,247 @@
+// Copyright 2025 Jacob Philip. All rights reserved.
+package inventory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jacobtrvl/inventory-management/internal/store"
+	"github.com/jacobtrvl/inventory-management/pkg/observability"
+)
+
+// Operations of a batch.
+const (
+	BatchCreate = "create"
+	BatchUpdate = "update"
+	BatchDelete = "delete"
+	// Updates the product if it exists, creates it otherwise
+	BatchUpsert = "upsert"
+)
+
+// MaxBatchSize is the maximum number of operations in a batch.
+const MaxBatchSize = 10000
+
+// BatchOp is one operation of a batch. Creates take the product fields,
+// the ID is generated if empty. Updates change the fields that are set.
+type BatchOp struct {
+	Op string `json:"op"`
+	ID string `json:"id,omitempty"`
+	UpdateRequest
+}
+
+// BatchResult is the outcome of one operation, with the status code the
+// single item endpoint would have returned.
+type BatchResult struct {
+	Index  int    `json:"index"`
+	ID     string `json:"id,omitempty"`
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// batchError reports the operation that rolled back an atomic batch.
+type batchError struct {
+	result BatchResult
+}
+
+func (e *batchError) Error() string {
+	return fmt.Sprintf("operation %d: %s", e.result.Index, e.result.Error)
+}
+
+// Batch applies a list of operations to the products of the tenant, under a
+// single lock of its table. In atomic mode the first failing operation rolls
+// back the whole batch, and its result is returned with the error. Otherwise
+// every operation is tried, and the results report each outcome.
+func (i *Inventory) Batch(ctx context.Context, ops []BatchOp, atomic bool) ([]BatchResult, int, error) {
+	if len(ops) == 0 || len(ops) > MaxBatchSize {
+		return nil, http.StatusBadRequest, fmt.Errorf("a batch must have 1 to %d operations, got %d", MaxBatchSize, len(ops))
+	}
+	mode := batchBestEffort
+	if atomic {
+		mode = batchStopOnError
+	}
+	results, committed, err := i.runBatch(ctx, ops, mode, false)
+	if err != nil {
+		return nil, storeStatus(err), err
+	}
+	if !committed {
+		// The failing operation is the last one that ran
+		failed := results[len(results)-1]
+		return []BatchResult{failed}, failed.Status, &batchError{result: failed}
+	}
+	return results, http.StatusOK, nil
+}
+
+// batchMode decides when a batch is rolled back.
+type batchMode int
+
+const (
+	// Every operation is tried, successful ones are kept
+	batchBestEffort batchMode = iota
+	// Roll back at the first failing operation
+	batchStopOnError
+	// Every operation is tried, any failure rolls back all of them
+	batchAllOrNothing
+)
+
+// runBatch applies ops in a single table batch and reports whether the
+// changes were kept. A dry run always rolls back, but its results tell what
+// would have happened. In batchStopOnError mode the results end with the
+// failing operation.
+func (i *Inventory) runBatch(ctx context.Context, ops []BatchOp, mode batchMode, dryRun bool) ([]BatchResult, bool, error) {
+	t, err := i.tenant(ctx, true)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := t.acquire(ctx); err != nil {
+		return nil, false, err
+	}
+	defer t.release()
+
+	quota := i.quotaOf(t.id)
+	now := time.Now()
+	results := make([]BatchResult, len(ops))
+	changes := make([]Change, 0, len(ops))
+	count := -1
+	err = t.products.BatchContext(ctx, func(tx productsTx) error {
+		failed := false
+		for idx, op := range ops {
+			change, err := applyBatchOp(tx, op, quota, now, &results[idx])
+			results[idx].Index = idx
+			if err != nil {
+				results[idx].Error = err.Error()
+				failed = true
+				if mode == batchStopOnError {
+					results = results[:idx+1]
+					return store.ErrRollback
+				}
+				continue
+			}
+			changes = append(changes, change)
+		}
+		count = tx.Len()
+		if dryRun || (failed && mode == batchAllOrNothing) {
+			return store.ErrRollback
+		}
+		return nil
+	})
+	committed := err == nil
+	if err != nil && !errors.Is(err, store.ErrRollback) {
+		observability.Logger(ctx).ErrorContext(ctx, "Failed to apply batch", "error", err)
+		return nil, false, err
+	}
+
+	for _, res := range results {
+		// Operations that were rolled back did not happen
+		if committed || (!dryRun && res.Error != "") {
+			t.mc.RecordOperation(batchOperation(ops[res.Index].Op), res.Error == "")
+		}
+	}
+	if !committed {
+		observability.Logger(ctx).DebugContext(ctx, "Batch rolled back", "operations", len(ops), "dry_run", dryRun)
+		return results, false, nil
+	}
+	t.count = count
+	for _, change := range changes {
+		recordChange(ctx, change.Before, change.After)
+	}
+	observability.Logger(ctx).DebugContext(ctx, "Batch applied", "operations", len(ops), "changed", len(changes))
+	return results, true, nil
+}
+
+func batchOperation(op string) observability.OperationType {
+	switch op {
+	case BatchCreate, BatchUpsert:
+		return observability.OpInsert
+	case BatchDelete:
+		return observability.OpDelete
+	default:
+		return observability.OpUpdate
+	}
+}
+
+type productsTx = store.TableTx[string, Product]
+
+// applyBatchOp applies one operation and fills in its result. Checks match
+// the ones of Add, Update and Delete.
+func applyBatchOp(tx productsTx, op BatchOp, quota int, now time.Time, res *BatchResult) (Change, error) {
+	res.ID = op.ID
+	fail := func(status int, err error) (Change, error) {
+		res.Status = status
+		return Change{}, err
+	}
+	if op.Op == BatchUpsert {
+		op.Op = BatchCreate
+		if _, err := tx.Read(op.ID); op.ID != "" && err == nil {
+			op.Op = BatchUpdate
+		}
+	}
+	if op.Op == BatchCreate || op.Op == BatchUpdate {
+		if err := op.validate(); err != nil {
+			return fail(http.StatusBadRequest, err)
+		}
+	}
+	switch op.Op {
+	case BatchCreate:
+		if op.ID == "" {
+			op.ID = generateID()
+			res.ID = op.ID
+		}
+		if len(op.ID) > 255 {
+			return fail(http.StatusBadRequest, fmt.Errorf("product ID too long: maximum 255 characters, got %d", len(op.ID)))
+		}
+		if _, err := tx.Read(op.ID); err == nil {
+			return fail(http.StatusConflict, fmt.Errorf("product with ID %s already exists", op.ID))
+		}
+		if quota > 0 && tx.Len() >= quota {
+			return fail(http.StatusForbidden, fmt.Errorf("%w: limited to %d products", ErrQuotaExceeded, quota))
+		}
+		product := Product{ID: op.ID, CreatedAt: now, UpdatedAt: now}
+		op.apply(&product)
+		if err := tx.Write(product.ID, product); err != nil {
+			return fail(http.StatusInternalServerError, err)
+		}
+		res.Status = http.StatusCreated
+		return Change{After: &product}, nil
+	case BatchUpdate, BatchDelete:
+		if op.ID == "" {
+			return fail(http.StatusBadRequest, errors.New("product ID is required"))
+		}
+		before, err := tx.Read(op.ID)
+		if err != nil {
+			return fail(readStatus(err), err)
+		}
+		if op.Op == BatchDelete {
+			if err := tx.Delete(op.ID); err != nil {
+				return fail(http.StatusInternalServerError, err)
+			}
+			res.Status = http.StatusOK
+			return Change{Before: &before}, nil
+		}
+		after := before
+		op.apply(&after)
+		after.UpdatedAt = now
+		if err := tx.Write(op.ID, after); err != nil {
+			return fail(http.StatusInternalServerError, err)
+		}
+		res.Status = http.StatusOK
+		return Change{Before: &before, After: &after}, nil
+	default:
+		return fail(http.StatusBadRequest, fmt.Errorf("unknown operation %q", op.Op))
+	}
+}
+
+// apply sets the fields of the request on p.
+func (req UpdateRequest) apply(p *Product) {
+	if req.Name != nil {
+		p.Name = *req.Name
+	}
+	if req.Price != nil {
+		p.Price = *req.Price
+	}
+	if req.Stock != nil {
+		p.Stock = *req.Stock
+	}
+}
@@ -0,0 +1,43 @@
+// Copyright 2025 Jacob Philip. All rights reserved.
+package inventory
+
+import (
+	"context"
+	"sync"
+)
+
+// Change is a product before and after a successful mutation. Before is nil
+// for inserts, After is nil for deletes.
+type Change struct {
+	Before *Product
+	After  *Product
+}
+
+type changesKey struct{}
+
+type changeLog struct {
+	mu      sync.Mutex
+	changes []Change
+}
+
+// TrackChanges returns a context that collects the changes made through it,
+// e.g. for auditing, and a function returning the changes collected so far.
+func TrackChanges(ctx context.Context) (context.Context, func() []Change) {
+	l := &changeLog{}
+	return context.WithValue(ctx, changesKey{}, l), func() []Change {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		return append([]Change(nil), l.changes...)
+	}
+}
+
+// recordChange is a no-op unless the context tracks changes.
+func recordChange(ctx context.Context, before, after *Product) {
+	l, ok := ctx.Value(changesKey{}).(*changeLog)
+	if !ok {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.changes = append(l.changes, Change{Before: before, After: after})
+}
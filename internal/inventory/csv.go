@@ -0,0 +1,301 @@
+// Copyright 2025 Jacob Philip. All rights reserved.
+package inventory
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jacobtrvl/inventory-management/pkg/observability"
+)
+
+// Import modes.
+const (
+	// Rows create products, an existing ID fails the row
+	ImportInsert = "insert"
+	// Rows update existing products and create the others
+	ImportUpsert = "upsert"
+)
+
+// MaxImportRows is the maximum number of data rows of an import.
+const MaxImportRows = 100000
+
+// Columns of an export, in order. Imports read the first four.
+var csvColumns = []string{"id", "name", "price", "stock", "created_at", "updated_at"}
+
+// ErrStockUpdate is returned by imports that would set the stock of existing
+// products while ImportOptions.AllowStockUpdates is false.
+var ErrStockUpdate = errors.New("updating stock is not allowed")
+
+// ImportOptions configures ImportCSV.
+type ImportOptions struct {
+	// ImportInsert (default) or ImportUpsert
+	Mode string
+	// Validate every row without writing anything
+	DryRun bool
+	// Mapping maps CSV headers to product fields (id, name, price, stock).
+	// Without a mapping, headers matching a field name are used. Other
+	// columns are ignored in both cases.
+	Mapping map[string]string
+	// Upserts with a stock column fail with ErrStockUpdate unless set
+	AllowStockUpdates bool
+}
+
+// ImportError is a rejected row. Line is the line of the row in the CSV.
+type ImportError struct {
+	Line   int    `json:"line"`
+	ID     string `json:"id,omitempty"`
+	Status int    `json:"status"`
+	Error  string `json:"error"`
+}
+
+// ImportReport is the outcome of an import. On dry runs and rejected imports
+// the counts are what the import would have done.
+type ImportReport struct {
+	Rows    int           `json:"rows"`
+	Created int           `json:"created"`
+	Updated int           `json:"updated"`
+	DryRun  bool          `json:"dry_run"`
+	Errors  []ImportError `json:"errors,omitempty"`
+}
+
+// ExportCSV writes the products of the tenant as CSV while iterating over
+// them with All. If w has a Flush method, it is called after each page.
+func (i *Inventory) ExportCSV(ctx context.Context, w io.Writer) error {
+	cw := csv.NewWriter(w)
+	flusher, _ := w.(interface{ Flush() })
+	flush := func() error {
+		cw.Flush()
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return cw.Error()
+	}
+	if err := cw.Write(csvColumns); err != nil {
+		return err
+	}
+	record := make([]string, len(csvColumns))
+	written := 0
+	for p, err := range i.All(ctx) {
+		if err != nil {
+			return err
+		}
+		record[0] = escapeCell(p.ID)
+		record[1] = escapeCell(p.Name)
+		record[2] = strconv.FormatFloat(p.Price, 'f', -1, 64)
+		record[3] = strconv.Itoa(p.Stock)
+		record[4] = p.CreatedAt.Format(time.RFC3339Nano)
+		record[5] = p.UpdatedAt.Format(time.RFC3339Nano)
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+		if written++; written%scanPageSize == 0 {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
+
+// ImportCSV creates or updates products from CSV with a header row. Every row
+// is validated, and the import is all or nothing: if any row fails, nothing
+// is written and the report lists the failed rows with status 422. Errors in
+// the CSV structure or the header are returned with status 400.
+func (i *Inventory) ImportCSV(ctx context.Context, r io.Reader, opts ImportOptions) (ImportReport, int, error) {
+	report := ImportReport{DryRun: opts.DryRun}
+	op := BatchCreate
+	switch opts.Mode {
+	case "", ImportInsert:
+	case ImportUpsert:
+		op = BatchUpsert
+	default:
+		return report, http.StatusBadRequest, fmt.Errorf("unknown import mode %q", opts.Mode)
+	}
+
+	cr := csv.NewReader(r)
+	cr.ReuseRecord = true
+	// Short rows are reported per row, missing cells are empty
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			err = errors.New("missing header row")
+		}
+		return report, http.StatusBadRequest, err
+	}
+	columns, err := importColumns(header, opts.Mapping)
+	if err != nil {
+		return report, http.StatusBadRequest, err
+	}
+	if op == BatchUpsert && columns.stock >= 0 && !opts.AllowStockUpdates {
+		return report, http.StatusForbidden, ErrStockUpdate
+	}
+
+	var ops []BatchOp
+	var lines []int
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return report, http.StatusBadRequest, err
+		}
+		if report.Rows++; report.Rows > MaxImportRows {
+			return report, http.StatusBadRequest, fmt.Errorf("an import must have at most %d rows", MaxImportRows)
+		}
+		line, _ := cr.FieldPos(0)
+		row, err := columns.parse(record)
+		row.Op = op
+		if err != nil {
+			report.Errors = append(report.Errors, ImportError{Line: line, ID: row.ID, Status: http.StatusBadRequest, Error: err.Error()})
+			continue
+		}
+		ops = append(ops, row)
+		lines = append(lines, line)
+	}
+	if report.Rows == 0 {
+		return report, http.StatusBadRequest, errors.New("no rows to import")
+	}
+
+	// If some rows did not parse, the rows that did still get the checks,
+	// but nothing is written
+	results, committed, err := i.runBatch(ctx, ops, batchAllOrNothing, opts.DryRun || len(report.Errors) > 0)
+	if err != nil {
+		return report, storeStatus(err), err
+	}
+	for _, res := range results {
+		switch {
+		case res.Error != "":
+			report.Errors = append(report.Errors, ImportError{Line: lines[res.Index], ID: res.ID, Status: res.Status, Error: res.Error})
+		case res.Status == http.StatusCreated:
+			report.Created++
+		default:
+			report.Updated++
+		}
+	}
+	if len(report.Errors) > 0 {
+		// Parse errors come first, order the report by line
+		slices.SortStableFunc(report.Errors, func(a, b ImportError) int { return a.Line - b.Line })
+		return report, http.StatusUnprocessableEntity, fmt.Errorf("%d of %d rows failed", len(report.Errors), report.Rows)
+	}
+	observability.Logger(ctx).InfoContext(ctx, "Products imported",
+		"rows", report.Rows, "created", report.Created, "updated", report.Updated, "committed", committed)
+	return report, http.StatusOK, nil
+}
+
+// csvColumnIndexes are the positions of the product fields in a record, -1
+// for fields without a column.
+type csvColumnIndexes struct {
+	id, name, price, stock int
+}
+
+func importColumns(header []string, mapping map[string]string) (csvColumnIndexes, error) {
+	cols := csvColumnIndexes{-1, -1, -1, -1}
+	fields := map[string]*int{"id": &cols.id, "name": &cols.name, "price": &cols.price, "stock": &cols.stock}
+	// Headers that are not mapped are ignored, the mapping is case insensitive
+	lookup := make(map[string]string, len(mapping))
+	for h, f := range mapping {
+		if _, ok := fields[f]; !ok {
+			return cols, fmt.Errorf("unknown product field %q in mapping", f)
+		}
+		lookup[strings.ToLower(strings.TrimSpace(h))] = f
+	}
+	found := 0
+	for idx, h := range header {
+		if idx == 0 {
+			// Spreadsheets often start UTF-8 files with a byte order mark
+			h = strings.TrimPrefix(h, "\ufeff")
+		}
+		h = strings.ToLower(strings.TrimSpace(h))
+		if mapping != nil {
+			h = lookup[h]
+		}
+		target, ok := fields[h]
+		if !ok {
+			continue
+		}
+		if *target >= 0 {
+			return cols, fmt.Errorf("more than one column for field %s", h)
+		}
+		*target = idx
+		found++
+	}
+	if found == 0 {
+		return cols, errors.New("no column matches a product field")
+	}
+	if found < len(lookup) {
+		return cols, errors.New("mapping names headers that are not in the CSV")
+	}
+	return cols, nil
+}
+
+// escapeCell prefixes text that spreadsheets would run as a formula with a
+// quote, which they show as text. Text starting with a quote that would be
+// taken for an escape is escaped as well, so that unescapeCell restores
+// every value.
+func escapeCell(v string) string {
+	if needsEscape(v) {
+		return "'" + v
+	}
+	return v
+}
+
+func unescapeCell(v string) string {
+	if rest, ok := strings.CutPrefix(v, "'"); ok && needsEscape(rest) {
+		return rest
+	}
+	return v
+}
+
+func needsEscape(v string) bool {
+	if v == "" {
+		return false
+	}
+	switch v[0] {
+	case '=', '+', '-', '@', '\t', '\r':
+		return true
+	case '\'':
+		return needsEscape(v[1:])
+	}
+	return false
+}
+
+// parse reads a row. Empty cells leave the field unset, so upserts keep the
+// stored value.
+func (cols csvColumnIndexes) parse(record []string) (BatchOp, error) {
+	var op BatchOp
+	cell := func(idx int) string {
+		if idx < 0 || idx >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[idx])
+	}
+	op.ID = unescapeCell(cell(cols.id))
+	if v := unescapeCell(cell(cols.name)); v != "" {
+		op.Name = &v
+	}
+	if v := cell(cols.price); v != "" {
+		price, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return op, fmt.Errorf("invalid price %q: must be a number", v)
+		}
+		op.Price = &price
+	}
+	if v := cell(cols.stock); v != "" {
+		stock, err := strconv.Atoi(v)
+		if err != nil {
+			return op, fmt.Errorf("invalid stock %q: must be an integer", v)
+		}
+		op.Stock = &stock
+	}
+	return op, nil
+}
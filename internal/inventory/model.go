@@ -2,16 +2,70 @@
 package inventory
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
 	"time"
 
 	"github.com/jacobtrvl/inventory-management/internal/store"
 	"github.com/jacobtrvl/inventory-management/pkg/observability"
 )
 
+// ProductsTable is the default table name of products.
+const ProductsTable = "products"
+
+var ErrQuotaExceeded = errors.New("product quota exceeded")
+
+// ErrUnknownTenant is returned for tenants that never added products.
+var ErrUnknownTenant = errors.New("unknown tenant")
+
 type Inventory struct {
 	tableName string
 	db        store.Store
 	mc        *observability.MetricsCollector
+	// product quota of tenants without an override, zero is unlimited
+	quota  int
+	quotas map[string]int
+
+	mu      sync.Mutex
+	tenants map[string]*tenantState
+}
+
+// Option customizes the inventory created by NewInventory.
+type Option func(*Inventory)
+
+// tenantState is the table and the metrics of a tenant.
+type tenantState struct {
+	id         string
+	products   store.Table[string, Product]
+	mc         *observability.MetricsCollector
+	ownMetrics bool
+
+	// lock serializes inserts and deletes, so that count stays exact. It is a
+	// one slot channel, so that requests waiting for it can give up.
+	lock chan struct{}
+	// number of products, -1 until first needed
+	count int
+}
+
+// acquire takes the lock of the tenant. Returns the error of ctx if it is
+// done first, the caller then does not hold the lock.
+func (t *tenantState) acquire(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	select {
+	case t.lock <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (t *tenantState) release() {
+	<-t.lock
 }
 
 type Product struct {
@@ -47,3 +101,26 @@ type UpdateRequest struct {
 	Price *float64 `json:"price,omitempty"`
 	Stock *int     `json:"stock,omitempty"`
 }
+
+// validate checks the fields the request sets. Add, Update, batches and CSV
+// imports all write products through it.
+func (req UpdateRequest) validate() error {
+	if req.Price != nil {
+		if p := *req.Price; math.IsNaN(p) || math.IsInf(p, 0) || p < 0 {
+			return fmt.Errorf("invalid price %v: must be a non-negative number", p)
+		}
+	}
+	if req.Stock != nil && *req.Stock < 0 {
+		return fmt.Errorf("invalid stock %d: must be a non-negative integer", *req.Stock)
+	}
+	return nil
+}
+
+func (req CreateRequest) validate() error {
+	return UpdateRequest{Price: &req.Price, Stock: &req.Stock}.validate()
+}
+
+// StockRequest adds Delta to the stock of a product, negative values remove stock.
+type StockRequest struct {
+	Delta int `json:"delta"`
+}
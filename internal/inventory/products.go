@@ -8,29 +8,150 @@ package inventory
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log/slog"
+	"iter"
+	"maps"
 	"net/http"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/jacobtrvl/inventory-management/internal/store"
+	"github.com/jacobtrvl/inventory-management/internal/tenant"
 	"github.com/jacobtrvl/inventory-management/pkg/observability"
 )
 
-func NewInventory(ctx context.Context, table string, db *store.MemDb, mc *observability.MetricsCollector) *Inventory {
-	db.CreateTable(table)
-	return &Inventory{
+// NewInventory stores the products of each tenant in its own table, named
+// after table. The default tenant uses table itself, so single tenant
+// deployments are unaffected. mc collects the metrics of the default tenant,
+// other tenants get their own collector.
+func NewInventory(ctx context.Context, table string, db store.Store, mc *observability.MetricsCollector, opts ...Option) *Inventory {
+	i := &Inventory{
 		tableName: table,
 		db:        db,
 		mc:        mc,
+		quotas:    make(map[string]int),
+		tenants:   make(map[string]*tenantState),
 	}
+	for _, o := range opts {
+		o(i)
+	}
+	// Failures are logged, requests retry them
+	i.tenant(ctx, true)
+	return i
+}
+
+// WithProductQuota limits the number of products of every tenant. Zero is unlimited.
+func WithProductQuota(n int) Option {
+	return func(i *Inventory) {
+		i.quota = n
+	}
+}
+
+// WithTenantQuotas overrides the product quota of single tenants.
+func WithTenantQuotas(quotas map[string]int) Option {
+	return func(i *Inventory) {
+		maps.Copy(i.quotas, quotas)
+	}
+}
+
+// TableName returns the table holding the products of a tenant. Tenant IDs
+// cannot contain "/", so the names never collide with other tables.
+func TableName(table, tenantID string) string {
+	if tenantID == tenant.Default {
+		return table
+	}
+	return tenantID + "/" + table
+}
+
+// tenant returns the state of the tenant of the request. Writes that can
+// add products pass create, which makes the table of a new tenant. Other
+// calls fail with ErrUnknownTenant for tenants without a table, so that
+// requests naming made up tenants leave nothing behind.
+func (i *Inventory) tenant(ctx context.Context, create bool) (*tenantState, error) {
+	id := tenant.FromContext(ctx)
+	i.mu.Lock()
+	t, ok := i.tenants[id]
+	i.mu.Unlock()
+	if ok {
+		return t, nil
+	}
+	if !create {
+		// Tables of tenants may be left from an earlier run of a persistent store
+		if _, _, err := i.db.ReadRangeContext(ctx, TableName(i.tableName, id), 0, 0); err != nil {
+			if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+				return nil, err
+			}
+			return nil, fmt.Errorf("%w: %s", ErrUnknownTenant, id)
+		}
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if t, ok := i.tenants[id]; ok {
+		return t, nil
+	}
+	// Nothing is kept if the table cannot be created, the next call tries again
+	products, err := store.NewTable[string, Product](i.db, TableName(i.tableName, id))
+	if err != nil {
+		observability.Logger(ctx).ErrorContext(ctx, "Failed to create products table", "tenant", id, "error", err)
+		return nil, err
+	}
+	t = &tenantState{
+		id:       id,
+		products: products,
+		lock:     make(chan struct{}, 1),
+		mc:       i.mc,
+		count:    -1,
+	}
+	if id != tenant.Default {
+		t.mc = observability.NewMetricsCollector()
+		t.ownMetrics = true
+	}
+	i.tenants[id] = t
+	return t, nil
+}
+
+func (i *Inventory) quotaOf(id string) int {
+	if q, ok := i.quotas[id]; ok {
+		return q
+	}
+	return i.quota
+}
+
+// reserve checks the product quota before an insert. Must be called with the lock of t held.
+func (i *Inventory) reserve(ctx context.Context, t *tenantState) error {
+	quota := i.quotaOf(t.id)
+	if quota <= 0 {
+		return nil
+	}
+	if t.count < 0 {
+		items, err := t.products.ReadAllContext(ctx)
+		if err != nil {
+			return err
+		}
+		t.count = len(items)
+	}
+	if t.count >= quota {
+		return fmt.Errorf("%w: tenant %s is limited to %d products", ErrQuotaExceeded, t.id, quota)
+	}
+	return nil
 }
 
 func (i *Inventory) Add(ctx context.Context, req CreateRequest) (string, int, error) {
-	_, err := i.db.Read(i.tableName, req.ID)
+	t, err := i.tenant(ctx, true)
+	if err != nil {
+		return "", storeStatus(err), err
+	}
+	// Serializes inserts and deletes of the tenant, so that the quota holds
+	if err := t.acquire(ctx); err != nil {
+		t.mc.RecordOperation(observability.OpInsert, false)
+		return "", storeStatus(err), err
+	}
+	defer t.release()
+	_, err = t.products.ReadContext(ctx, req.ID)
 	if err == nil {
-		i.mc.RecordOperation(observability.OpInsert, false)
+		t.mc.RecordOperation(observability.OpInsert, false)
 		return "", http.StatusConflict, fmt.Errorf("product with ID %s already exists", req.ID)
 	}
 	product := Product{
@@ -48,91 +169,183 @@ func (i *Inventory) Add(ctx context.Context, req CreateRequest) (string, int, er
 	// Validate ID length. In a real system, more validations would be needed.
 	// Some validations can be done during request binding in the API layer
 	if len(product.ID) > 255 {
-		i.mc.RecordOperation(observability.OpInsert, false)
+		t.mc.RecordOperation(observability.OpInsert, false)
 		return "", http.StatusBadRequest, fmt.Errorf("product ID too long: maximum 255 characters, got %d", len(product.ID))
 	}
-	err = i.db.Write(i.tableName, product.ID, product)
+	if err := req.validate(); err != nil {
+		t.mc.RecordOperation(observability.OpInsert, false)
+		return "", http.StatusBadRequest, err
+	}
+	if err := i.reserve(ctx, t); err != nil {
+		t.mc.RecordOperation(observability.OpInsert, false)
+		if errors.Is(err, ErrQuotaExceeded) {
+			return "", http.StatusForbidden, err
+		}
+		return "", storeStatus(err), err
+	}
+	err = t.products.WriteContext(ctx, product.ID, product)
 	if err != nil {
-		i.mc.RecordOperation(observability.OpInsert, false)
-		slog.ErrorContext(ctx, "Failed to add product", "id", product.ID, "error", err)
-		return "", http.StatusInternalServerError, err
+		t.mc.RecordOperation(observability.OpInsert, false)
+		observability.Logger(ctx).ErrorContext(ctx, "Failed to add product", "id", product.ID, "error", err)
+		return "", storeStatus(err), err
+	}
+	if t.count >= 0 {
+		t.count++
 	}
-	i.mc.RecordOperation(observability.OpInsert, true)
-	slog.DebugContext(ctx, "Product added", "id", product.ID)
+	t.mc.RecordOperation(observability.OpInsert, true)
+	recordChange(ctx, nil, &product)
+	observability.Logger(ctx).DebugContext(ctx, "Product added", "id", product.ID)
 	return product.ID, http.StatusCreated, nil
 }
 
 func (i *Inventory) Get(ctx context.Context, id string) (Product, int, error) {
-	item, err := i.db.Read(i.tableName, id)
+	t, err := i.tenant(ctx, false)
 	if err != nil {
-		i.mc.RecordOperation(observability.OpGet, false)
-		return Product{}, http.StatusNotFound, err
+		return Product{}, readStatus(err), err
 	}
-	i.mc.RecordOperation(observability.OpGet, true)
-	slog.DebugContext(ctx, "Product retrieved", "id", id)
-	return item.(Product), http.StatusOK, nil
+	product, err := t.products.ReadContext(ctx, id)
+	if err != nil {
+		t.mc.RecordOperation(observability.OpGet, false)
+		return Product{}, readStatus(err), err
+	}
+	t.mc.RecordOperation(observability.OpGet, true)
+	observability.Logger(ctx).DebugContext(ctx, "Product retrieved", "id", id)
+	return product, http.StatusOK, nil
 }
 
 func (i *Inventory) Update(ctx context.Context, id string, req UpdateRequest) (int, error) {
-	product, err := i.db.Read(i.tableName, id)
+	t, err := i.tenant(ctx, false)
 	if err != nil {
-		i.mc.RecordOperation(observability.OpUpdate, false)
-		return http.StatusNotFound, err
+		return readStatus(err), err
 	}
-	pd := product.(Product)
-	if req.Name != nil {
-		pd.Name = *req.Name
+	if err := req.validate(); err != nil {
+		t.mc.RecordOperation(observability.OpUpdate, false)
+		return http.StatusBadRequest, err
 	}
-	if req.Price != nil {
-		pd.Price = *req.Price
+	before, pd, status, err := t.modify(ctx, id, func(pd *Product) (int, error) {
+		req.apply(pd)
+		return 0, nil
+	})
+	if err != nil {
+		t.mc.RecordOperation(observability.OpUpdate, false)
+		if status >= http.StatusInternalServerError {
+			observability.Logger(ctx).ErrorContext(ctx, "Failed to update product", "id", id, "error", err)
+		}
+		return status, err
 	}
-	if req.Stock != nil {
-		pd.Stock = *req.Stock
+
+	t.mc.RecordOperation(observability.OpUpdate, true)
+	recordChange(ctx, &before, &pd)
+	observability.Logger(ctx).DebugContext(ctx, "Product updated", "id", id)
+	return http.StatusOK, nil
+}
+
+// AdjustStock changes the stock of a product by delta and returns the updated product.
+// The stock cannot drop below zero.
+func (i *Inventory) AdjustStock(ctx context.Context, id string, delta int) (Product, int, error) {
+	t, err := i.tenant(ctx, false)
+	if err != nil {
+		return Product{}, readStatus(err), err
 	}
-	pd.UpdatedAt = time.Now()
-	if err := i.db.Write(i.tableName, id, pd); err != nil {
-		i.mc.RecordOperation(observability.OpUpdate, false)
-		slog.ErrorContext(ctx, "Failed to update product", "id", id, "error", err)
-		return http.StatusInternalServerError, err
+	before, pd, status, err := t.modify(ctx, id, func(pd *Product) (int, error) {
+		if pd.Stock+delta < 0 {
+			return http.StatusConflict, fmt.Errorf("insufficient stock: %d available, %d requested", pd.Stock, -delta)
+		}
+		pd.Stock += delta
+		return 0, nil
+	})
+	if err != nil {
+		t.mc.RecordOperation(observability.OpUpdate, false)
+		if status >= http.StatusInternalServerError {
+			observability.Logger(ctx).ErrorContext(ctx, "Failed to adjust stock", "id", id, "error", err)
+		}
+		return Product{}, status, err
 	}
+	t.mc.RecordOperation(observability.OpUpdate, true)
+	recordChange(ctx, &before, &pd)
+	observability.Logger(ctx).DebugContext(ctx, "Stock adjusted", "id", id, "delta", delta, "stock", pd.Stock)
+	return pd, http.StatusOK, nil
+}
 
-	i.mc.RecordOperation(observability.OpUpdate, true)
-	slog.DebugContext(ctx, "Product updated", "id", id)
-	return http.StatusOK, nil
+// modify reads a product, changes it with fn and writes it back in a single
+// batch of the table, so that concurrent changes are not lost and checks of
+// fn hold when the product is written. fn returns the status of its errors.
+// Returns the product before and after the change.
+func (t *tenantState) modify(ctx context.Context, id string, fn func(pd *Product) (int, error)) (Product, Product, int, error) {
+	var before, after Product
+	status := 0
+	err := t.products.BatchContext(ctx, func(tx productsTx) error {
+		var err error
+		before, err = tx.Read(id)
+		if err != nil {
+			status = readStatus(err)
+			return err
+		}
+		after = before
+		if status, err = fn(&after); err != nil {
+			return err
+		}
+		after.UpdatedAt = time.Now()
+		return tx.Write(id, after)
+	})
+	if err != nil {
+		if status == 0 {
+			status = storeStatus(err)
+		}
+		return Product{}, Product{}, status, err
+	}
+	return before, after, http.StatusOK, nil
 }
 
 func (i *Inventory) Delete(ctx context.Context, id string) (int, error) {
-	_, err := i.db.Read(i.tableName, id)
+	t, err := i.tenant(ctx, false)
+	if err != nil {
+		return readStatus(err), err
+	}
+	if err := t.acquire(ctx); err != nil {
+		t.mc.RecordOperation(observability.OpDelete, false)
+		return storeStatus(err), err
+	}
+	defer t.release()
+	before, err := t.products.ReadContext(ctx, id)
 	if err != nil {
-		i.mc.RecordOperation(observability.OpDelete, false)
-		return http.StatusNotFound, err
+		t.mc.RecordOperation(observability.OpDelete, false)
+		return readStatus(err), err
 	}
-	if err := i.db.Delete(i.tableName, id); err != nil {
-		i.mc.RecordOperation(observability.OpDelete, false)
-		slog.ErrorContext(ctx, "Failed to delete product", "id", id, "error", err)
-		return http.StatusInternalServerError, err
+	if err := t.products.DeleteContext(ctx, id); err != nil {
+		t.mc.RecordOperation(observability.OpDelete, false)
+		observability.Logger(ctx).ErrorContext(ctx, "Failed to delete product", "id", id, "error", err)
+		return storeStatus(err), err
 	}
-	i.mc.RecordOperation(observability.OpDelete, true)
-	slog.DebugContext(ctx, "Product deleted", "id", id)
+	if t.count > 0 {
+		t.count--
+	}
+	t.mc.RecordOperation(observability.OpDelete, true)
+	recordChange(ctx, &before, nil)
+	observability.Logger(ctx).DebugContext(ctx, "Product deleted", "id", id)
 	return http.StatusOK, nil
 }
 
 // List returns a list of products based on the provided ListParams.
 // Returns Products slice, EOF status, and error (if any).
 func (i *Inventory) List(ctx context.Context, params ListParams) ([]Product, *ListMetadata, int, error) {
+	t, err := i.tenant(ctx, false)
+	if err != nil {
+		return nil, nil, readStatus(err), err
+	}
 	// Filtering is not supported due to in-memory DB limitations.
 	// Without an underlying DB with query capabilities filtering can be error-prone and inefficient.
 	if params.Page == nil && params.Limit == nil {
 		list, err := i.GetAllItems(ctx)
 		if err != nil {
-			i.mc.RecordOperation(observability.OpList, false)
-			return nil, nil, http.StatusInternalServerError, err
+			t.mc.RecordOperation(observability.OpList, false)
+			return nil, nil, storeStatus(err), err
 		}
-		i.mc.RecordOperation(observability.OpList, true)
+		t.mc.RecordOperation(observability.OpList, true)
 		return list, nil, http.StatusOK, nil
 	}
 	if params.Page == nil || params.Limit == nil {
-		i.mc.RecordOperation(observability.OpList, false)
+		t.mc.RecordOperation(observability.OpList, false)
 		return nil, nil, http.StatusBadRequest, fmt.Errorf("both page and limit must be provided")
 	}
 	page := *params.Page
@@ -140,15 +353,15 @@ func (i *Inventory) List(ctx context.Context, params ListParams) ([]Product, *Li
 
 	list, eof, err := i.NoFilter(ctx, (page-1)*limit, page*limit)
 	if err != nil {
-		i.mc.RecordOperation(observability.OpList, false)
-		return nil, nil, http.StatusInternalServerError, err
+		t.mc.RecordOperation(observability.OpList, false)
+		return nil, nil, storeStatus(err), err
 	}
 	var nextPage *int
 	if !eof {
 		np := page + 1
 		nextPage = &np
 	}
-	i.mc.RecordOperation(observability.OpList, true)
+	t.mc.RecordOperation(observability.OpList, true)
 	return list, &ListMetadata{
 		CurrentPage: page,
 		NextPage:    nextPage,
@@ -156,35 +369,105 @@ func (i *Inventory) List(ctx context.Context, params ListParams) ([]Product, *Li
 }
 
 func (i *Inventory) NoFilter(ctx context.Context, start, end int) ([]Product, bool, error) {
-	var unfiltered []Product
-	items, eof, err := i.db.ReadRange(i.tableName, start, end)
+	t, err := i.tenant(ctx, false)
 	if err != nil {
-		i.mc.RecordOperation(observability.OpList, false)
-		slog.ErrorContext(ctx, "Failed to retrieve products", "error", err)
 		return nil, false, err
 	}
-	for _, item := range items {
-		unfiltered = append(unfiltered, item.(Product))
+	unfiltered, eof, err := t.products.ReadRangeContext(ctx, start, end)
+	if err != nil {
+		t.mc.RecordOperation(observability.OpList, false)
+		observability.Logger(ctx).ErrorContext(ctx, "Failed to retrieve products", "error", err)
+		return nil, false, err
 	}
-
 	return unfiltered, eof, nil
 }
 
 func (i *Inventory) GetAllItems(ctx context.Context) ([]Product, error) {
-	items, err := i.db.ReadAll(i.tableName)
+	t, err := i.tenant(ctx, false)
 	if err != nil {
-		slog.ErrorContext(ctx, "Failed to retrieve all products", "error", err)
 		return nil, err
 	}
-	products := make([]Product, 0, len(items))
-	for _, item := range items {
-		products = append(products, item.(Product))
+	products, err := t.products.ReadAllContext(ctx)
+	if err != nil {
+		observability.Logger(ctx).ErrorContext(ctx, "Failed to retrieve all products", "error", err)
+		return nil, err
 	}
 	return products, nil
 }
 
-func (i *Inventory) GetStats() map[string]int64 {
-	return i.mc.GetStats()
+// Products between flushes of an export
+const scanPageSize = 1000
+
+// All iterates over the products of the tenant without copying the table.
+// The store lock is only held while a chunk of products is read, see
+// store.MemDb.Scan. Products changed during the iteration may or may not be
+// seen. Iteration stops at the first error, including cancellation of ctx,
+// which the store checks before every chunk.
+func (i *Inventory) All(ctx context.Context) iter.Seq2[Product, error] {
+	return func(yield func(Product, error) bool) {
+		t, err := i.tenant(ctx, false)
+		if err != nil {
+			yield(Product{}, err)
+			return
+		}
+		for entry, err := range t.products.ScanContext(ctx, store.ScanOptions{}) {
+			if err != nil {
+				observability.Logger(ctx).ErrorContext(ctx, "Failed to read products", "error", err)
+				t.mc.RecordOperation(observability.OpList, false)
+				yield(Product{}, err)
+				return
+			}
+			if !yield(entry.Value, nil) {
+				return
+			}
+		}
+		t.mc.RecordOperation(observability.OpList, true)
+	}
+}
+
+// GetStats returns the operation metrics of the tenant of the request, none
+// for unknown tenants.
+func (i *Inventory) GetStats(ctx context.Context) map[string]int64 {
+	t, err := i.tenant(ctx, false)
+	if err != nil {
+		return nil
+	}
+	return t.mc.GetStats()
+}
+
+// Shutdown stops the metrics collectors of the tenants. The collector passed
+// to NewInventory is owned by the caller.
+func (i *Inventory) Shutdown() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for _, t := range i.tenants {
+		if t.ownMetrics {
+			t.mc.Shutdown()
+		}
+	}
+}
+
+// readStatus is the status of a failed read. Items of the wrong type are
+// server errors, requests that went away while waiting for the store are
+// reported like other store failures.
+func readStatus(err error) int {
+	if errors.Is(err, store.ErrType) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return storeStatus(err)
+	}
+	return http.StatusNotFound
+}
+
+// storeStatus is the status of a failed store operation. Requests whose
+// deadline passed while waiting for the table lock time out with 504, and
+// cancelled ones get 503, the client has most likely gone away.
+func storeStatus(err error) int {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, context.Canceled):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
 }
 
 func generateID() string {
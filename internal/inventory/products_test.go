@@ -3,10 +3,19 @@ package inventory
 
 import (
 	"context"
+	"encoding/csv"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/jacobtrvl/inventory-management/pkg/observability"
 	"github.com/jacobtrvl/inventory-management/internal/store"
+	"github.com/jacobtrvl/inventory-management/internal/tenant"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -70,6 +79,25 @@ func TestInventory(t *testing.T) {
 	_, err = inventory.Update(ctx, "2", UpdateRequest{})
 	assert.Error(err)
 
+	// Prices and stock must not be negative
+	_, status, err := inventory.Add(ctx, CreateRequest{ID: "3", Price: -1})
+	assert.Error(err)
+	assert.Equal(400, status)
+	price := -0.5
+	status, err = inventory.Update(ctx, "1", UpdateRequest{Price: &price})
+	assert.Error(err)
+	assert.Equal(400, status)
+
+	// Test AdjustStock
+	product, _, err = inventory.AdjustStock(ctx, "1", -30)
+	assert.NoError(err)
+	assert.Equal(70, product.Stock)
+	_, status, err = inventory.AdjustStock(ctx, "1", -71)
+	assert.Error(err)
+	assert.Equal(409, status)
+	_, _, err = inventory.AdjustStock(ctx, "2", 1)
+	assert.Error(err)
+
 	// Test Delete
 	_, err = inventory.Delete(ctx, "1")
 	assert.NoError(err)
@@ -86,3 +114,434 @@ func TestInventory(t *testing.T) {
 	assert.NoError(err)
 	assert.Empty(products)
 }
+
+func TestTenants(t *testing.T) {
+	ctx := context.Background()
+	assert := assert.New(t)
+	db := store.NewMemDb()
+	inv := NewInventory(ctx, "products", db, observability.NewMetricsCollector(),
+		WithProductQuota(2), WithTenantQuotas(map[string]int{"brand-b": 1}))
+	defer inv.Shutdown()
+	a := tenant.ContextWith(ctx, "brand-a")
+	b := tenant.ContextWith(ctx, "brand-b")
+
+	_, _, err := inv.Add(a, CreateRequest{ID: "1", Name: "A"})
+	assert.NoError(err)
+	// The same ID in another tenant is another product
+	_, _, err = inv.Add(b, CreateRequest{ID: "1", Name: "B"})
+	assert.NoError(err)
+
+	p, _, err := inv.Get(b, "1")
+	assert.NoError(err)
+	assert.Equal("B", p.Name)
+	_, _, err = inv.Get(ctx, "1")
+	assert.Error(err, "not visible to the default tenant")
+	_, err = inv.Delete(ctx, "1")
+	assert.Error(err)
+
+	items, err := db.ReadAll("brand-a/products")
+	assert.NoError(err)
+	assert.Len(items, 1)
+
+	// Quotas
+	_, _, err = inv.Add(a, CreateRequest{ID: "2"})
+	assert.NoError(err)
+	_, status, err := inv.Add(a, CreateRequest{ID: "3"})
+	assert.ErrorIs(err, ErrQuotaExceeded)
+	assert.Equal(403, status)
+	_, _, err = inv.Add(b, CreateRequest{ID: "2"})
+	assert.ErrorIs(err, ErrQuotaExceeded, "override")
+	_, err = inv.Delete(a, "2")
+	assert.NoError(err)
+	_, _, err = inv.Add(a, CreateRequest{ID: "3"})
+	assert.NoError(err, "deletes free the quota")
+
+	// Reads of tenants that never added products leave nothing behind
+	unknown := tenant.ContextWith(ctx, "brand-c")
+	_, status, err = inv.Get(unknown, "1")
+	assert.ErrorIs(err, ErrUnknownTenant)
+	assert.Equal(404, status)
+	_, _, status, err = inv.List(unknown, ListParams{})
+	assert.ErrorIs(err, ErrUnknownTenant)
+	assert.Equal(404, status)
+	_, err = inv.Delete(unknown, "1")
+	assert.ErrorIs(err, ErrUnknownTenant)
+	assert.Nil(inv.GetStats(unknown))
+	_, err = db.ReadAll("brand-c/products")
+	assert.Error(err, "no table created")
+	assert.NotContains(inv.tenants, "brand-c")
+}
+
+func TestBatch(t *testing.T) {
+	ctx := context.Background()
+	assert := assert.New(t)
+	inv := NewInventory(ctx, "products", store.NewMemDb(), observability.NewMetricsCollector(), WithProductQuota(3))
+	name := func(s string) UpdateRequest { return UpdateRequest{Name: &s} }
+	negative := -1.0
+	_, _, err := inv.Add(ctx, CreateRequest{ID: "1", Name: "A"})
+	assert.NoError(err)
+
+	// Best effort reports every outcome
+	results, _, err := inv.Batch(ctx, []BatchOp{
+		{Op: BatchCreate, ID: "2", UpdateRequest: name("B")},
+		{Op: BatchCreate, ID: "1"},
+		{Op: BatchUpdate, ID: "1", UpdateRequest: name("A2")},
+		{Op: BatchDelete, ID: "9"},
+		{Op: BatchCreate, UpdateRequest: name("generated")},
+		{Op: BatchCreate, ID: "4"},
+		{Op: BatchUpsert, ID: "1", UpdateRequest: name("A2")},
+		{Op: "merge", ID: "1"},
+		{Op: BatchUpsert, ID: "1", UpdateRequest: UpdateRequest{Price: &negative}},
+	}, false)
+	assert.NoError(err)
+	statuses := make([]int, len(results))
+	for i, res := range results {
+		statuses[i] = res.Status
+	}
+	assert.Equal([]int{201, 409, 200, 404, 201, 403, 200, 400, 400}, statuses)
+	assert.NotEmpty(results[4].ID)
+	p, _, _ := inv.Get(ctx, "1")
+	assert.Equal("A2", p.Name)
+
+	// Atomic batches roll back on the first failure
+	results, status, err := inv.Batch(ctx, []BatchOp{
+		{Op: BatchDelete, ID: "1"},
+		{Op: BatchUpdate, ID: "2", UpdateRequest: name("B2")},
+		{Op: BatchUpdate, ID: "9"},
+	}, true)
+	assert.Error(err)
+	assert.Equal(404, status)
+	assert.Equal([]BatchResult{{Index: 2, ID: "9", Status: 404, Error: results[0].Error}}, results)
+	all, _ := inv.GetAllItems(ctx)
+	assert.Len(all, 3)
+	p, _, _ = inv.Get(ctx, "2")
+	assert.Equal("B", p.Name)
+
+	ctx, changes := TrackChanges(ctx)
+	_, _, err = inv.Batch(ctx, []BatchOp{{Op: BatchDelete, ID: "1"}, {Op: BatchDelete, ID: "2"}}, true)
+	assert.NoError(err)
+	assert.Len(changes(), 2)
+	// The quota counts the batch
+	for _, id := range []string{"5", "6"} {
+		_, _, err = inv.Add(ctx, CreateRequest{ID: id})
+		assert.NoError(err)
+	}
+	_, _, err = inv.Add(ctx, CreateRequest{ID: "7"})
+	assert.ErrorIs(err, ErrQuotaExceeded)
+
+	_, status, _ = inv.Batch(ctx, nil, false)
+	assert.Equal(400, status)
+}
+
+func TestCSV(t *testing.T) {
+	ctx := context.Background()
+	assert := assert.New(t)
+	inv := NewInventory(ctx, "products", store.NewMemDb(), observability.NewMetricsCollector(), WithProductQuota(3))
+
+	report, status, err := inv.ImportCSV(ctx, strings.NewReader("\ufeffID,Name,Price,Stock\n1,Pen,1.5,10\n2,Ink,-1,5\n1,Dup,1,1\n3,Short\n"), ImportOptions{})
+	assert.Error(err)
+	assert.Equal(422, status)
+	assert.Equal(4, report.Rows)
+	assert.Equal([]int{3, 4}, []int{report.Errors[0].Line, report.Errors[1].Line})
+	assert.Equal([]int{400, 409}, []int{report.Errors[0].Status, report.Errors[1].Status})
+	all, _ := inv.GetAllItems(ctx)
+	assert.Empty(all, "all or nothing")
+
+	report, status, err = inv.ImportCSV(ctx, strings.NewReader("id,name\n1,Pen\n2,Ink\n"), ImportOptions{DryRun: true})
+	assert.NoError(err)
+	assert.Equal(200, status)
+	assert.Equal(ImportReport{Rows: 2, Created: 2, DryRun: true}, report)
+	all, _ = inv.GetAllItems(ctx)
+	assert.Empty(all, "dry run")
+
+	_, _, err = inv.ImportCSV(ctx, strings.NewReader("sku,title,qty\n1,Pen,5\n2,Ink,7\n"),
+		ImportOptions{Mapping: map[string]string{"SKU": "id", "Title": "name", "Qty": "stock"}})
+	assert.NoError(err)
+	_, status, err = inv.ImportCSV(ctx, strings.NewReader("id,stock\n1,50\n"), ImportOptions{Mode: ImportUpsert})
+	assert.ErrorIs(err, ErrStockUpdate)
+	assert.Equal(403, status)
+	report, _, err = inv.ImportCSV(ctx, strings.NewReader("id,price,stock\n1,2,50\n3,4,\n"), ImportOptions{Mode: ImportUpsert, AllowStockUpdates: true})
+	assert.NoError(err)
+	assert.Equal(1, report.Created)
+	assert.Equal(1, report.Updated)
+	p, _, _ := inv.Get(ctx, "1")
+	assert.Equal(Product{ID: "1", Name: "Pen", Price: 2, Stock: 50}, Product{ID: p.ID, Name: p.Name, Price: p.Price, Stock: p.Stock})
+	_, status, _ = inv.ImportCSV(ctx, strings.NewReader("id,name\n4,Pad\n"), ImportOptions{})
+	assert.Equal(422, status, "quota")
+
+	for _, in := range []string{"", "color\nred\n", "id\n", "id,ID\n1,1\n"} {
+		_, status, _ = inv.ImportCSV(ctx, strings.NewReader(in), ImportOptions{})
+		assert.Equal(400, status, in)
+	}
+	_, status, _ = inv.ImportCSV(ctx, strings.NewReader("id\n1\n"), ImportOptions{Mode: "merge"})
+	assert.Equal(400, status)
+
+	var out strings.Builder
+	assert.NoError(inv.ExportCSV(ctx, &out))
+	records, err := csv.NewReader(strings.NewReader(out.String())).ReadAll()
+	assert.NoError(err)
+	assert.Len(records, 4)
+	assert.Equal([]string{"1", "Pen", "2", "50"}, records[1][:4])
+	// An export imports back unchanged
+	report, _, err = inv.ImportCSV(ctx, strings.NewReader(out.String()), ImportOptions{Mode: ImportUpsert, AllowStockUpdates: true})
+	assert.NoError(err)
+
+	// Formulas are exported as text, and import back as they were
+	names := []string{"=HYPERLINK(\"http://x\")", "+1", "-1", "@SUM(A1)", "'=1", "''+1", "'quoted"}
+	for n, name := range names {
+		_, err := inv.Update(ctx, strconv.Itoa(n%3+1), UpdateRequest{Name: &names[n]})
+		assert.NoError(err, name)
+		out.Reset()
+		assert.NoError(inv.ExportCSV(ctx, &out))
+		records, err := csv.NewReader(strings.NewReader(out.String())).ReadAll()
+		assert.NoError(err)
+		for _, record := range records[1:] {
+			assert.NotRegexp(`^[=+\-@]`, record[1])
+		}
+		_, _, err = inv.ImportCSV(ctx, strings.NewReader(out.String()), ImportOptions{Mode: ImportUpsert, AllowStockUpdates: true})
+		assert.NoError(err)
+		p, _, _ := inv.Get(ctx, strconv.Itoa(n%3+1))
+		assert.Equal(name, p.Name)
+	}
+	assert.Equal(3, report.Updated)
+}
+
+func TestAll(t *testing.T) {
+	ctx := context.Background()
+	assert := assert.New(t)
+	inv := NewInventory(ctx, "products", store.NewMemDb(), observability.NewMetricsCollector())
+	n := scanPageSize + 10
+	for i := range n {
+		_, _, err := inv.Add(ctx, CreateRequest{ID: strconv.Itoa(i)})
+		assert.NoError(err)
+	}
+
+	seen := 0
+	for p, err := range inv.All(ctx) {
+		assert.NoError(err)
+		assert.Equal(strconv.Itoa(seen), p.ID)
+		seen++
+	}
+	assert.Equal(n, seen, "across pages")
+
+	seen = 0
+	for range inv.All(ctx) {
+		if seen++; seen == 3 {
+			break
+		}
+	}
+	assert.Equal(3, seen)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	for _, err := range inv.All(cancelled) {
+		assert.ErrorIs(err, context.Canceled)
+	}
+}
+
+func TestBadData(t *testing.T) {
+	ctx := context.Background()
+	assert := assert.New(t)
+	db := store.NewMemDb()
+	inv := NewInventory(ctx, "products", db, observability.NewMetricsCollector())
+	assert.NoError(db.Write("products", "1", "not a product"))
+
+	_, status, err := inv.Get(ctx, "1")
+	assert.ErrorIs(err, store.ErrType)
+	assert.Equal(500, status)
+	_, err = inv.Update(ctx, "1", UpdateRequest{})
+	assert.ErrorIs(err, store.ErrType)
+	_, _, _, err = inv.List(ctx, ListParams{})
+	assert.ErrorIs(err, store.ErrType)
+	for _, err = range inv.All(ctx) {
+	}
+	assert.ErrorIs(err, store.ErrType)
+}
+
+func TestStoreContext(t *testing.T) {
+	ctx := context.Background()
+	assert := assert.New(t)
+	db := store.NewMemDb()
+	inv := NewInventory(ctx, "products", db, observability.NewMetricsCollector())
+	_, _, err := inv.Add(ctx, CreateRequest{ID: "1", Name: "p"})
+	assert.NoError(err)
+
+	// Requests waiting for a table held by a batch give up at their deadline
+	locked, release, done := make(chan struct{}), make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		db.Batch("products", func(store.Tx) error {
+			close(locked)
+			<-release
+			return nil
+		})
+	}()
+	<-locked
+	timeout, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	_, status, err := inv.Get(timeout, "1")
+	assert.ErrorIs(err, context.DeadlineExceeded)
+	assert.Equal(http.StatusGatewayTimeout, status)
+	_, status, err = inv.Add(timeout, CreateRequest{ID: "2"})
+	assert.ErrorIs(err, context.DeadlineExceeded)
+	assert.Equal(http.StatusGatewayTimeout, status)
+	close(release)
+	<-done
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	status, err = inv.Delete(cancelled, "1")
+	assert.ErrorIs(err, context.Canceled)
+	assert.Equal(http.StatusServiceUnavailable, status)
+	_, status, err = inv.Get(ctx, "1")
+	assert.NoError(err, "not deleted")
+	assert.Equal(http.StatusOK, status)
+
+	// Requests waiting for the tenant lock, held by an import or batch, give
+	// up the same way
+	ts, err := inv.tenant(ctx, true)
+	assert.NoError(err)
+	assert.NoError(ts.acquire(ctx))
+	timeout, cancel = context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	_, status, err = inv.Add(timeout, CreateRequest{ID: "2"})
+	assert.ErrorIs(err, context.DeadlineExceeded)
+	assert.Equal(http.StatusGatewayTimeout, status)
+	_, status, err = inv.Batch(timeout, []BatchOp{{Op: BatchDelete, ID: "1"}}, true)
+	assert.ErrorIs(err, context.DeadlineExceeded)
+	assert.Equal(http.StatusGatewayTimeout, status)
+	_, status, err = inv.ImportCSV(timeout, strings.NewReader("id\n3\n"), ImportOptions{})
+	assert.ErrorIs(err, context.DeadlineExceeded)
+	assert.Equal(http.StatusGatewayTimeout, status)
+	status, err = inv.Delete(cancelled, "1")
+	assert.ErrorIs(err, context.Canceled)
+	assert.Equal(http.StatusServiceUnavailable, status)
+	ts.release()
+	_, _, err = inv.Add(ctx, CreateRequest{ID: "2"})
+	assert.NoError(err, "lock released")
+}
+
+func TestConcurrentStock(t *testing.T) {
+	ctx := context.Background()
+	assert := assert.New(t)
+	// Reads are slowed down, so that a read and a write not done under one
+	// lock of the table interleave with other requests
+	db := store.Trace(store.NewMemDb(), func(_ context.Context, span store.Span) {
+		if span.Op == "Read" {
+			time.Sleep(time.Millisecond)
+		}
+	})
+	inv := NewInventory(ctx, "products", db, observability.NewMetricsCollector())
+	_, _, err := inv.Add(ctx, CreateRequest{ID: "1", Stock: 50})
+	assert.NoError(err)
+
+	// 100 removals of one item race for a stock of 50, and 20 updates of the
+	// name must not write back a stale stock
+	var wg sync.WaitGroup
+	var removed atomic.Int64
+	for range 100 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, status, err := inv.AdjustStock(ctx, "1", -1)
+			if err == nil {
+				removed.Add(1)
+				return
+			}
+			assert.Equal(http.StatusConflict, status)
+		}()
+	}
+	for n := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			name := strconv.Itoa(n)
+			_, err := inv.Update(ctx, "1", UpdateRequest{Name: &name})
+			assert.NoError(err)
+		}()
+	}
+	wg.Wait()
+
+	product, _, err := inv.Get(ctx, "1")
+	assert.NoError(err)
+	assert.Equal(int64(50), removed.Load())
+	assert.Equal(0, product.Stock)
+}
+
+func TestConcurrentUpdateDelete(t *testing.T) {
+	ctx := context.Background()
+	assert := assert.New(t)
+	db := store.Trace(store.NewMemDb(), func(_ context.Context, span store.Span) {
+		if span.Op == "Read" {
+			time.Sleep(time.Millisecond)
+		}
+	})
+	inv := NewInventory(ctx, "products", db, observability.NewMetricsCollector(), WithProductQuota(1))
+
+	// Updates racing with the delete must not bring the product back, nor
+	// leave the product count short, which would let the quota be exceeded
+	for n := range 10 {
+		id := strconv.Itoa(n)
+		_, _, err := inv.Add(ctx, CreateRequest{ID: id})
+		assert.NoError(err)
+		var wg sync.WaitGroup
+		for range 5 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				stock := 1
+				if _, err := inv.Update(ctx, id, UpdateRequest{Stock: &stock}); err != nil {
+					assert.ErrorContains(err, "not found")
+				}
+			}()
+		}
+		_, err = inv.Delete(ctx, id)
+		assert.NoError(err)
+		wg.Wait()
+		_, status, _ := inv.Get(ctx, id)
+		assert.Equal(http.StatusNotFound, status, "product %s deleted", id)
+	}
+	all, err := inv.GetAllItems(ctx)
+	assert.NoError(err)
+	assert.Empty(all)
+}
+
+// failingTables fails to create tables while fail is set.
+type failingTables struct {
+	store.Store
+	fail bool
+}
+
+func (f *failingTables) CreateTable(name string) error {
+	if f.fail {
+		return errors.New("disk full")
+	}
+	return f.Store.CreateTable(name)
+}
+
+func TestTableCreationFailure(t *testing.T) {
+	ctx := context.Background()
+	assert := assert.New(t)
+	db := &failingTables{Store: store.NewMemDb(), fail: true}
+	inv := NewInventory(ctx, "products", db, observability.NewMetricsCollector())
+	defer inv.Shutdown()
+	a := tenant.ContextWith(ctx, "brand-a")
+
+	for _, ctx := range []context.Context{ctx, a} {
+		_, status, err := inv.Add(ctx, CreateRequest{ID: "1"})
+		assert.ErrorContains(err, "disk full")
+		assert.Equal(500, status)
+		_, status, err = inv.Batch(ctx, []BatchOp{{Op: BatchCreate, ID: "1"}}, true)
+		assert.ErrorContains(err, "disk full")
+		assert.Equal(500, status)
+	}
+	assert.Empty(inv.tenants, "failed tenants are not kept")
+
+	db.fail = false
+	for _, ctx := range []context.Context{ctx, a} {
+		_, _, err := inv.Add(ctx, CreateRequest{ID: "1"})
+		assert.NoError(err, "retried")
+		_, _, err = inv.Get(ctx, "1")
+		assert.NoError(err)
+	}
+}
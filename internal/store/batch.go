@@ -0,0 +1,161 @@
+// Copyright 2025 Jacob Philip. All rights reserved.
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Tx is a batch of operations on a single table, see MemDb.Batch.
+type Tx interface {
+	Read(id any) (any, error)
+	Write(key any, item any) error
+	Delete(id any) error
+	// Len returns the number of items in the table, including the changes of
+	// the batch. If the store fails to count them, the batch fails as well.
+	Len() int
+}
+
+// ErrRollback can be returned by a batch function to discard its changes
+// without reporting an error of its own.
+var ErrRollback = errors.New("batch rolled back")
+
+// tombstone marks a slot deleted within a batch. Slots are only compacted
+// when the batch is done, so that undo entries keep valid indices.
+type tombstone struct{}
+
+type undo struct {
+	key   any
+	index int
+	old   any
+	// the key was not in the table before the change
+	added bool
+}
+
+// memTx applies the operations of a batch to a table whose lock it holds.
+type memTx struct {
+	table   string
+	v       *value
+	origLen int
+	undo    []undo
+	deleted int
+}
+
+// Batch runs fn with exclusive access to a table. All operations of fn take
+// the table lock once, instead of once per item. If fn returns an error, all
+// its changes are rolled back, so a batch can be all-or-nothing. Deletes
+// within the batch are compacted once at the end, not once per item.
+func (m *MemDb) Batch(table string, fn func(tx Tx) error) error {
+	return m.BatchContext(context.Background(), table, fn)
+}
+
+// BatchContext is Batch, giving up if ctx is done while waiting for the table
+// lock. Once fn runs, the batch is no longer affected by ctx.
+func (m *MemDb) BatchContext(ctx context.Context, table string, fn func(tx Tx) error) error {
+	v, err := m.getDataMap(table)
+	if err != nil {
+		return err
+	}
+	if err := v.mutex.lock(ctx, true); err != nil {
+		return err
+	}
+	defer v.mutex.Unlock()
+
+	tx := &memTx{table: table, v: v, origLen: len(v.dataSlice)}
+	returned := false
+	defer func() {
+		// fn panicked, tombstones must not leak out of the batch
+		if !returned {
+			tx.rollback()
+		}
+	}()
+	err = fn(tx)
+	returned = true
+	if err != nil {
+		tx.rollback()
+		return err
+	}
+	tx.compact()
+	return nil
+}
+
+func (tx *memTx) Read(id any) (any, error) {
+	index, exists := tx.v.indexMap[id]
+	if !exists {
+		return nil, fmt.Errorf("item with id %v not found in table %s", id, tx.table)
+	}
+	return tx.v.dataSlice[index], nil
+}
+
+func (tx *memTx) Write(key any, item any) error {
+	if index, exists := tx.v.indexMap[key]; exists {
+		tx.undo = append(tx.undo, undo{key: key, index: index, old: tx.v.dataSlice[index]})
+		tx.v.dataSlice[index] = item
+		return nil
+	}
+	tx.undo = append(tx.undo, undo{key: key, added: true})
+	tx.v.append(key, item)
+	return nil
+}
+
+func (tx *memTx) Delete(id any) error {
+	index, exists := tx.v.indexMap[id]
+	if !exists {
+		return fmt.Errorf("item with id %v not found in table %s", id, tx.table)
+	}
+	tx.undo = append(tx.undo, undo{key: id, index: index, old: tx.v.dataSlice[index]})
+	tx.v.dataSlice[index] = tombstone{}
+	delete(tx.v.indexMap, id)
+	tx.deleted++
+	return nil
+}
+
+func (tx *memTx) Len() int {
+	return len(tx.v.indexMap)
+}
+
+// rollback undoes the changes in reverse order. Slots appended by the batch
+// are dropped at the end, all other slots get their old item back.
+func (tx *memTx) rollback() {
+	for i := len(tx.undo) - 1; i >= 0; i-- {
+		u := tx.undo[i]
+		if u.added {
+			delete(tx.v.indexMap, u.key)
+			continue
+		}
+		tx.v.dataSlice[u.index] = u.old
+		tx.v.indexMap[u.key] = u.index
+	}
+	clear(tx.v.dataSlice[tx.origLen:])
+	tx.v.dataSlice = tx.v.dataSlice[:tx.origLen]
+	clear(tx.v.slots[tx.origLen:])
+	tx.v.slots = tx.v.slots[:tx.origLen]
+}
+
+// compact removes the slots deleted by the batch in a single pass.
+func (tx *memTx) compact() {
+	if tx.deleted == 0 {
+		return
+	}
+	data := tx.v.dataSlice
+	// removed[i] is the number of deleted slots before slot i
+	removed := make([]int, len(data))
+	n := 0
+	for i, item := range data {
+		removed[i] = i - n
+		if _, ok := item.(tombstone); ok {
+			continue
+		}
+		data[n] = item
+		tx.v.slots[n] = tx.v.slots[i]
+		n++
+	}
+	clear(data[n:])
+	tx.v.dataSlice = data[:n]
+	clear(tx.v.slots[n:])
+	tx.v.slots = tx.v.slots[:n]
+	for key, index := range tx.v.indexMap {
+		tx.v.indexMap[key] = index - removed[index]
+	}
+}
@@ -0,0 +1,592 @@
+// Copyright 2025 Jacob Philip. All rights reserved.
+// Package bolt implements store.Store on bbolt, an embedded ordered key value
+// database. Every transaction is written to disk before it returns, so
+// committed data survives crashes.
+// Each store table is a bucket with two nested buckets: items, keyed by a
+// big endian sequence number, and keys, mapping keys to their sequence
+// number. Cursors over items walk the insertion order of MemDb natively, and
+// scans resume by seeking past the last sequence number. Prefix scans seek
+// the keys bucket to the prefix instead.
+// Items are serialized with a Codec and read back as store.Encoded.
+// Keys must be strings.
+package bolt
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"iter"
+	"slices"
+	"time"
+
+	"github.com/jacobtrvl/inventory-management/internal/store"
+	bbolt "go.etcd.io/bbolt"
+)
+
+// ErrKeyType is returned for keys that are not strings.
+var ErrKeyType = errors.New("bolt store keys must be strings")
+
+var (
+	itemsBucket = []byte("items")
+	keysBucket  = []byte("keys")
+	// number of items of a table, kept so that Len and ReadRange do not count
+	countKey = []byte("count")
+)
+
+// Items read per transaction of a scan without snapshot
+const scanChunkSize = 256
+
+// Store is a store.Store backed by a bbolt database file.
+type Store struct {
+	db    *bbolt.DB
+	codec Codec
+	// held by the writer, bbolt allows one at a time. Waiting for it here
+	// instead of in bbolt lets writers give up when their context is done.
+	writer chan struct{}
+}
+
+var _ store.Store = (*Store)(nil)
+
+// Option customizes the store created by Open.
+type Option func(*Store)
+
+// WithCodec sets how items are serialized. Defaults to JSONCodec. A database
+// must always be opened with the same codec.
+func WithCodec(c Codec) Option {
+	return func(s *Store) {
+		s.codec = c
+	}
+}
+
+// Open opens or creates the database at path. Another process holding the
+// file makes Open fail after a second.
+func Open(path string, opts ...Option) (*Store, error) {
+	db, err := bbolt.Open(path, 0o600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", path, err)
+	}
+	s := &Store{db: db, codec: JSONCodec{}, writer: make(chan struct{}, 1)}
+	for _, o := range opts {
+		o(s)
+	}
+	return s, nil
+}
+
+// Close closes the database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// update runs fn in a read write transaction, giving up if ctx is done while
+// waiting for another writer.
+func (s *Store) update(ctx context.Context, fn func(tx *bbolt.Tx) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	select {
+	case s.writer <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-s.writer }()
+	return s.db.Update(fn)
+}
+
+// view runs fn in a read only transaction. Readers do not wait for writers,
+// so ctx is only checked up front.
+func (s *Store) view(ctx context.Context, fn func(tx *bbolt.Tx) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.db.View(fn)
+}
+
+// table is the bucket of a store table within a transaction.
+type table struct {
+	name  string
+	root  *bbolt.Bucket
+	items *bbolt.Bucket
+	keys  *bbolt.Bucket
+}
+
+func getTable(tx *bbolt.Tx, name string) (*table, error) {
+	root := tx.Bucket([]byte(name))
+	if root == nil {
+		return nil, fmt.Errorf("table %s does not exist", name)
+	}
+	return &table{name: name, root: root, items: root.Bucket(itemsBucket), keys: root.Bucket(keysBucket)}, nil
+}
+
+func seqKey(seq uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, seq)
+}
+
+// record is the value of an item: the length of the key, the key and the
+// encoded item, so that scans over items can yield keys.
+func record(key string, data []byte) []byte {
+	b := binary.AppendUvarint(nil, uint64(len(key)))
+	b = append(b, key...)
+	return append(b, data...)
+}
+
+func parseRecord(b []byte) (string, []byte) {
+	n, size := binary.Uvarint(b)
+	key := b[size : size+int(n)]
+	return string(key), b[size+int(n):]
+}
+
+func stringKey(key any) (string, error) {
+	k, ok := key.(string)
+	if !ok {
+		return "", fmt.Errorf("%w: got %T", ErrKeyType, key)
+	}
+	return k, nil
+}
+
+func (t *table) len() int {
+	v := t.root.Get(countKey)
+	if v == nil {
+		return 0
+	}
+	return int(binary.BigEndian.Uint64(v))
+}
+
+func (t *table) setLen(n int) error {
+	return t.root.Put(countKey, seqKey(uint64(n)))
+}
+
+// item copies the encoded item out of a record, bbolt memory is only valid
+// within the transaction.
+func (s *Store) item(data []byte) encodedItem {
+	return encodedItem{data: append([]byte(nil), data...), codec: s.codec}
+}
+
+func (s *Store) read(t *table, id any) (any, error) {
+	k, err := stringKey(id)
+	if err != nil {
+		return nil, err
+	}
+	seq := t.keys.Get([]byte(k))
+	if seq == nil {
+		return nil, fmt.Errorf("item with id %v not found in table %s", id, t.name)
+	}
+	_, data := parseRecord(t.items.Get(seq))
+	return s.item(data), nil
+}
+
+// write inserts or replaces an item. Replacing keeps its sequence number,
+// and so its position.
+func (s *Store) write(t *table, key any, item any) error {
+	k, err := stringKey(key)
+	if err != nil {
+		return err
+	}
+	data, err := s.codec.Marshal(item)
+	if err != nil {
+		return fmt.Errorf("encoding item %s: %w", k, err)
+	}
+	seq := t.keys.Get([]byte(k))
+	if seq == nil {
+		next, err := t.root.NextSequence()
+		if err != nil {
+			return err
+		}
+		seq = seqKey(next)
+		if err := t.keys.Put([]byte(k), seq); err != nil {
+			return err
+		}
+		if err := t.setLen(t.len() + 1); err != nil {
+			return err
+		}
+	}
+	return t.items.Put(seq, record(k, data))
+}
+
+func (s *Store) remove(t *table, id any) error {
+	k, err := stringKey(id)
+	if err != nil {
+		return err
+	}
+	seq := t.keys.Get([]byte(k))
+	if seq == nil {
+		return fmt.Errorf("item with id %v not found in table %s", id, t.name)
+	}
+	if err := t.items.Delete(seq); err != nil {
+		return err
+	}
+	if err := t.keys.Delete([]byte(k)); err != nil {
+		return err
+	}
+	return t.setLen(t.len() - 1)
+}
+
+func (s *Store) CreateTable(name string) error {
+	return s.CreateTableContext(context.Background(), name)
+}
+
+func (s *Store) DeleteTable(name string) error {
+	return s.DeleteTableContext(context.Background(), name)
+}
+
+func (s *Store) Write(name string, key any, item any) error {
+	return s.WriteContext(context.Background(), name, key, item)
+}
+
+func (s *Store) Read(name string, id any) (any, error) {
+	return s.ReadContext(context.Background(), name, id)
+}
+
+func (s *Store) Delete(name string, id any) error {
+	return s.DeleteContext(context.Background(), name, id)
+}
+
+func (s *Store) ReadRange(name string, start, end int) ([]any, bool, error) {
+	return s.ReadRangeContext(context.Background(), name, start, end)
+}
+
+func (s *Store) ReadAll(name string) ([]any, error) {
+	return s.ReadAllContext(context.Background(), name)
+}
+
+func (s *Store) Scan(name string, opts store.ScanOptions) (iter.Seq2[any, any], error) {
+	return s.ScanContext(context.Background(), name, opts)
+}
+
+func (s *Store) Batch(name string, fn func(tx store.Tx) error) error {
+	return s.BatchContext(context.Background(), name, fn)
+}
+
+func (s *Store) CreateTableContext(ctx context.Context, name string) error {
+	return s.update(ctx, func(tx *bbolt.Tx) error {
+		root, err := tx.CreateBucketIfNotExists([]byte(name))
+		if err != nil {
+			return err
+		}
+		if _, err := root.CreateBucketIfNotExists(itemsBucket); err != nil {
+			return err
+		}
+		_, err = root.CreateBucketIfNotExists(keysBucket)
+		return err
+	})
+}
+
+func (s *Store) DeleteTableContext(ctx context.Context, name string) error {
+	return s.update(ctx, func(tx *bbolt.Tx) error {
+		if err := tx.DeleteBucket([]byte(name)); err != nil {
+			return fmt.Errorf("table %s does not exist", name)
+		}
+		return nil
+	})
+}
+
+func (s *Store) WriteContext(ctx context.Context, name string, key any, item any) error {
+	return s.update(ctx, func(tx *bbolt.Tx) error {
+		t, err := getTable(tx, name)
+		if err != nil {
+			return err
+		}
+		return s.write(t, key, item)
+	})
+}
+
+func (s *Store) ReadContext(ctx context.Context, name string, id any) (any, error) {
+	var item any
+	err := s.view(ctx, func(tx *bbolt.Tx) error {
+		t, err := getTable(tx, name)
+		if err != nil {
+			return err
+		}
+		item, err = s.read(t, id)
+		return err
+	})
+	return item, err
+}
+
+func (s *Store) DeleteContext(ctx context.Context, name string, id any) error {
+	return s.update(ctx, func(tx *bbolt.Tx) error {
+		t, err := getTable(tx, name)
+		if err != nil {
+			return err
+		}
+		return s.remove(t, id)
+	})
+}
+
+// ReadRangeContext retrieves the items in [start, end) in insertion order.
+// Without deletes the sequence numbers have no gaps and the cursor seeks to
+// start, otherwise it walks from the nearer end of the table. Returns an
+// error if start is past the end, like MemDb.
+func (s *Store) ReadRangeContext(ctx context.Context, name string, start, end int) ([]any, bool, error) {
+	var items []any
+	var eof bool
+	err := s.view(ctx, func(tx *bbolt.Tx) error {
+		t, err := getTable(tx, name)
+		if err != nil {
+			return err
+		}
+		n := t.len()
+		start = max(start, 0)
+		end = min(end, n)
+		if start > end {
+			return fmt.Errorf("invalid start or end index")
+		}
+		eof = end >= n
+		items = make([]any, 0, end-start)
+		if start == end {
+			return nil
+		}
+		c := t.items.Cursor()
+		first, _ := c.First()
+		last, _ := c.Last()
+		firstSeq := binary.BigEndian.Uint64(first)
+		var seq, v []byte
+		switch {
+		case binary.BigEndian.Uint64(last)-firstSeq == uint64(n-1):
+			seq, v = c.Seek(seqKey(firstSeq + uint64(start)))
+		case start <= n-end:
+			seq, v = c.First()
+			for range start {
+				seq, v = c.Next()
+			}
+		default:
+			seq, v = c.Last()
+			for range n - end {
+				seq, v = c.Prev()
+			}
+			for ; seq != nil && len(items) < end-start; seq, v = c.Prev() {
+				_, data := parseRecord(v)
+				items = append(items, s.item(data))
+			}
+			slices.Reverse(items)
+			return nil
+		}
+		for ; seq != nil && len(items) < end-start; seq, v = c.Next() {
+			_, data := parseRecord(v)
+			items = append(items, s.item(data))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return items, eof, nil
+}
+
+func (s *Store) ReadAllContext(ctx context.Context, name string) ([]any, error) {
+	var items []any
+	err := s.view(ctx, func(tx *bbolt.Tx) error {
+		t, err := getTable(tx, name)
+		if err != nil {
+			return err
+		}
+		items = make([]any, 0, t.len())
+		return t.items.ForEach(func(_, v []byte) error {
+			_, data := parseRecord(v)
+			items = append(items, s.item(data))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// ScanContext iterates over the items in insertion order, see
+// store.ScanOptions. Without a snapshot every chunk is read in its own
+// transaction, seeking past the sequence number of the last item, so writes
+// between chunks may be seen. Snapshots are copied in a single transaction, a
+// long running read transaction would block bbolt from growing the file. The
+// iteration ends before the next chunk once ctx is done.
+func (s *Store) ScanContext(ctx context.Context, name string, opts store.ScanOptions) (iter.Seq2[any, any], error) {
+	var startKey string
+	if opts.StartKey != nil {
+		k, err := stringKey(opts.StartKey)
+		if err != nil {
+			return nil, err
+		}
+		startKey = k
+	}
+	first, err := s.scanChunk(ctx, name, opts, startKey, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	return func(yield func(any, any) bool) {
+		c := first
+		for {
+			for i := range c.keys {
+				if !yield(c.keys[i], c.items[i]) {
+					return
+				}
+			}
+			if c.done {
+				return
+			}
+			var err error
+			if c, err = s.scanChunk(ctx, name, opts, "", c.last, scanChunkSize); err != nil {
+				// The table was deleted during the scan, ctx is done or the
+				// database failed
+				yield(nil, &store.ScanError{Err: err})
+				return
+			}
+		}
+	}, nil
+}
+
+type scanChunk struct {
+	keys  []any
+	items []any
+	// sequence number of the last visited item
+	last []byte
+	done bool
+}
+
+// scanChunk reads the matching items of up to max visited items, all if max
+// is zero. The first chunk starts at startKey or the end of the table, later
+// chunks after the item with sequence number after. The first chunk of a
+// scan without snapshot is limited as well.
+func (s *Store) scanChunk(ctx context.Context, name string, opts store.ScanOptions, startKey string, after []byte, max int) (scanChunk, error) {
+	if after == nil && !opts.Snapshot {
+		max = scanChunkSize
+	}
+	var chunk scanChunk
+	err := s.view(ctx, func(tx *bbolt.Tx) error {
+		t, err := getTable(tx, name)
+		if err != nil {
+			return err
+		}
+		if opts.Prefix != "" {
+			chunk = s.prefixChunk(t, opts, startKey, after, max)
+			return nil
+		}
+		c := t.items.Cursor()
+		var seq, v []byte
+		switch {
+		case after != nil:
+			seq, v = c.Seek(after)
+			if opts.Reverse && seq == nil {
+				// Every item is before after
+				seq, v = c.Last()
+			} else if opts.Reverse {
+				seq, v = c.Prev()
+			} else if seq != nil && string(seq) == string(after) {
+				seq, v = c.Next()
+			}
+		case opts.StartKey != nil:
+			start := t.keys.Get([]byte(startKey))
+			if start == nil {
+				chunk.done = true
+				return nil
+			}
+			seq, v = c.Seek(start)
+		case opts.Reverse:
+			seq, v = c.Last()
+		default:
+			seq, v = c.First()
+		}
+		for visited := 0; seq != nil && (max == 0 || visited < max); visited++ {
+			chunk.last = append(chunk.last[:0], seq...)
+			key, data := parseRecord(v)
+			chunk.keys = append(chunk.keys, key)
+			chunk.items = append(chunk.items, s.item(data))
+			if opts.Reverse {
+				seq, v = c.Prev()
+			} else {
+				seq, v = c.Next()
+			}
+		}
+		chunk.done = seq == nil
+		return nil
+	})
+	return chunk, err
+}
+
+// prefixChunk is scanChunk for prefix scans. Only the keys with the prefix
+// are visited, by seeking the keys bucket to the prefix, and their items are
+// read in insertion order.
+func (s *Store) prefixChunk(t *table, opts store.ScanOptions, startKey string, after []byte, max int) scanChunk {
+	var chunk scanChunk
+	var start []byte
+	if after == nil && opts.StartKey != nil {
+		if start = t.keys.Get([]byte(startKey)); start == nil {
+			chunk.done = true
+			return chunk
+		}
+	}
+	seqs := t.matching(opts.Prefix, start, after, opts.Reverse)
+	if max > 0 && len(seqs) > max {
+		seqs = seqs[:max]
+	} else {
+		chunk.done = true
+	}
+	for _, seq := range seqs {
+		key, data := parseRecord(t.items.Get(seq))
+		chunk.keys = append(chunk.keys, key)
+		chunk.items = append(chunk.items, s.item(data))
+	}
+	if len(seqs) > 0 {
+		chunk.last = append([]byte(nil), seqs[len(seqs)-1]...)
+	}
+	return chunk
+}
+
+// matching returns the sequence numbers of the items whose key starts with
+// prefix in scan order, from the item with sequence number start on or after
+// the one with after.
+func (t *table) matching(prefix string, start, after []byte, reverse bool) [][]byte {
+	var seqs [][]byte
+	c := t.keys.Cursor()
+	for k, seq := c.Seek([]byte(prefix)); k != nil && bytes.HasPrefix(k, []byte(prefix)); k, seq = c.Next() {
+		switch {
+		case after != nil:
+			if cmp := bytes.Compare(seq, after); cmp == 0 || (cmp > 0) == reverse {
+				continue
+			}
+		case start != nil:
+			if cmp := bytes.Compare(seq, start); cmp != 0 && (cmp > 0) == reverse {
+				continue
+			}
+		}
+		seqs = append(seqs, seq)
+	}
+	slices.SortFunc(seqs, bytes.Compare)
+	if reverse {
+		slices.Reverse(seqs)
+	}
+	return seqs
+}
+
+// BatchContext runs fn in a single read write transaction, see store.Store.
+// An error or a panic of fn rolls the transaction back.
+func (s *Store) BatchContext(ctx context.Context, name string, fn func(tx store.Tx) error) error {
+	return s.update(ctx, func(tx *bbolt.Tx) error {
+		t, err := getTable(tx, name)
+		if err != nil {
+			return err
+		}
+		return fn(&boltTx{s: s, t: t})
+	})
+}
+
+type boltTx struct {
+	s *Store
+	t *table
+}
+
+func (tx *boltTx) Read(id any) (any, error) {
+	return tx.s.read(tx.t, id)
+}
+
+func (tx *boltTx) Write(key any, item any) error {
+	return tx.s.write(tx.t, key, item)
+}
+
+func (tx *boltTx) Delete(id any) error {
+	return tx.s.remove(tx.t, id)
+}
+
+func (tx *boltTx) Len() int {
+	return tx.t.len()
+}
@@ -0,0 +1,76 @@
+// Copyright 2025 Jacob Philip. All rights reserved.
+package bolt
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/jacobtrvl/inventory-management/internal/store"
+	"github.com/jacobtrvl/inventory-management/internal/store/storetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func open(t *testing.T, path string, opts ...Option) *Store {
+	t.Helper()
+	s, err := Open(path, opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestConformance(t *testing.T) {
+	for name, codec := range map[string]Codec{"json": JSONCodec{}, "gob": GobCodec{}} {
+		t.Run(name, func(t *testing.T) {
+			storetest.Run(t, func(t *testing.T) store.Store {
+				return open(t, filepath.Join(t.TempDir(), "store.db"), WithCodec(codec))
+			})
+		})
+	}
+}
+
+func TestPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.db")
+	s := open(t, path)
+	tbl, err := store.NewTable[string, storetest.Item](s, "acme/products")
+	require.NoError(t, err)
+	for _, k := range []string{"1", "2", "3"} {
+		require.NoError(t, tbl.Write(k, storetest.Item{Name: k}))
+	}
+	require.NoError(t, tbl.Delete("2"))
+	require.NoError(t, s.Close())
+
+	s = open(t, path)
+	tbl, err = store.NewTable[string, storetest.Item](s, "acme/products")
+	require.NoError(t, err)
+	values, err := tbl.ReadAll()
+	assert.NoError(t, err)
+	assert.Equal(t, []storetest.Item{{Name: "1"}, {Name: "3"}}, values)
+	// Sequence numbers continue, new items go last
+	require.NoError(t, tbl.Write("0", storetest.Item{Name: "0"}))
+	values, _, err = tbl.ReadRange(1, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, []storetest.Item{{Name: "3"}, {Name: "0"}}, values)
+
+	assert.ErrorIs(t, s.Write("acme/products", 1, "x"), ErrKeyType)
+}
+
+func TestMarshalJSON(t *testing.T) {
+	for name, codec := range map[string]Codec{"json": JSONCodec{}, "gob": GobCodec{}} {
+		t.Run(name, func(t *testing.T) {
+			s := open(t, filepath.Join(t.TempDir(), "store.db"), WithCodec(codec))
+			require.NoError(t, s.CreateTable("t"))
+			require.NoError(t, s.Write("t", "a", storetest.Item{Name: "a"}))
+			item, err := s.Read("t", "a")
+			require.NoError(t, err)
+			data, err := json.Marshal(item)
+			if name == "gob" {
+				assert.Error(t, err, "gob items only decode into their type")
+				return
+			}
+			assert.NoError(t, err)
+			assert.JSONEq(t, `{"name": "a", "n": 0}`, string(data))
+		})
+	}
+}
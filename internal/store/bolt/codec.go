@@ -0,0 +1,67 @@
+// Copyright 2025 Jacob Philip. All rights reserved.
+package bolt
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+)
+
+// Codec serializes items. Unmarshal decodes into the type of a typed table,
+// not into the type the item was written with, so both must be compatible.
+type Codec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// JSONCodec stores items as JSON, the default.
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+// GobCodec stores items with encoding/gob, which is more compact than JSON.
+// It ignores json tags, so fields tagged json:"-" are stored as well.
+type GobCodec struct{}
+
+func (GobCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// encodedItem is an item as stored, see store.Encoded.
+type encodedItem struct {
+	data  []byte
+	codec Codec
+}
+
+func (e encodedItem) Decode(v any) error {
+	return e.codec.Unmarshal(e.data, v)
+}
+
+// MarshalJSON lets untyped readers pass items on as JSON. JSON items are
+// passed on unchanged, others are decoded into an any through the codec,
+// which fails for gob: it only decodes into the type of the item.
+func (e encodedItem) MarshalJSON() ([]byte, error) {
+	if _, ok := e.codec.(JSONCodec); ok {
+		return e.data, nil
+	}
+	var v any
+	if err := e.codec.Unmarshal(e.data, &v); err != nil {
+		return nil, fmt.Errorf("decoding item without its type: %w", err)
+	}
+	return json.Marshal(v)
+}
@@ -0,0 +1,13 @@
+// Copyright 2025 Jacob Philip. All rights reserved.
+package store_test
+
+import (
+	"testing"
+
+	"github.com/jacobtrvl/inventory-management/internal/store"
+	"github.com/jacobtrvl/inventory-management/internal/store/storetest"
+)
+
+func TestConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store { return store.NewMemDb() })
+}
@@ -1,14 +1,39 @@
 package store
 
-// Store interface.
-// This is not a idiomatic Go style to define interface when there is only one implementation.
-// This is just for demonstrating usage of interfaces.
+import (
+	"context"
+	"iter"
+)
+
+// Store is implemented by MemDb, by the SQLite store in package sqlite and by
+// the bbolt store in package bolt. All pass the conformance suite in package storetest.
 type Store interface {
 	Write(table string, key any, item any) error
 	Read(table string, id any) (any, error)
 	ReadRange(table string, start, end int) ([]any, bool, error)
 	ReadAll(table string) ([]any, error)
+	// Scan iterates over the keys and items of a table without copying it
+	// first, see ScanOptions
+	Scan(table string, opts ScanOptions) (iter.Seq2[any, any], error)
 	Delete(table string, id any) error
 	CreateTable(name string) error
 	DeleteTable(name string) error
+	// Batch runs fn with exclusive access to a table, rolling back its
+	// changes if it returns an error
+	Batch(table string, fn func(tx Tx) error) error
+
+	// Variants of the methods above that give up with the error of ctx if it
+	// is done before the table lock is acquired, so that requests going away
+	// do not queue behind long batches. An operation holding the lock runs to
+	// completion. The iterator of ScanContext ends early instead, with a
+	// ScanError like other failures during a scan.
+	WriteContext(ctx context.Context, table string, key any, item any) error
+	ReadContext(ctx context.Context, table string, id any) (any, error)
+	ReadRangeContext(ctx context.Context, table string, start, end int) ([]any, bool, error)
+	ReadAllContext(ctx context.Context, table string) ([]any, error)
+	ScanContext(ctx context.Context, table string, opts ScanOptions) (iter.Seq2[any, any], error)
+	DeleteContext(ctx context.Context, table string, id any) error
+	CreateTableContext(ctx context.Context, name string) error
+	DeleteTableContext(ctx context.Context, name string) error
+	BatchContext(ctx context.Context, table string, fn func(tx Tx) error) error
 }
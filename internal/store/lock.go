@@ -0,0 +1,87 @@
+// Copyright 2025 Jacob Philip. All rights reserved.
+package store
+
+import (
+	"context"
+	"sync"
+)
+
+// rwLock is a readers-writer lock whose waiters give up when their context
+// is done, which sync.RWMutex cannot do. Waiting writers hold off new
+// readers, so that a steady stream of readers cannot starve them.
+type rwLock struct {
+	mu      sync.Mutex
+	readers int
+	writer  bool
+	// writers waiting for the lock
+	waiting int
+	// closed when the lock changes, waiters then try again
+	changed chan struct{}
+}
+
+// lock takes the lock for writing, or for reading if write is false. Returns
+// the error of ctx if it is done before the lock is taken.
+func (l *rwLock) lock(ctx context.Context, write bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	l.mu.Lock()
+	if write {
+		l.waiting++
+	}
+	for {
+		if write && !l.writer && l.readers == 0 {
+			l.waiting--
+			l.writer = true
+			l.mu.Unlock()
+			return nil
+		}
+		if !write && !l.writer && l.waiting == 0 {
+			l.readers++
+			l.mu.Unlock()
+			return nil
+		}
+		if l.changed == nil {
+			l.changed = make(chan struct{})
+		}
+		changed := l.changed
+		l.mu.Unlock()
+		select {
+		case <-changed:
+			l.mu.Lock()
+		case <-ctx.Done():
+			l.mu.Lock()
+			if write {
+				// Readers held off by this writer can go ahead
+				l.waiting--
+				l.notify()
+			}
+			l.mu.Unlock()
+			return ctx.Err()
+		}
+	}
+}
+
+// notify wakes up the waiters, the caller holds l.mu.
+func (l *rwLock) notify() {
+	if l.changed != nil {
+		close(l.changed)
+		l.changed = nil
+	}
+}
+
+func (l *rwLock) Unlock() {
+	l.mu.Lock()
+	l.writer = false
+	l.notify()
+	l.mu.Unlock()
+}
+
+func (l *rwLock) RUnlock() {
+	l.mu.Lock()
+	l.readers--
+	if l.readers == 0 {
+		l.notify()
+	}
+	l.mu.Unlock()
+}
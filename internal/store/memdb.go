@@ -21,6 +21,7 @@
 package store
 
 import (
+	"context"
 	"fmt"
 	"sync"
 )
@@ -37,7 +38,19 @@ type MemDb struct {
 type value struct {
 	indexMap  map[any]int
 	dataSlice []any
-	mutex     sync.RWMutex
+	// slots[i] describes dataSlice[i]
+	slots []slot
+	// sequence number of the next appended item
+	nextSeq uint64
+	mutex   rwLock
+}
+
+// slot is the key of an item and its sequence number. Items are only ever
+// appended and deletes keep the order, so sequence numbers increase along the
+// slice, which lets a Scan find its place again after the table changed.
+type slot struct {
+	key any
+	seq uint64
 }
 
 // NewMemDb initializes a new in-memory database.
@@ -50,6 +63,14 @@ func NewMemDb() *MemDb {
 
 // CreateTable creates a new table in the memdb if it does not already exist.
 func (m *MemDb) CreateTable(name string) error {
+	return m.CreateTableContext(context.Background(), name)
+}
+
+// CreateTableContext is CreateTable, which never waits, so ctx is only checked up front.
+func (m *MemDb) CreateTableContext(ctx context.Context, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	if m.tables == nil {
 		return fmt.Errorf("tables map not initialized")
 	}
@@ -57,7 +78,6 @@ func (m *MemDb) CreateTable(name string) error {
 	v := &value{
 		indexMap:  make(map[any]int),
 		dataSlice: []any{},
-		mutex:     sync.RWMutex{},
 	}
 
 	m.tables.LoadOrStore(name, v)
@@ -66,6 +86,14 @@ func (m *MemDb) CreateTable(name string) error {
 
 // DeleteTable deletes a table from the memdb.
 func (m *MemDb) DeleteTable(name string) error {
+	return m.DeleteTableContext(context.Background(), name)
+}
+
+// DeleteTableContext is DeleteTable, which never waits, so ctx is only checked up front.
+func (m *MemDb) DeleteTableContext(ctx context.Context, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	if m.tables == nil {
 		return fmt.Errorf("tables map not initialized")
 	}
@@ -90,28 +118,49 @@ func (m *MemDb) getDataMap(table string) (*value, error) {
 
 // Write inserts an item into the specified table in the memdb.
 func (m *MemDb) Write(table string, key any, item any) error {
+	return m.WriteContext(context.Background(), table, key, item)
+}
+
+// WriteContext is Write, giving up if ctx is done while waiting for the table lock.
+func (m *MemDb) WriteContext(ctx context.Context, table string, key any, item any) error {
 	v, err := m.getDataMap(table)
 	if err != nil {
 		return err
 	}
-	v.mutex.Lock()
+	if err := v.mutex.lock(ctx, true); err != nil {
+		return err
+	}
 	defer v.mutex.Unlock()
 	if index, exists := v.indexMap[key]; exists {
 		v.dataSlice[index] = item
 		return nil
 	}
+	v.append(key, item)
+	return nil
+}
+
+// append adds an item at the end of the table, the caller holds the lock.
+func (v *value) append(key any, item any) {
 	v.dataSlice = append(v.dataSlice, item)
+	v.slots = append(v.slots, slot{key: key, seq: v.nextSeq})
+	v.nextSeq++
 	v.indexMap[key] = len(v.dataSlice) - 1
-	return nil
 }
 
 // Read retrieves an item from the specified table and index in the memdb.
 func (m *MemDb) Read(table string, id any) (any, error) {
+	return m.ReadContext(context.Background(), table, id)
+}
+
+// ReadContext is Read, giving up if ctx is done while waiting for the table lock.
+func (m *MemDb) ReadContext(ctx context.Context, table string, id any) (any, error) {
 	t, err := m.getDataMap(table)
 	if err != nil {
 		return nil, err
 	}
-	t.mutex.RLock()
+	if err := t.mutex.lock(ctx, false); err != nil {
+		return nil, err
+	}
 	defer t.mutex.RUnlock()
 	index, exists := t.indexMap[id]
 	if !exists {
@@ -123,11 +172,18 @@ func (m *MemDb) Read(table string, id any) (any, error) {
 // ReadRange retrieves all itemms within the specified range [start, end) from the table.
 // Returns slice of items, EOF status, and error (if any).
 func (m *MemDb) ReadRange(table string, start, end int) ([]any, bool, error) {
+	return m.ReadRangeContext(context.Background(), table, start, end)
+}
+
+// ReadRangeContext is ReadRange, giving up if ctx is done while waiting for the table lock.
+func (m *MemDb) ReadRangeContext(ctx context.Context, table string, start, end int) ([]any, bool, error) {
 	t, err := m.getDataMap(table)
 	if err != nil {
 		return nil, false, err
 	}
-	t.mutex.RLock()
+	if err := t.mutex.lock(ctx, false); err != nil {
+		return nil, false, err
+	}
 	defer t.mutex.RUnlock()
 	if start < 0 {
 		start = 0
@@ -145,11 +201,18 @@ func (m *MemDb) ReadRange(table string, start, end int) ([]any, bool, error) {
 
 // ReadAll retrieves all items from the specified table in the memdb.
 func (m *MemDb) ReadAll(table string) ([]any, error) {
+	return m.ReadAllContext(context.Background(), table)
+}
+
+// ReadAllContext is ReadAll, giving up if ctx is done while waiting for the table lock.
+func (m *MemDb) ReadAllContext(ctx context.Context, table string) ([]any, error) {
 	t, err := m.getDataMap(table)
 	if err != nil {
 		return nil, err
 	}
-	t.mutex.RLock()
+	if err := t.mutex.lock(ctx, false); err != nil {
+		return nil, err
+	}
 	defer t.mutex.RUnlock()
 	result := make([]any, len(t.dataSlice))
 	copy(result, t.dataSlice)
@@ -160,11 +223,18 @@ func (m *MemDb) ReadAll(table string) ([]any, error) {
 // This is an O(n) operation due to slice reallocation and index remapping.
 // We are assuming delete operations are rare compared to read/write operations.
 func (m *MemDb) Delete(table string, id any) error {
+	return m.DeleteContext(context.Background(), table, id)
+}
+
+// DeleteContext is Delete, giving up if ctx is done while waiting for the table lock.
+func (m *MemDb) DeleteContext(ctx context.Context, table string, id any) error {
 	v, err := m.getDataMap(table)
 	if err != nil {
 		return err
 	}
-	v.mutex.Lock()
+	if err := v.mutex.lock(ctx, true); err != nil {
+		return err
+	}
 	defer v.mutex.Unlock()
 
 	indexToDelete, exists := v.indexMap[id]
@@ -175,6 +245,8 @@ func (m *MemDb) Delete(table string, id any) error {
 	// Remove the item from slice
 	copy(v.dataSlice[indexToDelete:], v.dataSlice[indexToDelete+1:])
 	v.dataSlice = v.dataSlice[:len(v.dataSlice)-1]
+	copy(v.slots[indexToDelete:], v.slots[indexToDelete+1:])
+	v.slots = v.slots[:len(v.slots)-1]
 
 	// Remove from index map
 	delete(v.indexMap, id)
@@ -2,6 +2,7 @@
 package store
 
 import (
+	"context"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -48,3 +49,213 @@ func TestInsertAndGet(t *testing.T) {
 	assert.Equal(t, 1, len(items), "expected one item in the table")
 	assert.Equal(t, "value2", items[0], "expected remaining item to match inserted value")
 }
+
+func TestBatch(t *testing.T) {
+	db := NewMemDb()
+	assert.NoError(t, db.CreateTable("t"))
+	for _, k := range []string{"a", "b", "c", "d"} {
+		assert.NoError(t, db.Write("t", k, k+"1"))
+	}
+
+	err := db.Batch("t", func(tx Tx) error {
+		assert.NoError(t, tx.Delete("b"))
+		assert.NoError(t, tx.Write("a", "a2"))
+		assert.NoError(t, tx.Write("e", "e1"))
+		assert.NoError(t, tx.Delete("d"))
+		_, err := tx.Read("b")
+		assert.Error(t, err, "deleted within the batch")
+		assert.Equal(t, 3, tx.Len())
+		return nil
+	})
+	assert.NoError(t, err)
+	items, _ := db.ReadAll("t")
+	assert.Equal(t, []any{"a2", "c1", "e1"}, items)
+	for k, want := range map[string]string{"a": "a2", "c": "c1", "e": "e1"} {
+		v, err := db.Read("t", k)
+		assert.NoError(t, err)
+		assert.Equal(t, want, v, "index of %s", k)
+	}
+
+	// A failing batch leaves the table as it was
+	err = db.Batch("t", func(tx Tx) error {
+		assert.NoError(t, tx.Write("c", "c2"))
+		assert.NoError(t, tx.Delete("a"))
+		assert.NoError(t, tx.Write("f", "f1"))
+		assert.NoError(t, tx.Write("a", "a3"))
+		assert.NoError(t, tx.Delete("e"))
+		return ErrRollback
+	})
+	assert.ErrorIs(t, err, ErrRollback)
+	items, _ = db.ReadAll("t")
+	assert.Equal(t, []any{"a2", "c1", "e1"}, items)
+	_, err = db.Read("t", "f")
+	assert.Error(t, err)
+	v, err := db.Read("t", "a")
+	assert.NoError(t, err)
+	assert.Equal(t, "a2", v)
+
+	assert.Error(t, db.Batch("missing", func(Tx) error { return nil }))
+}
+
+func TestScan(t *testing.T) {
+	db := NewMemDb()
+	assert.NoError(t, db.CreateTable("t"))
+	scan := func(opts ScanOptions) []any {
+		items, err := db.Scan("t", opts)
+		assert.NoError(t, err)
+		var keys []any
+		for k := range items {
+			keys = append(keys, k)
+		}
+		return keys
+	}
+	for _, k := range []string{"a1", "b1", "a2", "b2", "a3"} {
+		assert.NoError(t, db.Write("t", k, k))
+	}
+
+	assert.Equal(t, []any{"a1", "b1", "a2", "b2", "a3"}, scan(ScanOptions{}))
+	assert.Equal(t, []any{"a3", "b2", "a2", "b1", "a1"}, scan(ScanOptions{Reverse: true}))
+	assert.Equal(t, []any{"a1", "a2", "a3"}, scan(ScanOptions{Prefix: "a"}))
+	assert.Equal(t, []any{"a2", "b2", "a3"}, scan(ScanOptions{StartKey: "a2"}))
+	assert.Equal(t, []any{"b1", "a1"}, scan(ScanOptions{StartKey: "b1", Reverse: true, Prefix: ""}))
+	assert.Equal(t, []any{"b2", "b1"}, scan(ScanOptions{StartKey: "b2", Reverse: true, Prefix: "b"}))
+	assert.Empty(t, scan(ScanOptions{StartKey: "zz"}))
+	_, err := db.Scan("missing", ScanOptions{})
+	assert.Error(t, err)
+
+	// Batches keep the order
+	assert.NoError(t, db.Batch("t", func(tx Tx) error {
+		assert.NoError(t, tx.Delete("b1"))
+		return tx.Write("c1", "c1")
+	}))
+	assert.ErrorIs(t, db.Batch("t", func(tx Tx) error {
+		assert.NoError(t, tx.Delete("a2"))
+		assert.NoError(t, tx.Write("c2", "c2"))
+		return ErrRollback
+	}), ErrRollback)
+	assert.Equal(t, []any{"a1", "a2", "b2", "a3", "c1"}, scan(ScanOptions{}))
+}
+
+func TestScanConcurrentChanges(t *testing.T) {
+	db := NewMemDb()
+	assert.NoError(t, db.CreateTable("t"))
+	n := 3 * scanChunkSize
+	for i := range n {
+		assert.NoError(t, db.Write("t", i, i))
+	}
+
+	for _, snapshot := range []bool{false, true} {
+		items, err := db.Scan("t", ScanOptions{Snapshot: snapshot})
+		assert.NoError(t, err)
+		seen := make(map[any]int)
+		for k, v := range items {
+			seen[k]++
+			assert.Equal(t, k, v)
+			// Deleting the item just seen shifts the rest of the table
+			if i := k.(int); i < n {
+				assert.NoError(t, db.Delete("t", i))
+				assert.NoError(t, db.Write("t", n+i, n+i))
+			}
+		}
+		for k, count := range seen {
+			assert.Equal(t, 1, count, "item %v of snapshot %v", k, snapshot)
+		}
+		if snapshot {
+			assert.Len(t, seen, n)
+		} else {
+			assert.Len(t, seen, 2*n, "appended items are seen")
+		}
+		// Reset the table
+		assert.NoError(t, db.DeleteTable("t"))
+		assert.NoError(t, db.CreateTable("t"))
+		for i := range n {
+			assert.NoError(t, db.Write("t", i, i))
+		}
+	}
+}
+
+func TestTable(t *testing.T) {
+	db := NewMemDb()
+	tbl, err := NewTable[string, int](db, "t")
+	assert.NoError(t, err)
+	assert.Equal(t, "t", tbl.Name())
+	assert.NoError(t, tbl.Write("a", 1))
+	assert.NoError(t, tbl.Write("b", 2))
+	v, err := tbl.Read("a")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, v)
+	_, err = tbl.Read("c")
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrType)
+
+	assert.NoError(t, tbl.Batch(func(tx TableTx[string, int]) error {
+		v, err := tx.Read("b")
+		assert.NoError(t, err)
+		assert.NoError(t, tx.Delete("a"))
+		return tx.Write("c", v+1)
+	}))
+	values, err := tbl.ReadAll()
+	assert.NoError(t, err)
+	assert.Equal(t, []int{2, 3}, values)
+	var entries []Entry[string, int]
+	for e, err := range tbl.Scan(ScanOptions{Reverse: true}) {
+		assert.NoError(t, err)
+		entries = append(entries, e)
+	}
+	assert.Equal(t, []Entry[string, int]{{"c", 3}, {"b", 2}}, entries)
+
+	// Items written through the untyped interface are checked, not asserted
+	assert.NoError(t, db.Write("t", "d", "four"))
+	_, err = tbl.Read("d")
+	assert.ErrorIs(t, err, ErrType)
+	_, err = tbl.ReadAll()
+	assert.ErrorIs(t, err, ErrType)
+	assert.ErrorContains(t, err, "item d of table t")
+	_, _, err = tbl.ReadRange(1, 10)
+	assert.ErrorIs(t, err, ErrType)
+	assert.ErrorContains(t, err, "item d of table t")
+	for _, err = range tbl.Scan(ScanOptions{}) {
+	}
+	assert.ErrorIs(t, err, ErrType)
+	for _, err = range (Table[string, int]{db: db, name: "missing"}).Scan(ScanOptions{}) {
+	}
+	assert.Error(t, err)
+}
+
+func TestTrace(t *testing.T) {
+	var spans []Span
+	db := Trace(NewMemDb(), func(ctx context.Context, span Span) {
+		spans = append(spans, span)
+	})
+	tbl, err := NewTable[string, int](db, "t")
+	assert.NoError(t, err)
+	assert.NoError(t, tbl.WriteContext(context.Background(), "a", 1))
+	_, err = tbl.Read("b")
+	assert.Error(t, err)
+	for range tbl.Scan(ScanOptions{}) {
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, err = range tbl.ScanContext(ctx, ScanOptions{}) {
+	}
+	assert.ErrorIs(t, err, context.Canceled)
+	// A scan that completes is not failed by ctx ending afterwards
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	for _, err = range tbl.ScanContext(ctx, ScanOptions{}) {
+		cancel()
+	}
+	assert.NoError(t, err)
+
+	var ops []string
+	for _, s := range spans {
+		assert.Equal(t, "t", s.Table)
+		assert.False(t, s.Start.IsZero())
+		ops = append(ops, s.Op)
+	}
+	assert.Equal(t, []string{"CreateTable", "Write", "Read", "Scan", "Scan", "Scan"}, ops)
+	assert.NoError(t, spans[1].Err)
+	assert.Error(t, spans[2].Err)
+	assert.ErrorIs(t, spans[4].Err, context.Canceled)
+	assert.NoError(t, spans[5].Err)
+}
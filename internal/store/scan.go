@@ -0,0 +1,147 @@
+// Copyright 2025 Jacob Philip. All rights reserved.
+package store
+
+import (
+	"context"
+	"iter"
+	"sort"
+	"strings"
+)
+
+// ScanOptions selects the items of a Scan. The zero value scans the whole
+// table in order.
+type ScanOptions struct {
+	// StartKey starts the scan at this key, inclusive. In MemDb the key must
+	// be in the table, otherwise nothing is yielded.
+	StartKey any
+	// Prefix only yields items with string keys starting with it
+	Prefix string
+	// Reverse scans from the last item to the first
+	Reverse bool
+	// Snapshot copies the selected items under a single read lock, so the scan
+	// sees the table as it was when Scan was called. Otherwise the lock is
+	// taken once per chunk and changes made during the scan may be seen.
+	Snapshot bool
+}
+
+// ScanError is yielded by a scan that fails after it started, such as when
+// a chunk cannot be read or ctx is done, as the last item with a nil key.
+// Without it a failed scan would look like the end of the table.
+type ScanError struct {
+	Err error
+}
+
+func (e *ScanError) Error() string {
+	return "scan failed: " + e.Err.Error()
+}
+
+func (e *ScanError) Unwrap() error {
+	return e.Err
+}
+
+// Items copied per lock of a scan without snapshot
+const scanChunkSize = 256
+
+// Scan iterates over the keys and items of a table in insertion order, the
+// order of ReadRange. Without a snapshot, the table lock is only held while a
+// chunk of items is copied. Items that are in the table for the whole scan are
+// yielded exactly once, items written or deleted during the scan may or may
+// not be.
+func (m *MemDb) Scan(table string, opts ScanOptions) (iter.Seq2[any, any], error) {
+	return m.ScanContext(context.Background(), table, opts)
+}
+
+// ScanContext is Scan, ending the iteration with a ScanError if ctx is done
+// while waiting for the table lock. Without a snapshot that is before every
+// chunk.
+func (m *MemDb) ScanContext(ctx context.Context, table string, opts ScanOptions) (iter.Seq2[any, any], error) {
+	v, err := m.getDataMap(table)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Snapshot {
+		if err := v.mutex.lock(ctx, false); err != nil {
+			return nil, err
+		}
+		c := v.collect(opts, nil, 0)
+		v.mutex.RUnlock()
+		return func(yield func(any, any) bool) { c.all(yield) }, nil
+	}
+	return func(yield func(any, any) bool) {
+		var after *uint64
+		for {
+			if err := v.mutex.lock(ctx, false); err != nil {
+				yield(nil, &ScanError{Err: err})
+				return
+			}
+			c := v.collect(opts, after, scanChunkSize)
+			v.mutex.RUnlock()
+			if !c.all(yield) || c.done {
+				return
+			}
+			after = &c.last
+		}
+	}, nil
+}
+
+// scanChunk is a part of a scan, copied under the table lock.
+type scanChunk struct {
+	keys  []any
+	items []any
+	// sequence number of the last visited slot
+	last uint64
+	// no slots left to visit
+	done bool
+}
+
+func (c scanChunk) all(yield func(any, any) bool) bool {
+	for i := range c.keys {
+		if !yield(c.keys[i], c.items[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// collect copies the matching items of up to max slots, all if max is zero.
+// The first chunk starts at opts.StartKey or the end of the table, later
+// chunks after the slot with sequence number after. The caller holds the read
+// lock.
+func (v *value) collect(opts ScanOptions, after *uint64, max int) scanChunk {
+	n := len(v.slots)
+	step := 1
+	if opts.Reverse {
+		step = -1
+	}
+	var idx int
+	switch {
+	case after != nil && opts.Reverse:
+		idx = sort.Search(n, func(i int) bool { return v.slots[i].seq >= *after }) - 1
+	case after != nil:
+		idx = sort.Search(n, func(i int) bool { return v.slots[i].seq > *after })
+	case opts.StartKey != nil:
+		start, ok := v.indexMap[opts.StartKey]
+		if !ok {
+			return scanChunk{done: true}
+		}
+		idx = start
+	case opts.Reverse:
+		idx = n - 1
+	}
+
+	var c scanChunk
+	for visited := 0; idx >= 0 && idx < n && (max == 0 || visited < max); idx += step {
+		visited++
+		s := v.slots[idx]
+		c.last = s.seq
+		if opts.Prefix != "" {
+			if key, ok := s.key.(string); !ok || !strings.HasPrefix(key, opts.Prefix) {
+				continue
+			}
+		}
+		c.keys = append(c.keys, s.key)
+		c.items = append(c.items, v.dataSlice[idx])
+	}
+	c.done = idx < 0 || idx >= n
+	return c
+}
@@ -0,0 +1,83 @@
+// Copyright 2025 Jacob Philip. All rights reserved.
+package sqlite
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jacobtrvl/inventory-management/internal/store"
+)
+
+// sqlTx runs the operations of a batch in an SQL transaction.
+type sqlTx struct {
+	tx    querier
+	table string
+	// first error of Len, which cannot return it. The batch fails with it.
+	err error
+}
+
+// Batch runs fn in a transaction, see store.Store. An error or a panic of fn
+// rolls the transaction back.
+func (s *Store) Batch(table string, fn func(tx store.Tx) error) error {
+	return s.BatchContext(context.Background(), table, fn)
+}
+
+// BatchContext is Batch, giving up if ctx is done while waiting for the
+// connection. The transaction itself does not use ctx, so that a batch that
+// started is not interrupted, like in MemDb.
+func (s *Store) BatchContext(ctx context.Context, table string, fn func(tx store.Tx) error) error {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := count(ctx, conn, table); err != nil {
+		return err
+	}
+	tx, err := conn.BeginTx(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			tx.Rollback()
+		}
+	}()
+	stx := &sqlTx{tx: tx, table: table}
+	err = fn(stx)
+	// fn may have decided on a wrong Len, such as to allow an insert
+	if stx.err != nil {
+		return stx.err
+	}
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	committed = true
+	return nil
+}
+
+func (t *sqlTx) Read(id any) (any, error) {
+	return read(context.Background(), t.tx, t.table, id)
+}
+
+func (t *sqlTx) Write(key any, item any) error {
+	return write(context.Background(), t.tx, t.table, key, item)
+}
+
+func (t *sqlTx) Delete(id any) error {
+	return remove(context.Background(), t.tx, t.table, id)
+}
+
+// Len returns 0 if the table cannot be counted, and the batch then fails with
+// the error.
+func (t *sqlTx) Len() int {
+	n, err := count(context.Background(), t.tx, t.table)
+	if err != nil && t.err == nil {
+		t.err = fmt.Errorf("counting items of %s: %w", t.table, err)
+	}
+	return n
+}
@@ -0,0 +1,396 @@
+// Copyright 2025 Jacob Philip. All rights reserved.
+// Package sqlite implements store.Store on an embedded SQLite database, using
+// a pure Go driver, so that data survives restarts.
+// Each store table is an SQL table of (seq, key, value) rows. seq keeps the
+// insertion order that ReadRange and Scan use, like the slice of MemDb, and
+// values are JSON documents. Items read back are store.Encoded, typed tables
+// decode them into their item type.
+// Keys must be strings.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/jacobtrvl/inventory-management/internal/store"
+	_ "modernc.org/sqlite"
+)
+
+// ErrKeyType is returned for keys that are not strings.
+var ErrKeyType = errors.New("sqlite store keys must be strings")
+
+// Rows read per query of a scan without snapshot
+const scanChunkSize = 256
+
+// Store is a store.Store backed by a SQLite database file.
+type Store struct {
+	db *sql.DB
+}
+
+var _ store.Store = (*Store)(nil)
+
+// Open opens or creates the database at path. ":memory:" keeps the database
+// in memory, which is useful for tests.
+func Open(path string) (*Store, error) {
+	dsn := url.URL{
+		Scheme: "file",
+		// SQLite decodes %XX in the path, "?" and "#" would end it
+		Opaque:   strings.NewReplacer("%", "%25", "?", "%3F", "#", "%23").Replace(path),
+		RawQuery: "_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)",
+	}
+	db, err := sql.Open("sqlite", dsn.String())
+	if err != nil {
+		return nil, err
+	}
+	// SQLite has a single writer. One connection serializes writes without
+	// busy errors, and an in memory database exists once per connection.
+	db.SetMaxOpenConns(1)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("opening %s: %w", path, err)
+	}
+	return &Store{db: db}, nil
+}
+
+// Close closes the database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// jsonItem is an item as stored, see store.Encoded.
+type jsonItem []byte
+
+func (j jsonItem) Decode(v any) error {
+	return json.Unmarshal(j, v)
+}
+
+// MarshalJSON lets untyped readers pass items on as JSON unchanged.
+func (j jsonItem) MarshalJSON() ([]byte, error) {
+	return j, nil
+}
+
+// ident quotes the SQL table of a store table. Store table names can contain
+// characters such as "/", the prefix keeps them apart from SQLite's own tables.
+func ident(table string) string {
+	return `"t_` + strings.ReplaceAll(table, `"`, `""`) + `"`
+}
+
+func stringKey(key any) (string, error) {
+	k, ok := key.(string)
+	if !ok {
+		return "", fmt.Errorf("%w: got %T", ErrKeyType, key)
+	}
+	return k, nil
+}
+
+// querier is the part of *sql.DB and *sql.Tx the operations need.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// tableError reports missing tables like MemDb does.
+func tableError(table string, err error) error {
+	if err != nil && strings.Contains(err.Error(), "no such table") {
+		return fmt.Errorf("table %s does not exist", table)
+	}
+	return err
+}
+
+func notFound(table string, key any) error {
+	return fmt.Errorf("item with id %v not found in table %s", key, table)
+}
+
+func (s *Store) CreateTable(name string) error {
+	return s.CreateTableContext(context.Background(), name)
+}
+
+func (s *Store) DeleteTable(name string) error {
+	return s.DeleteTableContext(context.Background(), name)
+}
+
+func (s *Store) Write(table string, key any, item any) error {
+	return s.WriteContext(context.Background(), table, key, item)
+}
+
+func (s *Store) Read(table string, id any) (any, error) {
+	return s.ReadContext(context.Background(), table, id)
+}
+
+func (s *Store) Delete(table string, id any) error {
+	return s.DeleteContext(context.Background(), table, id)
+}
+
+func (s *Store) ReadRange(table string, start, end int) ([]any, bool, error) {
+	return s.ReadRangeContext(context.Background(), table, start, end)
+}
+
+func (s *Store) ReadAll(table string) ([]any, error) {
+	return s.ReadAllContext(context.Background(), table)
+}
+
+func (s *Store) Scan(table string, opts store.ScanOptions) (iter.Seq2[any, any], error) {
+	return s.ScanContext(context.Background(), table, opts)
+}
+
+// The context variants wait for the single connection, and so for SQLite's
+// write lock, with ctx. database/sql also interrupts running statements when
+// ctx is done.
+
+func (s *Store) CreateTableContext(ctx context.Context, name string) error {
+	_, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+ident(name)+` (
+		seq   INTEGER PRIMARY KEY AUTOINCREMENT,
+		key   TEXT NOT NULL UNIQUE,
+		value BLOB NOT NULL
+	)`)
+	return err
+}
+
+func (s *Store) DeleteTableContext(ctx context.Context, name string) error {
+	_, err := s.db.ExecContext(ctx, `DROP TABLE `+ident(name))
+	return tableError(name, err)
+}
+
+func (s *Store) WriteContext(ctx context.Context, table string, key any, item any) error {
+	return write(ctx, s.db, table, key, item)
+}
+
+func (s *Store) ReadContext(ctx context.Context, table string, id any) (any, error) {
+	return read(ctx, s.db, table, id)
+}
+
+func (s *Store) DeleteContext(ctx context.Context, table string, id any) error {
+	return remove(ctx, s.db, table, id)
+}
+
+// write inserts or replaces an item. Replacing keeps its position.
+func write(ctx context.Context, q querier, table string, key any, item any) error {
+	k, err := stringKey(key)
+	if err != nil {
+		return err
+	}
+	value, err := json.Marshal(item)
+	if err != nil {
+		return fmt.Errorf("encoding item %s: %w", k, err)
+	}
+	_, err = q.ExecContext(ctx, `INSERT INTO `+ident(table)+` (key, value) VALUES (?, ?)
+		ON CONFLICT (key) DO UPDATE SET value = excluded.value`, k, value)
+	return tableError(table, err)
+}
+
+func read(ctx context.Context, q querier, table string, id any) (any, error) {
+	k, err := stringKey(id)
+	if err != nil {
+		return nil, err
+	}
+	var value []byte
+	err = q.QueryRowContext(ctx, `SELECT value FROM `+ident(table)+` WHERE key = ?`, k).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, notFound(table, id)
+	}
+	if err != nil {
+		return nil, tableError(table, err)
+	}
+	return jsonItem(value), nil
+}
+
+func remove(ctx context.Context, q querier, table string, id any) error {
+	k, err := stringKey(id)
+	if err != nil {
+		return err
+	}
+	res, err := q.ExecContext(ctx, `DELETE FROM `+ident(table)+` WHERE key = ?`, k)
+	if err != nil {
+		return tableError(table, err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return notFound(table, id)
+	}
+	return nil
+}
+
+func count(ctx context.Context, q querier, table string) (int, error) {
+	var n int
+	err := q.QueryRowContext(ctx, `SELECT COUNT(*) FROM `+ident(table)).Scan(&n)
+	return n, tableError(table, err)
+}
+
+// ReadRangeContext retrieves the items in [start, end) in insertion order.
+// Returns the items, whether the end of the table was reached, and an error
+// if start is past the end, like MemDb.
+func (s *Store) ReadRangeContext(ctx context.Context, table string, start, end int) ([]any, bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+	n, err := count(ctx, tx, table)
+	if err != nil {
+		return nil, false, err
+	}
+	start = max(start, 0)
+	end = min(end, n)
+	if start > end {
+		return nil, false, fmt.Errorf("invalid start or end index")
+	}
+	items, err := queryItems(ctx, tx, table, `ORDER BY seq LIMIT ? OFFSET ?`, end-start, start)
+	if err != nil {
+		return nil, false, err
+	}
+	return items, end >= n, nil
+}
+
+func (s *Store) ReadAllContext(ctx context.Context, table string) ([]any, error) {
+	return queryItems(ctx, s.db, table, `ORDER BY seq`)
+}
+
+func queryItems(ctx context.Context, q querier, table, clause string, args ...any) ([]any, error) {
+	rows, err := q.QueryContext(ctx, `SELECT value FROM `+ident(table)+` `+clause, args...)
+	if err != nil {
+		return nil, tableError(table, err)
+	}
+	defer rows.Close()
+	items := make([]any, 0)
+	for rows.Next() {
+		var value []byte
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		items = append(items, jsonItem(value))
+	}
+	return items, rows.Err()
+}
+
+// ScanContext iterates over the items in insertion order, see
+// store.ScanOptions. Without a snapshot each chunk is a separate query,
+// resuming after the seq of the last row, so writes between chunks may be
+// seen. The iteration ends when a query fails, including because ctx is done.
+func (s *Store) ScanContext(ctx context.Context, table string, opts store.ScanOptions) (iter.Seq2[any, any], error) {
+	var where []string
+	var args []any
+	// Rows of the first chunk come at or after the start key
+	if opts.StartKey != nil {
+		k, err := stringKey(opts.StartKey)
+		if err != nil {
+			return nil, err
+		}
+		var seq int64
+		err = s.db.QueryRowContext(ctx, `SELECT seq FROM `+ident(table)+` WHERE key = ?`, k).Scan(&seq)
+		if errors.Is(err, sql.ErrNoRows) {
+			return func(func(any, any) bool) {}, nil
+		}
+		if err != nil {
+			return nil, tableError(table, err)
+		}
+		if opts.Reverse {
+			where, args = append(where, "seq <= ?"), append(args, seq)
+		} else {
+			where, args = append(where, "seq >= ?"), append(args, seq)
+		}
+	} else if _, err := count(ctx, s.db, table); err != nil {
+		return nil, err
+	}
+	if opts.Prefix != "" {
+		// Text compares bytewise, so the keys with the prefix are a range
+		where, args = append(where, "key >= ?"), append(args, opts.Prefix)
+		if end, ok := prefixEnd(opts.Prefix); ok {
+			where, args = append(where, "key < ?"), append(args, end)
+		}
+	}
+	order := "ASC"
+	after := "seq > ?"
+	if opts.Reverse {
+		order, after = "DESC", "seq < ?"
+	}
+
+	query := func(where []string, args []any, limit int) ([]scanRow, error) {
+		q := `SELECT seq, key, value FROM ` + ident(table)
+		if len(where) > 0 {
+			q += ` WHERE ` + strings.Join(where, " AND ")
+		}
+		q += ` ORDER BY seq ` + order
+		if limit > 0 {
+			q += fmt.Sprintf(` LIMIT %d`, limit)
+		}
+		rows, err := s.db.QueryContext(ctx, q, args...)
+		if err != nil {
+			return nil, tableError(table, err)
+		}
+		defer rows.Close()
+		var result []scanRow
+		for rows.Next() {
+			var r scanRow
+			if err := rows.Scan(&r.seq, &r.key, &r.value); err != nil {
+				return nil, err
+			}
+			result = append(result, r)
+		}
+		return result, rows.Err()
+	}
+
+	if opts.Snapshot {
+		rows, err := query(where, args, 0)
+		if err != nil {
+			return nil, err
+		}
+		return func(yield func(any, any) bool) {
+			for _, r := range rows {
+				if !yield(r.key, jsonItem(r.value)) {
+					return
+				}
+			}
+		}, nil
+	}
+	return func(yield func(any, any) bool) {
+		where, args := where, args
+		for first := true; ; first = false {
+			rows, err := query(where, args, scanChunkSize)
+			// The table was deleted during the scan, ctx is done or the
+			// database failed
+			if err != nil {
+				yield(nil, &store.ScanError{Err: err})
+				return
+			}
+			for _, r := range rows {
+				if !yield(r.key, jsonItem(r.value)) {
+					return
+				}
+			}
+			if len(rows) < scanChunkSize {
+				return
+			}
+			if first {
+				where = append(slices.Clone(where), after)
+				args = append(slices.Clone(args), nil)
+			}
+			args[len(args)-1] = rows[len(rows)-1].seq
+		}
+	}, nil
+}
+
+// prefixEnd returns the smallest string greater than every string starting
+// with prefix, false if there is none.
+func prefixEnd(prefix string) (string, bool) {
+	b := []byte(prefix)
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i] < 0xff {
+			b[i]++
+			return string(b[:i+1]), true
+		}
+	}
+	return "", false
+}
+
+type scanRow struct {
+	seq   int64
+	key   string
+	value []byte
+}
@@ -0,0 +1,91 @@
+// Copyright 2025 Jacob Philip. All rights reserved.
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jacobtrvl/inventory-management/internal/store"
+	"github.com/jacobtrvl/inventory-management/internal/store/storetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func open(t *testing.T, path string) *Store {
+	t.Helper()
+	s, err := Open(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store {
+		return open(t, filepath.Join(t.TempDir(), "store.db"))
+	})
+}
+
+func TestPersistence(t *testing.T) {
+	// Characters with a meaning in SQLite URIs are part of the file name
+	path := filepath.Join(t.TempDir(), "store?mode=ro#%41.db")
+	s := open(t, path)
+	tbl, err := store.NewTable[string, storetest.Item](s, "acme/products")
+	require.NoError(t, err)
+	require.NoError(t, tbl.Write("1", storetest.Item{Name: "Pen", N: 3}))
+	require.NoError(t, s.Close())
+	_, err = os.Stat(path)
+	require.NoError(t, err)
+
+	tbl, err = store.NewTable[string, storetest.Item](open(t, path), "acme/products")
+	require.NoError(t, err)
+	v, err := tbl.Read("1")
+	assert.NoError(t, err)
+	assert.Equal(t, storetest.Item{Name: "Pen", N: 3}, v)
+
+	assert.ErrorIs(t, open(t, ":memory:").Write("t", 1, "x"), ErrKeyType)
+}
+
+func TestScanFailure(t *testing.T) {
+	s := open(t, filepath.Join(t.TempDir(), "store.db"))
+	tbl, err := store.NewTable[string, storetest.Item](s, "t")
+	require.NoError(t, err)
+	for i := range 300 {
+		require.NoError(t, tbl.Write(fmt.Sprint(i), storetest.Item{N: i}))
+	}
+
+	// A chunk that cannot be read ends the scan with its error, not as if
+	// the table ended there
+	n := 0
+	for _, err = range tbl.Scan(store.ScanOptions{}) {
+		if err != nil {
+			break
+		}
+		if n++; n == 1 {
+			require.NoError(t, s.Close())
+		}
+	}
+	assert.Error(t, err)
+	assert.Less(t, n, 300)
+}
+
+func TestBatchLenFailure(t *testing.T) {
+	s := open(t, filepath.Join(t.TempDir(), "store.db"))
+	require.NoError(t, s.CreateTable("t"))
+
+	// A batch that could not count the table fails, whatever fn returns, so
+	// that a wrong count cannot be acted upon
+	err := s.Batch("t", func(tx store.Tx) error {
+		require.NoError(t, tx.Write("a", storetest.Item{Name: "a"}))
+		_, err := tx.(*sqlTx).tx.ExecContext(context.Background(), `ALTER TABLE `+ident("t")+` RENAME TO `+ident("u"))
+		require.NoError(t, err)
+		assert.Equal(t, 0, tx.Len())
+		return nil
+	})
+	assert.ErrorContains(t, err, "counting items of t")
+	all, err := s.ReadAll("t")
+	assert.NoError(t, err, "rolled back")
+	assert.Empty(t, all)
+}
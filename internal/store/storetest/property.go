@@ -0,0 +1,214 @@
+// Copyright 2025 Jacob Philip. All rights reserved.
+package storetest
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jacobtrvl/inventory-management/internal/store"
+	"github.com/stretchr/testify/require"
+)
+
+// model is the reference a store is compared with: the keys in insertion
+// order and their items.
+type model struct {
+	keys  []string
+	items map[string]Item
+}
+
+func newModel() *model {
+	return &model{items: make(map[string]Item)}
+}
+
+func (m *model) clone() *model {
+	return &model{keys: slices.Clone(m.keys), items: maps.Clone(m.items)}
+}
+
+func (m *model) write(k string, v Item) {
+	if _, ok := m.items[k]; !ok {
+		m.keys = append(m.keys, k)
+	}
+	m.items[k] = v
+}
+
+func (m *model) delete(k string) bool {
+	if _, ok := m.items[k]; !ok {
+		return false
+	}
+	delete(m.items, k)
+	m.keys = slices.DeleteFunc(m.keys, func(key string) bool { return key == k })
+	return true
+}
+
+func (m *model) values(keys []string) []Item {
+	values := make([]Item, 0, len(keys))
+	for _, k := range keys {
+		values = append(values, m.items[k])
+	}
+	return values
+}
+
+// readRange returns the items in [start, end), whether that is the end of
+// the table, and false for invalid ranges.
+func (m *model) readRange(start, end int) ([]Item, bool, bool) {
+	start, end = max(start, 0), min(end, len(m.keys))
+	if start > end {
+		return nil, false, false
+	}
+	return m.values(m.keys[start:end]), end >= len(m.keys), true
+}
+
+func (m *model) scan(opts store.ScanOptions) []string {
+	keys := m.keys
+	if opts.StartKey != nil {
+		idx := slices.Index(keys, opts.StartKey.(string))
+		if idx < 0 {
+			return nil
+		}
+		if opts.Reverse {
+			keys = keys[:idx+1]
+		} else {
+			keys = keys[idx:]
+		}
+	}
+	var result []string
+	for i := range keys {
+		if opts.Reverse {
+			i = len(keys) - 1 - i
+		}
+		if strings.HasPrefix(keys[i], opts.Prefix) {
+			result = append(result, keys[i])
+		}
+	}
+	return result
+}
+
+// seed returns STORETEST_SEED, or a new seed.
+func seed(t *testing.T) uint64 {
+	if v := os.Getenv("STORETEST_SEED"); v != "" {
+		s, err := strconv.ParseUint(v, 10, 64)
+		require.NoError(t, err, "STORETEST_SEED")
+		return s
+	}
+	return uint64(time.Now().UnixNano())
+}
+
+// testProperty applies random operations to the store and a model, comparing
+// every result. Keys come from a small set, so that overwrites, deletes of
+// missing keys and reinserts are frequent.
+func testProperty(t *testing.T, db store.Store) {
+	steps := 1000
+	if testing.Short() {
+		steps = 200
+	}
+	seed := seed(t)
+	r := rand.New(rand.NewPCG(seed, seed))
+	key := func() string {
+		return fmt.Sprintf("%c%d", 'a'+r.IntN(3), r.IntN(8))
+	}
+	m := newModel()
+	fill(t, db, "t")
+
+	for step := range steps {
+		msg := fmt.Sprintf("seed %d, step %d", seed, step)
+		switch op := r.IntN(14); {
+		case op < 4:
+			k := key()
+			v := Item{Name: k, N: step}
+			require.NoError(t, db.Write("t", k, v), msg)
+			m.write(k, v)
+		case op < 6:
+			k := key()
+			err := db.Delete("t", k)
+			require.Equal(t, m.delete(k), err == nil, "%s: delete %s: %v", msg, k, err)
+		case op < 8:
+			k := key()
+			item, err := db.Read("t", k)
+			want, ok := m.items[k]
+			require.Equal(t, ok, err == nil, "%s: read %s: %v", msg, k, err)
+			if ok {
+				require.Equal(t, want, Decode(t, item), "%s: read %s", msg, k)
+			}
+		case op < 10:
+			start := r.IntN(len(m.keys)+3) - 1
+			end := start + r.IntN(6) - 1
+			got, eof, err := db.ReadRange("t", start, end)
+			want, wantEOF, ok := m.readRange(start, end)
+			require.Equal(t, ok, err == nil, "%s: range [%d, %d): %v", msg, start, end, err)
+			if ok {
+				require.Equal(t, want, decodeAll(t, got), "%s: range [%d, %d)", msg, start, end)
+				require.Equal(t, wantEOF, eof, "%s: range [%d, %d)", msg, start, end)
+			}
+		case op < 11:
+			all, err := db.ReadAll("t")
+			require.NoError(t, err, msg)
+			require.Equal(t, m.values(m.keys), decodeAll(t, all), msg)
+		case op < 13:
+			opts := store.ScanOptions{Reverse: r.IntN(2) == 0, Snapshot: r.IntN(2) == 0}
+			if r.IntN(2) == 0 {
+				opts.StartKey = key()
+			}
+			if r.IntN(2) == 0 {
+				opts.Prefix = string(rune('a' + r.IntN(3)))
+			}
+			seq, err := db.Scan("t", opts)
+			require.NoError(t, err, msg)
+			var keys []string
+			for k, item := range seq {
+				require.Equal(t, k, Decode(t, item).Name, "%s: scan %+v", msg, opts)
+				keys = append(keys, k.(string))
+			}
+			require.Equal(t, m.scan(opts), keys, "%s: scan %+v", msg, opts)
+		default:
+			m = propertyBatch(t, db, r, m, key, msg)
+		}
+	}
+}
+
+// propertyBatch runs a random batch, checking reads within it against a
+// copy of the model, and returns the model after the batch, which is the
+// copy if the batch committed.
+func propertyBatch(t *testing.T, db store.Store, r *rand.Rand, m *model, key func() string, msg string) *model {
+	tx := m.clone()
+	rollback := r.IntN(3) == 0
+	err := db.Batch("t", func(btx store.Tx) error {
+		for i := range 1 + r.IntN(8) {
+			k := key()
+			switch r.IntN(3) {
+			case 0:
+				v := Item{Name: k, N: -i}
+				require.NoError(t, btx.Write(k, v), msg)
+				tx.write(k, v)
+			case 1:
+				err := btx.Delete(k)
+				require.Equal(t, tx.delete(k), err == nil, "%s: batch delete %s: %v", msg, k, err)
+			default:
+				item, err := btx.Read(k)
+				want, ok := tx.items[k]
+				require.Equal(t, ok, err == nil, "%s: batch read %s: %v", msg, k, err)
+				if ok {
+					require.Equal(t, want, Decode(t, item), "%s: batch read %s", msg, k)
+				}
+			}
+			require.Equal(t, len(tx.keys), btx.Len(), "%s: batch len", msg)
+		}
+		if rollback {
+			return store.ErrRollback
+		}
+		return nil
+	})
+	if rollback {
+		require.True(t, errors.Is(err, store.ErrRollback), "%s: batch: %v", msg, err)
+		return m
+	}
+	require.NoError(t, err, msg)
+	return tx
+}
@@ -0,0 +1,492 @@
+// Copyright 2025 Jacob Philip. All rights reserved.
+// Package storetest checks that store.Store implementations behave like
+// MemDb. Implementations run the suite from their own tests:
+//
+//	func TestConformance(t *testing.T) {
+//		storetest.Run(t, func(t *testing.T) store.Store { return store.NewMemDb() })
+//	}
+//
+// Keys are strings, the one key type every implementation supports.
+//
+// Besides table driven tests of each method, the suite runs random operations
+// against a store and a reference model, failing at the first difference, and
+// runs writers, batches and readers concurrently, which is meant for -race.
+// Property failures report their seed, setting STORETEST_SEED replays them.
+// With -short both run fewer operations.
+package storetest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/jacobtrvl/inventory-management/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Item is the value type the suite stores.
+type Item struct {
+	Name string `json:"name"`
+	N    int    `json:"n"`
+}
+
+// Run runs the suite. newStore returns a new, empty store for each test.
+func Run(t *testing.T, newStore func(t *testing.T) store.Store) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, db store.Store)
+	}{
+		{"Tables", testTables},
+		{"ReadWrite", testReadWrite},
+		{"ReadRange", testReadRange},
+		{"Scan", testScan},
+		{"Batch", testBatch},
+		{"Context", testContext},
+		{"Property", testProperty},
+		{"Concurrency", testConcurrency},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newStore(t))
+		})
+	}
+}
+
+// Decode returns an item read from db as an Item, decoding it if the store
+// serializes items.
+func Decode(t *testing.T, item any) Item {
+	t.Helper()
+	if e, ok := item.(store.Encoded); ok {
+		var v Item
+		require.NoError(t, e.Decode(&v))
+		return v
+	}
+	v, ok := item.(Item)
+	require.True(t, ok, "item of type %T", item)
+	return v
+}
+
+func decodeAll(t *testing.T, items []any) []Item {
+	t.Helper()
+	values := make([]Item, 0, len(items))
+	for _, item := range items {
+		values = append(values, Decode(t, item))
+	}
+	return values
+}
+
+func items(names ...string) []Item {
+	values := make([]Item, 0, len(names))
+	for _, name := range names {
+		values = append(values, Item{Name: name})
+	}
+	return values
+}
+
+// fill writes an Item named after each key, in order.
+func fill(t *testing.T, db store.Store, table string, keys ...string) {
+	t.Helper()
+	require.NoError(t, db.CreateTable(table))
+	for _, k := range keys {
+		require.NoError(t, db.Write(table, k, Item{Name: k}))
+	}
+}
+
+func testTables(t *testing.T, db store.Store) {
+	fill(t, db, "a/products", "1")
+	assert.NoError(t, db.CreateTable("a/products"), "creating an existing table is a no-op")
+	all, err := db.ReadAll("a/products")
+	assert.NoError(t, err)
+	assert.Len(t, all, 1, "an existing table keeps its items")
+
+	assert.NoError(t, db.DeleteTable("a/products"))
+	assert.Error(t, db.DeleteTable("a/products"))
+	for name, err := range map[string]error{
+		"Write":  db.Write("a/products", "1", Item{}),
+		"Delete": db.Delete("a/products", "1"),
+		"Batch":  db.Batch("a/products", func(store.Tx) error { return nil }),
+	} {
+		assert.Error(t, err, name)
+	}
+	_, err = db.Read("a/products", "1")
+	assert.Error(t, err)
+	_, err = db.ReadAll("a/products")
+	assert.Error(t, err)
+	_, _, err = db.ReadRange("a/products", 0, 1)
+	assert.Error(t, err)
+	_, err = db.Scan("a/products", store.ScanOptions{})
+	assert.Error(t, err)
+
+	// A deleted table can be created again, empty
+	require.NoError(t, db.CreateTable("a/products"))
+	all, err = db.ReadAll("a/products")
+	assert.NoError(t, err)
+	assert.Empty(t, all)
+
+	// Deleting a table leaves the others, including ones sharing a prefix
+	// or with unusual names, alone
+	names := []string{"b/products", "b/products/archive", `b/"quoted" products`}
+	for _, name := range names {
+		fill(t, db, name, "1", "2")
+	}
+	assert.NoError(t, db.DeleteTable("b/products"))
+	for _, name := range names[1:] {
+		all, err := db.ReadAll(name)
+		assert.NoError(t, err, name)
+		assert.Equal(t, items("1", "2"), decodeAll(t, all), name)
+	}
+	assert.Error(t, db.DeleteTable("never/created"))
+
+	// Deleting a table during a scan ends the scan, without panicking or
+	// repeating items. A scan that ends before the last item says why.
+	fill(t, db, "c/products")
+	for i := range 600 {
+		require.NoError(t, db.Write("c/products", fmt.Sprint(i), Item{Name: fmt.Sprint(i)}))
+	}
+	seq, err := db.Scan("c/products", store.ScanOptions{})
+	require.NoError(t, err)
+	seen := make(map[any]bool)
+	var last any
+	assert.NotPanics(t, func() {
+		for k, item := range seq {
+			last = item
+			if _, ok := item.(*store.ScanError); ok {
+				assert.Nil(t, k)
+				continue
+			}
+			assert.False(t, seen[k], "key %v repeated", k)
+			seen[k] = true
+			if len(seen) == 1 {
+				require.NoError(t, db.DeleteTable("c/products"))
+			}
+		}
+	})
+	if len(seen) < 600 {
+		assert.IsType(t, &store.ScanError{}, last, "scan ended after %d items", len(seen))
+	}
+
+	_, err = db.ReadAll("c/products")
+	assert.Error(t, err)
+}
+
+func testReadWrite(t *testing.T, db store.Store) {
+	fill(t, db, "t", "a", "b", "c")
+	item, err := db.Read("t", "b")
+	assert.NoError(t, err)
+	assert.Equal(t, Item{Name: "b"}, Decode(t, item))
+	// Untyped readers get the JSON of the item, or an error if the store
+	// cannot tell without the item type
+	if data, err := json.Marshal(item); err == nil {
+		assert.JSONEq(t, `{"name": "b", "n": 0}`, string(data))
+	}
+	_, err = db.Read("t", "x")
+	assert.Error(t, err)
+
+	// Overwrites keep the position of the item
+	assert.NoError(t, db.Write("t", "a", Item{Name: "a", N: 2}))
+	all, err := db.ReadAll("t")
+	assert.NoError(t, err)
+	assert.Equal(t, []Item{{Name: "a", N: 2}, {Name: "b"}, {Name: "c"}}, decodeAll(t, all))
+
+	assert.NoError(t, db.Delete("t", "b"))
+	assert.Error(t, db.Delete("t", "b"))
+	_, err = db.Read("t", "b")
+	assert.Error(t, err)
+	// Deletes keep the order of the rest, writes append
+	assert.NoError(t, db.Write("t", "b", Item{Name: "b"}))
+	all, err = db.ReadAll("t")
+	assert.NoError(t, err)
+	assert.Equal(t, []Item{{Name: "a", N: 2}, {Name: "c"}, {Name: "b"}}, decodeAll(t, all))
+}
+
+func testReadRange(t *testing.T, db store.Store) {
+	fill(t, db, "t", "a", "b", "c", "d", "e")
+	tests := []struct {
+		start, end int
+		want       []Item
+		eof        bool
+	}{
+		{0, 2, items("a", "b"), false},
+		{2, 5, items("c", "d", "e"), true},
+		{3, 10, items("d", "e"), true},
+		{-1, 1, items("a"), false},
+		{5, 10, items(), true},
+		{0, 0, items(), false},
+	}
+	for _, tt := range tests {
+		got, eof, err := db.ReadRange("t", tt.start, tt.end)
+		assert.NoError(t, err, "[%d, %d)", tt.start, tt.end)
+		assert.Equal(t, tt.want, decodeAll(t, got), "[%d, %d)", tt.start, tt.end)
+		assert.Equal(t, tt.eof, eof, "[%d, %d)", tt.start, tt.end)
+	}
+	for _, r := range [][2]int{{6, 10}, {3, 1}, {0, -1}} {
+		_, _, err := db.ReadRange("t", r[0], r[1])
+		assert.Error(t, err, "[%d, %d)", r[0], r[1])
+	}
+
+	// EOF follows the current length of the table
+	require.NoError(t, db.Delete("t", "e"))
+	got, eof, err := db.ReadRange("t", 2, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, items("c", "d"), decodeAll(t, got))
+	assert.True(t, eof, "d is the last item after deleting e")
+	require.NoError(t, db.Write("t", "f", Item{Name: "f"}))
+	_, eof, err = db.ReadRange("t", 2, 4)
+	assert.NoError(t, err)
+	assert.False(t, eof, "f was appended")
+
+	// Paging until EOF visits every item once, whatever the page size
+	for size := 1; size <= 6; size++ {
+		var all []Item
+		for start := 0; ; start += size {
+			page, eof, err := db.ReadRange("t", start, start+size)
+			require.NoError(t, err, "page size %d", size)
+			all = append(all, decodeAll(t, page)...)
+			if eof {
+				break
+			}
+		}
+		assert.Equal(t, items("a", "b", "c", "d", "f"), all, "page size %d", size)
+	}
+
+	// An empty table is at EOF from the start
+	fill(t, db, "empty")
+	for _, r := range [][2]int{{0, 0}, {0, 10}} {
+		got, eof, err := db.ReadRange("empty", r[0], r[1])
+		assert.NoError(t, err, "[%d, %d)", r[0], r[1])
+		assert.Empty(t, got)
+		assert.True(t, eof, "[%d, %d)", r[0], r[1])
+	}
+	_, _, err = db.ReadRange("empty", 1, 2)
+	assert.Error(t, err)
+}
+
+func testScan(t *testing.T, db store.Store) {
+	fill(t, db, "t", "a1", "b1", "a2", "b2", "a3")
+	scan := func(opts store.ScanOptions) []string {
+		t.Helper()
+		seq, err := db.Scan("t", opts)
+		require.NoError(t, err)
+		var keys []string
+		for k, item := range seq {
+			assert.Equal(t, k, Decode(t, item).Name)
+			keys = append(keys, k.(string))
+		}
+		return keys
+	}
+	tests := []struct {
+		opts store.ScanOptions
+		want []string
+	}{
+		{store.ScanOptions{}, []string{"a1", "b1", "a2", "b2", "a3"}},
+		{store.ScanOptions{Reverse: true}, []string{"a3", "b2", "a2", "b1", "a1"}},
+		{store.ScanOptions{Prefix: "a"}, []string{"a1", "a2", "a3"}},
+		{store.ScanOptions{StartKey: "a2"}, []string{"a2", "b2", "a3"}},
+		{store.ScanOptions{StartKey: "b2", Reverse: true, Prefix: "b"}, []string{"b2", "b1"}},
+		{store.ScanOptions{StartKey: "x"}, nil},
+		{store.ScanOptions{Snapshot: true, Prefix: "b"}, []string{"b1", "b2"}},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, scan(tt.opts), "%+v", tt.opts)
+	}
+	// Prefixes are bytes, also beyond ASCII. "ê" follows "é" in UTF-8.
+	fill(t, db, "t", "é1", "e1", "ê1", "é2", "éa")
+	assert.Equal(t, []string{"é1", "é2", "éa"}, scan(store.ScanOptions{Prefix: "é"}))
+	assert.Equal(t, []string{"ê1"}, scan(store.ScanOptions{Prefix: "ê"}))
+	assert.Equal(t, []string{"é2"}, scan(store.ScanOptions{Prefix: "é2"}))
+
+	// Large enough for several chunks. Deleting the item just seen and
+	// appending another must neither skip nor repeat items.
+	const n = 1000
+	fill(t, db, "big")
+	for i := range n {
+		require.NoError(t, db.Write("big", fmt.Sprint(i), Item{N: i}))
+	}
+	for _, snapshot := range []bool{false, true} {
+		seq, err := db.Scan("big", store.ScanOptions{Snapshot: snapshot})
+		require.NoError(t, err)
+		seen := make(map[string]int)
+		for k, item := range seq {
+			seen[k.(string)]++
+			if i := Decode(t, item).N; i < n {
+				require.NoError(t, db.Delete("big", k))
+				require.NoError(t, db.Write("big", fmt.Sprint(n+i), Item{N: n + i}))
+			}
+		}
+		for k, count := range seen {
+			assert.Equal(t, 1, count, "key %s, snapshot %v", k, snapshot)
+		}
+		if snapshot {
+			assert.Len(t, seen, n)
+		} else {
+			assert.Len(t, seen, 2*n, "items appended during the scan are seen")
+		}
+		require.NoError(t, db.DeleteTable("big"))
+		fill(t, db, "big")
+		for i := range n {
+			require.NoError(t, db.Write("big", fmt.Sprint(i), Item{N: i}))
+		}
+	}
+
+	// Prefix scans over several chunks, interleaved with other keys
+	var want []string
+	for i := range n {
+		require.NoError(t, db.Write("big", fmt.Sprint("x", i), Item{N: i}))
+		require.NoError(t, db.Write("big", fmt.Sprint("y", i), Item{N: i}))
+		want = append(want, fmt.Sprint("x", i))
+	}
+	seq, err := db.Scan("big", store.ScanOptions{Prefix: "x"})
+	require.NoError(t, err)
+	var keys []string
+	for k := range seq {
+		keys = append(keys, k.(string))
+	}
+	assert.Equal(t, want, keys)
+	seq, err = db.Scan("big", store.ScanOptions{Prefix: "x", StartKey: "x700", Reverse: true})
+	require.NoError(t, err)
+	keys = keys[:0]
+	for k := range seq {
+		keys = append(keys, k.(string))
+	}
+	slices.Reverse(want[:701])
+	assert.Equal(t, want[:701], keys)
+}
+
+func testBatch(t *testing.T, db store.Store) {
+	fill(t, db, "t", "a", "b", "c", "d")
+	err := db.Batch("t", func(tx store.Tx) error {
+		assert.NoError(t, tx.Delete("b"))
+		assert.NoError(t, tx.Write("a", Item{Name: "a", N: 2}))
+		assert.NoError(t, tx.Write("e", Item{Name: "e"}))
+		assert.NoError(t, tx.Delete("d"))
+		_, err := tx.Read("b")
+		assert.Error(t, err, "deleted within the batch")
+		item, err := tx.Read("e")
+		assert.NoError(t, err, "written within the batch")
+		assert.Equal(t, Item{Name: "e"}, Decode(t, item))
+		assert.Equal(t, 3, tx.Len())
+		return nil
+	})
+	assert.NoError(t, err)
+	want := []Item{{Name: "a", N: 2}, {Name: "c"}, {Name: "e"}}
+	all, err := db.ReadAll("t")
+	assert.NoError(t, err)
+	assert.Equal(t, want, decodeAll(t, all))
+
+	// Errors and panics roll the batch back
+	fail := errors.New("fail")
+	err = db.Batch("t", func(tx store.Tx) error {
+		assert.NoError(t, tx.Write("c", Item{Name: "c", N: 2}))
+		assert.NoError(t, tx.Delete("a"))
+		assert.NoError(t, tx.Write("f", Item{Name: "f"}))
+		assert.NoError(t, tx.Write("a", Item{Name: "a", N: 3}))
+		return fail
+	})
+	assert.ErrorIs(t, err, fail)
+	assert.ErrorIs(t, db.Batch("t", func(tx store.Tx) error { return store.ErrRollback }), store.ErrRollback)
+	assert.Panics(t, func() {
+		db.Batch("t", func(tx store.Tx) error {
+			assert.NoError(t, tx.Delete("c"))
+			panic("batch")
+		})
+	})
+	all, err = db.ReadAll("t")
+	assert.NoError(t, err)
+	assert.Equal(t, want, decodeAll(t, all))
+	_, err = db.Read("t", "f")
+	assert.Error(t, err)
+
+	// Typed tables work on top of every store
+	tbl, err := store.NewTable[string, Item](db, "t")
+	require.NoError(t, err)
+	v, err := tbl.Read("c")
+	assert.NoError(t, err)
+	assert.Equal(t, Item{Name: "c"}, v)
+}
+
+func testContext(t *testing.T, db store.Store) {
+	fill(t, db, "t", "a", "b")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := db.ReadContext(ctx, "t", "a")
+	assert.ErrorIs(t, err, context.Canceled, "Read")
+	_, _, err = db.ReadRangeContext(ctx, "t", 0, 1)
+	assert.ErrorIs(t, err, context.Canceled, "ReadRange")
+	_, err = db.ReadAllContext(ctx, "t")
+	assert.ErrorIs(t, err, context.Canceled, "ReadAll")
+	for name, err := range map[string]error{
+		"Write":       db.WriteContext(ctx, "t", "c", Item{Name: "c"}),
+		"Delete":      db.DeleteContext(ctx, "t", "a"),
+		"CreateTable": db.CreateTableContext(ctx, "u"),
+		"DeleteTable": db.DeleteTableContext(ctx, "t"),
+		"Batch":       db.BatchContext(ctx, "t", func(store.Tx) error { return nil }),
+	} {
+		assert.ErrorIs(t, err, context.Canceled, name)
+	}
+	if seq, err := db.ScanContext(ctx, "t", store.ScanOptions{}); err == nil {
+		for k, item := range seq {
+			if serr, ok := item.(*store.ScanError); ok {
+				assert.ErrorIs(t, serr, context.Canceled)
+				continue
+			}
+			assert.Fail(t, "scan with a done context yielded", "key %v", k)
+		}
+	}
+	all, err := db.ReadAll("t")
+	assert.NoError(t, err)
+	assert.Equal(t, items("a", "b"), decodeAll(t, all), "nothing changed")
+
+	// A writer waiting behind a batch gives up at its deadline
+	locked, release := make(chan struct{}), make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- db.Batch("t", func(tx store.Tx) error {
+			close(locked)
+			<-release
+			return nil
+		})
+	}()
+	<-locked
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, db.WriteContext(ctx, "t", "c", Item{Name: "c"}), context.DeadlineExceeded)
+	close(release)
+	assert.NoError(t, <-done)
+	_, err = db.Read("t", "c")
+	assert.Error(t, err, "the write gave up")
+
+	// A batch holding the lock runs to completion
+	ctx, cancel = context.WithCancel(context.Background())
+	assert.NoError(t, db.BatchContext(ctx, "t", func(tx store.Tx) error {
+		cancel()
+		return tx.Write("c", Item{Name: "c"})
+	}))
+	_, err = db.Read("t", "c")
+	assert.NoError(t, err)
+
+	// Scans end soon after ctx is done
+	fill(t, db, "big")
+	for i := range 600 {
+		require.NoError(t, db.Write("big", fmt.Sprint(i), Item{Name: fmt.Sprint(i)}))
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	seq, err := db.ScanContext(ctx, "big", store.ScanOptions{})
+	require.NoError(t, err)
+	n := 0
+	var last any
+	for _, item := range seq {
+		n++
+		last = item
+		cancel()
+	}
+	assert.Less(t, n, 600)
+	if assert.IsType(t, &store.ScanError{}, last) {
+		assert.ErrorIs(t, last.(error), context.Canceled)
+	}
+}
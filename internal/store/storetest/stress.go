@@ -0,0 +1,163 @@
+// Copyright 2025 Jacob Philip. All rights reserved.
+package storetest
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/jacobtrvl/inventory-management/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testConcurrency runs writers, batches and readers on one table at once.
+// Writers own disjoint keys and check them against their own model at the
+// end. Batches increment a shared counter, so lost updates show in its final
+// value. Readers check that items present for the whole test are seen
+// exactly once by every scan and page through ReadRange while the table
+// changes. Failures in goroutines are reported with assert, which is safe
+// outside the test goroutine.
+func testConcurrency(t *testing.T, db store.Store) {
+	writers, ops, incrementers, increments := 4, 200, 4, 25
+	if testing.Short() {
+		ops, increments = 50, 10
+	}
+	const fixed = 20
+	fill(t, db, "t")
+	for i := range fixed {
+		k := fmt.Sprintf("fixed/%d", i)
+		require.NoError(t, db.Write("t", k, Item{Name: k}))
+	}
+	require.NoError(t, db.Write("t", "counter", Item{Name: "counter"}))
+
+	var done atomic.Bool
+	var work, readers sync.WaitGroup
+	models := make([]*model, writers)
+	for w := range writers {
+		models[w] = newModel()
+		work.Add(1)
+		go func() {
+			defer work.Done()
+			r := rand.New(rand.NewPCG(uint64(w), 0))
+			for i := range ops {
+				k := fmt.Sprintf("w%d/%d", w, r.IntN(10))
+				if r.IntN(3) == 0 {
+					err := db.Delete("t", k)
+					assert.Equal(t, models[w].delete(k), err == nil, "delete %s: %v", k, err)
+					continue
+				}
+				v := Item{Name: k, N: i}
+				if assert.NoError(t, db.Write("t", k, v)) {
+					models[w].write(k, v)
+				}
+			}
+		}()
+	}
+	for range incrementers {
+		work.Add(1)
+		go func() {
+			defer work.Done()
+			for range increments {
+				err := db.Batch("t", func(tx store.Tx) error {
+					item, err := tx.Read("counter")
+					if err != nil {
+						return err
+					}
+					v := decodeItem(item)
+					v.N++
+					return tx.Write("counter", v)
+				})
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	for _, snapshot := range []bool{false, true} {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for !done.Load() {
+				seq, err := db.Scan("t", store.ScanOptions{Snapshot: snapshot})
+				if !assert.NoError(t, err) {
+					return
+				}
+				seen := make(map[string]int)
+				for k, item := range seq {
+					key := k.(string)
+					seen[key]++
+					assert.Equal(t, key, decodeItem(item).Name)
+				}
+				for i := range fixed {
+					k := fmt.Sprintf("fixed/%d", i)
+					assert.Equal(t, 1, seen[k], "%s, snapshot %v", k, snapshot)
+				}
+				if snapshot {
+					for k, count := range seen {
+						assert.Equal(t, 1, count, "%s repeated in a snapshot", k)
+					}
+				}
+			}
+		}()
+	}
+	readers.Add(1)
+	go func() {
+		defer readers.Done()
+		for !done.Load() {
+			for start := 0; ; start += 7 {
+				page, eof, err := db.ReadRange("t", start, start+7)
+				// The table shrank past start since the last page
+				if err != nil || eof {
+					break
+				}
+				assert.Len(t, page, 7)
+			}
+		}
+	}()
+	work.Wait()
+	done.Store(true)
+	readers.Wait()
+
+	item, err := db.Read("t", "counter")
+	require.NoError(t, err)
+	assert.Equal(t, incrementers*increments, Decode(t, item).N, "lost batch updates")
+	all, err := db.ReadAll("t")
+	require.NoError(t, err)
+	got := make(map[int]*model)
+	for _, v := range decodeAll(t, all) {
+		var w, k int
+		if _, err := fmt.Sscanf(v.Name, "w%d/%d", &w, &k); err != nil {
+			continue
+		}
+		if got[w] == nil {
+			got[w] = newModel()
+		}
+		got[w].write(v.Name, v)
+	}
+	total := fixed + 1
+	for w, m := range models {
+		total += len(m.keys)
+		if len(m.keys) == 0 {
+			assert.Nil(t, got[w], "writer %d", w)
+			continue
+		}
+		if assert.NotNil(t, got[w], "writer %d", w) {
+			assert.Equal(t, m.values(m.keys), got[w].values(got[w].keys), "writer %d", w)
+		}
+	}
+	assert.Len(t, all, total)
+}
+
+// decodeItem is Decode for goroutines, which must not call require. Items
+// that cannot be decoded come back as the zero Item, failing the checks of
+// the caller.
+func decodeItem(item any) Item {
+	if e, ok := item.(store.Encoded); ok {
+		var v Item
+		e.Decode(&v)
+		return v
+	}
+	v, _ := item.(Item)
+	return v
+}
@@ -0,0 +1,237 @@
+// Copyright 2025 Jacob Philip. All rights reserved.
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+)
+
+// ErrType is returned for items that do not have the type of a Table.
+var ErrType = errors.New("unexpected item type")
+
+// Encoded is an item read from a store that serializes items, such as the
+// SQLite store. Tables decode it into their item type.
+type Encoded interface {
+	Decode(v any) error
+}
+
+// Table is a typed view of a table of a Store. Items written by other code
+// with another type are reported as ErrType errors instead of panicking.
+type Table[K comparable, V any] struct {
+	db   Store
+	name string
+}
+
+// Entry is a key and item pair of a Table.
+type Entry[K comparable, V any] struct {
+	Key   K
+	Value V
+}
+
+// NewTable returns a typed view of the named table, creating it if needed.
+func NewTable[K comparable, V any](db Store, name string) (Table[K, V], error) {
+	if err := db.CreateTable(name); err != nil {
+		return Table[K, V]{}, err
+	}
+	return Table[K, V]{db: db, name: name}, nil
+}
+
+// Name returns the name of the table in the store.
+func (t Table[K, V]) Name() string {
+	return t.name
+}
+
+func (t Table[K, V]) cast(key any, item any) (V, error) {
+	if e, ok := item.(Encoded); ok {
+		var v V
+		if err := e.Decode(&v); err != nil {
+			return v, fmt.Errorf("%w: decoding item %v of table %s: %v", ErrType, key, t.name, err)
+		}
+		return v, nil
+	}
+	v, ok := item.(V)
+	if !ok {
+		return v, fmt.Errorf("%w: item %v of table %s is %T", ErrType, key, t.name, item)
+	}
+	return v, nil
+}
+
+// castAll casts the items of a read starting at position start. The reads
+// return no keys, so the key of an item that fails is looked up.
+func (t Table[K, V]) castAll(ctx context.Context, start int, items []any) ([]V, error) {
+	values := make([]V, 0, len(items))
+	for idx, item := range items {
+		v, err := t.cast(fmt.Sprintf("at position %d", start+idx), item)
+		if err != nil {
+			if key, ok := t.keyAt(ctx, start+idx); ok {
+				_, err = t.cast(key, item)
+			}
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// keyAt returns the key of the item at position pos, by scanning the table.
+func (t Table[K, V]) keyAt(ctx context.Context, pos int) (any, bool) {
+	items, err := t.db.ScanContext(ctx, t.name, ScanOptions{})
+	if err != nil {
+		return nil, false
+	}
+	i := 0
+	for key, item := range items {
+		if _, ok := item.(*ScanError); ok {
+			break
+		}
+		if i == pos {
+			return key, true
+		}
+		i++
+	}
+	return nil, false
+}
+
+func (t Table[K, V]) Read(key K) (V, error) {
+	return t.ReadContext(context.Background(), key)
+}
+
+// ReadContext is Read, see Store.ReadContext.
+func (t Table[K, V]) ReadContext(ctx context.Context, key K) (V, error) {
+	item, err := t.db.ReadContext(ctx, t.name, key)
+	if err != nil {
+		var zero V
+		return zero, err
+	}
+	return t.cast(key, item)
+}
+
+func (t Table[K, V]) Write(key K, value V) error {
+	return t.WriteContext(context.Background(), key, value)
+}
+
+// WriteContext is Write, see Store.WriteContext.
+func (t Table[K, V]) WriteContext(ctx context.Context, key K, value V) error {
+	return t.db.WriteContext(ctx, t.name, key, value)
+}
+
+func (t Table[K, V]) Delete(key K) error {
+	return t.DeleteContext(context.Background(), key)
+}
+
+// DeleteContext is Delete, see Store.DeleteContext.
+func (t Table[K, V]) DeleteContext(ctx context.Context, key K) error {
+	return t.db.DeleteContext(ctx, t.name, key)
+}
+
+// ReadRange returns the items in [start, end) and whether the end of the
+// table was reached, see Store.ReadRange.
+func (t Table[K, V]) ReadRange(start, end int) ([]V, bool, error) {
+	return t.ReadRangeContext(context.Background(), start, end)
+}
+
+// ReadRangeContext is ReadRange, see Store.ReadRangeContext.
+func (t Table[K, V]) ReadRangeContext(ctx context.Context, start, end int) ([]V, bool, error) {
+	items, eof, err := t.db.ReadRangeContext(ctx, t.name, start, end)
+	if err != nil {
+		return nil, false, err
+	}
+	values, err := t.castAll(ctx, max(start, 0), items)
+	return values, eof, err
+}
+
+func (t Table[K, V]) ReadAll() ([]V, error) {
+	return t.ReadAllContext(context.Background())
+}
+
+// ReadAllContext is ReadAll, see Store.ReadAllContext.
+func (t Table[K, V]) ReadAllContext(ctx context.Context) ([]V, error) {
+	items, err := t.db.ReadAllContext(ctx, t.name)
+	if err != nil {
+		return nil, err
+	}
+	return t.castAll(ctx, 0, items)
+}
+
+// Scan iterates over the entries of the table, see Store.Scan. Iteration
+// stops at the first error, including a ScanError of the store.
+func (t Table[K, V]) Scan(opts ScanOptions) iter.Seq2[Entry[K, V], error] {
+	return t.ScanContext(context.Background(), opts)
+}
+
+// ScanContext is Scan, see Store.ScanContext. Once ctx is done, the error of
+// ctx is yielded instead of the next item.
+func (t Table[K, V]) ScanContext(ctx context.Context, opts ScanOptions) iter.Seq2[Entry[K, V], error] {
+	return func(yield func(Entry[K, V], error) bool) {
+		items, err := t.db.ScanContext(ctx, t.name, opts)
+		if err != nil {
+			yield(Entry[K, V]{}, err)
+			return
+		}
+		for key, item := range items {
+			if err := ctx.Err(); err != nil {
+				yield(Entry[K, V]{}, err)
+				return
+			}
+			if serr, ok := item.(*ScanError); ok {
+				yield(Entry[K, V]{}, serr.Err)
+				return
+			}
+			k, ok := key.(K)
+			if !ok {
+				yield(Entry[K, V]{}, fmt.Errorf("%w: key %v of table %s is %T", ErrType, key, t.name, key))
+				return
+			}
+			v, err := t.cast(key, item)
+			if err != nil {
+				yield(Entry[K, V]{}, err)
+				return
+			}
+			if !yield(Entry[K, V]{Key: k, Value: v}, nil) {
+				return
+			}
+		}
+	}
+}
+
+// Batch runs fn with exclusive access to the table, see Store.Batch.
+func (t Table[K, V]) Batch(fn func(tx TableTx[K, V]) error) error {
+	return t.BatchContext(context.Background(), fn)
+}
+
+// BatchContext is Batch, see Store.BatchContext.
+func (t Table[K, V]) BatchContext(ctx context.Context, fn func(tx TableTx[K, V]) error) error {
+	return t.db.BatchContext(ctx, t.name, func(tx Tx) error {
+		return fn(TableTx[K, V]{tx: tx, table: t})
+	})
+}
+
+// TableTx is a typed view of the Tx of a Table batch.
+type TableTx[K comparable, V any] struct {
+	tx    Tx
+	table Table[K, V]
+}
+
+func (tx TableTx[K, V]) Read(key K) (V, error) {
+	item, err := tx.tx.Read(key)
+	if err != nil {
+		var zero V
+		return zero, err
+	}
+	return tx.table.cast(key, item)
+}
+
+func (tx TableTx[K, V]) Write(key K, value V) error {
+	return tx.tx.Write(key, value)
+}
+
+func (tx TableTx[K, V]) Delete(key K) error {
+	return tx.tx.Delete(key)
+}
+
+// Len returns the number of items in the table, including the changes of the batch.
+func (tx TableTx[K, V]) Len() int {
+	return tx.tx.Len()
+}
@@ -0,0 +1,160 @@
+// Copyright 2025 Jacob Philip. All rights reserved.
+package store
+
+import (
+	"context"
+	"iter"
+	"time"
+)
+
+// Span describes a finished store operation.
+type Span struct {
+	// Op is the Store method without the Context suffix, such as "Read"
+	Op    string
+	Table string
+	Start time.Time
+	// Duration includes waiting for the table lock. For Scan it lasts until
+	// the iteration ends.
+	Duration time.Duration
+	// Err is the error of the operation. For a Scan it is the error of its
+	// ScanError, or of ctx if the scan ended early because of it
+	Err error
+}
+
+// TraceFunc is called after every operation of a traced store with the
+// context of the operation, context.Background for the methods without one.
+type TraceFunc func(ctx context.Context, span Span)
+
+// Trace returns db with fn called after each of its operations, to log them
+// or to export them to a tracing system.
+func Trace(db Store, fn TraceFunc) Store {
+	return &traced{db: db, fn: fn}
+}
+
+type traced struct {
+	db Store
+	fn TraceFunc
+}
+
+func (t *traced) end(ctx context.Context, op, table string, start time.Time, err error) {
+	t.fn(ctx, Span{Op: op, Table: table, Start: start, Duration: time.Since(start), Err: err})
+}
+
+func (t *traced) Write(table string, key any, item any) error {
+	return t.WriteContext(context.Background(), table, key, item)
+}
+
+func (t *traced) Read(table string, id any) (any, error) {
+	return t.ReadContext(context.Background(), table, id)
+}
+
+func (t *traced) ReadRange(table string, start, end int) ([]any, bool, error) {
+	return t.ReadRangeContext(context.Background(), table, start, end)
+}
+
+func (t *traced) ReadAll(table string) ([]any, error) {
+	return t.ReadAllContext(context.Background(), table)
+}
+
+func (t *traced) Scan(table string, opts ScanOptions) (iter.Seq2[any, any], error) {
+	return t.ScanContext(context.Background(), table, opts)
+}
+
+func (t *traced) Delete(table string, id any) error {
+	return t.DeleteContext(context.Background(), table, id)
+}
+
+func (t *traced) CreateTable(name string) error {
+	return t.CreateTableContext(context.Background(), name)
+}
+
+func (t *traced) DeleteTable(name string) error {
+	return t.DeleteTableContext(context.Background(), name)
+}
+
+func (t *traced) Batch(table string, fn func(tx Tx) error) error {
+	return t.BatchContext(context.Background(), table, fn)
+}
+
+func (t *traced) WriteContext(ctx context.Context, table string, key any, item any) error {
+	start := time.Now()
+	err := t.db.WriteContext(ctx, table, key, item)
+	t.end(ctx, "Write", table, start, err)
+	return err
+}
+
+func (t *traced) ReadContext(ctx context.Context, table string, id any) (any, error) {
+	start := time.Now()
+	item, err := t.db.ReadContext(ctx, table, id)
+	t.end(ctx, "Read", table, start, err)
+	return item, err
+}
+
+func (t *traced) ReadRangeContext(ctx context.Context, table string, start, end int) ([]any, bool, error) {
+	begin := time.Now()
+	items, eof, err := t.db.ReadRangeContext(ctx, table, start, end)
+	t.end(ctx, "ReadRange", table, begin, err)
+	return items, eof, err
+}
+
+func (t *traced) ReadAllContext(ctx context.Context, table string) ([]any, error) {
+	start := time.Now()
+	items, err := t.db.ReadAllContext(ctx, table)
+	t.end(ctx, "ReadAll", table, start, err)
+	return items, err
+}
+
+func (t *traced) ScanContext(ctx context.Context, table string, opts ScanOptions) (iter.Seq2[any, any], error) {
+	start := time.Now()
+	items, err := t.db.ScanContext(ctx, table, opts)
+	if err != nil {
+		t.end(ctx, "Scan", table, start, err)
+		return nil, err
+	}
+	return func(yield func(any, any) bool) {
+		var err error
+		defer func() {
+			t.end(ctx, "Scan", table, start, err)
+		}()
+		for k, item := range items {
+			if serr, ok := item.(*ScanError); ok {
+				err = serr.Err
+			}
+			if !yield(k, item) {
+				// The caller may have stopped because ctx is done
+				if err == nil {
+					err = ctx.Err()
+				}
+				return
+			}
+		}
+	}, nil
+}
+
+func (t *traced) DeleteContext(ctx context.Context, table string, id any) error {
+	start := time.Now()
+	err := t.db.DeleteContext(ctx, table, id)
+	t.end(ctx, "Delete", table, start, err)
+	return err
+}
+
+func (t *traced) CreateTableContext(ctx context.Context, name string) error {
+	start := time.Now()
+	err := t.db.CreateTableContext(ctx, name)
+	t.end(ctx, "CreateTable", name, start, err)
+	return err
+}
+
+func (t *traced) DeleteTableContext(ctx context.Context, name string) error {
+	start := time.Now()
+	err := t.db.DeleteTableContext(ctx, name)
+	t.end(ctx, "DeleteTable", name, start, err)
+	return err
+}
+
+func (t *traced) BatchContext(ctx context.Context, table string, fn func(tx Tx) error) error {
+	start := time.Now()
+	err := t.db.BatchContext(ctx, table, fn)
+	t.end(ctx, "Batch", table, start, err)
+	return err
+}
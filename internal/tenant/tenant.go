@@ -0,0 +1,52 @@
+// Copyright 2025 Jacob Philip. All rights reserved.
+// Package tenant carries the tenant of a request through the context.
+// Tenants share one deployment, their data is kept apart by the inventory layer.
+package tenant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Default is the tenant of requests and callers without one.
+const Default = "default"
+
+// MaxLength is the maximum length of a tenant ID.
+const MaxLength = 63
+
+var ErrInvalid = errors.New("invalid tenant")
+
+// Validate checks that id is a lowercase DNS label: letters, digits and
+// dashes, starting with a letter or digit. Tenant IDs end up in table
+// names, so separators like "/" must never be accepted.
+func Validate(id string) error {
+	if id == "" || len(id) > MaxLength {
+		return fmt.Errorf("%w: length must be 1 to %d", ErrInvalid, MaxLength)
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+		case c == '-' && i > 0:
+		default:
+			return fmt.Errorf("%w: %q", ErrInvalid, id)
+		}
+	}
+	return nil
+}
+
+type tenantKey struct{}
+
+// ContextWith returns a copy of ctx scoped to the tenant.
+func ContextWith(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, id)
+}
+
+// FromContext returns the tenant of the request, Default if none is set.
+func FromContext(ctx context.Context) string {
+	if id, ok := ctx.Value(tenantKey{}).(string); ok && id != "" {
+		return id
+	}
+	return Default
+}
@@ -0,0 +1,25 @@
+// Copyright 2025 Jacob Philip. All rights reserved.
+package tenant
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate(t *testing.T) {
+	for _, id := range []string{"a", "brand-a", "0x1", strings.Repeat("a", MaxLength)} {
+		assert.NoError(t, Validate(id), id)
+	}
+	for _, id := range []string{"", "-a", "Brand", "a/b", "a_b", "a b", strings.Repeat("a", MaxLength+1)} {
+		assert.ErrorIs(t, Validate(id), ErrInvalid, id)
+	}
+}
+
+func TestContext(t *testing.T) {
+	ctx := context.Background()
+	assert.Equal(t, Default, FromContext(ctx))
+	assert.Equal(t, "brand-a", FromContext(ContextWith(ctx, "brand-a")))
+}
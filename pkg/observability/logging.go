@@ -0,0 +1,112 @@
+// Copyright 2025 Jacob Philip. All rights reserved.
+package observability
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+const (
+	LogFormatJSON = "json"
+	LogFormatText = "text"
+)
+
+// LogConfig holds the logging settings of the service.
+type LogConfig struct {
+	Level  slog.Level
+	Format string
+}
+
+// LogConfigFromEnv reads LOG_LEVEL (debug, info, warn, error) and
+// LOG_FORMAT (json, text) from the environment.
+// Unknown or missing values fall back to info level and JSON format.
+func LogConfigFromEnv() LogConfig {
+	cfg := LogConfig{
+		Level:  slog.LevelInfo,
+		Format: LogFormatJSON,
+	}
+	if lvl := os.Getenv("LOG_LEVEL"); lvl != "" {
+		var l slog.Level
+		if err := l.UnmarshalText([]byte(lvl)); err == nil {
+			cfg.Level = l
+		}
+	}
+	if strings.EqualFold(os.Getenv("LOG_FORMAT"), LogFormatText) {
+		cfg.Format = LogFormatText
+	}
+	return cfg
+}
+
+// NewLogger returns a slog logger writing to w in the configured format.
+// The handler is wrapped with ContextHandler, so request IDs are added to
+// every slog.*Context call.
+func NewLogger(cfg LogConfig, w io.Writer) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: cfg.Level}
+	var h slog.Handler
+	if cfg.Format == LogFormatText {
+		h = slog.NewTextHandler(w, opts)
+	} else {
+		h = slog.NewJSONHandler(w, opts)
+	}
+	return slog.New(NewContextHandler(h))
+}
+
+// ContextHandler is a slog.Handler that adds correlation values stored in the
+// context (currently the request ID) to each record.
+// Only the *Context variants of slog calls carry the context, plain calls are
+// logged as is.
+type ContextHandler struct {
+	slog.Handler
+}
+
+func NewContextHandler(h slog.Handler) *ContextHandler {
+	return &ContextHandler{Handler: h}
+}
+
+func (h *ContextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *ContextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &ContextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *ContextHandler) WithGroup(name string) slog.Handler {
+	return &ContextHandler{Handler: h.Handler.WithGroup(name)}
+}
+
+type loggerKey struct{}
+type requestIDKey struct{}
+
+// ContextWithRequestID stores the request ID in the context.
+// Records created while serving the request (logs, audit entries etc.) should
+// read it back with RequestID.
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID stored in the context, or an empty string.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// ContextWithLogger attaches a request scoped logger to the context.
+func ContextWithLogger(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// Logger returns the logger attached to the context.
+// Falls back to the default slog logger, so it is always safe to use.
+func Logger(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok && l != nil {
+		return l
+	}
+	return slog.Default()
+}
@@ -0,0 +1,229 @@
+// Copyright 2025 Jacob Philip. All rights reserved.
+package ratelimiter
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// AdaptiveLimiter limits the number of requests in flight instead of the
+// request rate. The limit is not configured up front: it is adjusted from the
+// latency of completed requests, in the spirit of Netflix's concurrency-limits.
+// When the service slows down the limit shrinks, and requests beyond it
+// should be shed right away instead of queueing up.
+//
+// Acquire returns a Token that must be completed with exactly one of
+// OnSuccess, OnDropped or OnIgnore once the request is done.
+type AdaptiveLimiter struct {
+	mu        sync.Mutex
+	limit     float64
+	minLimit  int
+	maxLimit  int
+	inflight  int
+	algorithm LimitAlgorithm
+	clock     Clock
+}
+
+// LimitAlgorithm computes a new concurrency limit from a completed request.
+// Implementations keep state across samples, do not share them between limiters.
+type LimitAlgorithm interface {
+	// Update returns the new limit. rtt is the latency of the request,
+	// inflight the number of requests in flight when it started and dropped
+	// tells that it timed out or was rejected downstream.
+	Update(limit float64, rtt time.Duration, inflight int, dropped bool) float64
+}
+
+// AdaptiveOption customizes an AdaptiveLimiter.
+type AdaptiveOption func(*AdaptiveLimiter)
+
+// WithInitialLimit sets the limit used until latency samples come in. Defaults to 20.
+func WithInitialLimit(n int) AdaptiveOption {
+	return func(a *AdaptiveLimiter) {
+		if n > 0 {
+			a.limit = float64(n)
+		}
+	}
+}
+
+// WithLimitBounds keeps the limit within [min, max]. Defaults to [1, 1000].
+func WithLimitBounds(min, max int) AdaptiveOption {
+	return func(a *AdaptiveLimiter) {
+		if min > 0 && max >= min {
+			a.minLimit, a.maxLimit = min, max
+		}
+	}
+}
+
+// WithLimitAlgorithm selects how the limit is adjusted. Defaults to NewGradient().
+func WithLimitAlgorithm(l LimitAlgorithm) AdaptiveOption {
+	return func(a *AdaptiveLimiter) {
+		if l != nil {
+			a.algorithm = l
+		}
+	}
+}
+
+// NewAdaptiveLimiter creates an adaptive concurrency limiter.
+func NewAdaptiveLimiter(opts ...AdaptiveOption) *AdaptiveLimiter {
+	a := &AdaptiveLimiter{
+		limit:    20,
+		minLimit: 1,
+		maxLimit: 1000,
+		clock:    realClock{},
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	if a.algorithm == nil {
+		a.algorithm = NewGradient()
+	}
+	a.limit = a.clamp(a.limit)
+	return a
+}
+
+// Token is a request admitted by AdaptiveLimiter.Acquire.
+type Token struct {
+	a        *AdaptiveLimiter
+	start    time.Time
+	inflight int
+	done     bool
+}
+
+// Acquire admits a request if fewer than Limit requests are in flight.
+// It never blocks. The token is nil when the limiter is saturated.
+func (a *AdaptiveLimiter) Acquire() (*Token, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.inflight >= int(a.limit) {
+		return nil, false
+	}
+	a.inflight++
+	return &Token{a: a, start: a.clock.Now(), inflight: a.inflight}, true
+}
+
+// OnSuccess completes the request and feeds its latency to the limit algorithm.
+func (t *Token) OnSuccess() {
+	t.release(true, false)
+}
+
+// OnDropped completes a request that timed out or was rejected because of load.
+// The limit backs off.
+func (t *Token) OnDropped() {
+	t.release(true, true)
+}
+
+// OnIgnore completes the request without a sample, e.g. for a request that
+// failed validation and says nothing about the load.
+func (t *Token) OnIgnore() {
+	t.release(false, false)
+}
+
+func (t *Token) release(sample, dropped bool) {
+	a := t.a
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if t.done {
+		return
+	}
+	t.done = true
+	a.inflight--
+	if sample {
+		rtt := a.clock.Now().Sub(t.start)
+		a.limit = a.clamp(a.algorithm.Update(a.limit, rtt, t.inflight, dropped))
+	}
+}
+
+func (a *AdaptiveLimiter) clamp(limit float64) float64 {
+	return math.Min(math.Max(limit, float64(a.minLimit)), float64(a.maxLimit))
+}
+
+// Limit returns the current concurrency limit.
+func (a *AdaptiveLimiter) Limit() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return int(a.limit)
+}
+
+// InFlight returns the number of admitted requests that are not completed yet.
+func (a *AdaptiveLimiter) InFlight() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.inflight
+}
+
+// AIMD grows the limit by one for every successful request while the limiter
+// is well utilized, and multiplies it by BackoffRatio when a request is
+// dropped or slower than Timeout.
+type AIMD struct {
+	BackoffRatio float64
+	Timeout      time.Duration
+}
+
+// NewAIMD returns AIMD with a backoff ratio of 0.9 and a 5 second timeout.
+func NewAIMD() *AIMD {
+	return &AIMD{BackoffRatio: 0.9, Timeout: 5 * time.Second}
+}
+
+func (l *AIMD) Update(limit float64, rtt time.Duration, inflight int, dropped bool) float64 {
+	if dropped || rtt > l.Timeout {
+		return limit * l.BackoffRatio
+	}
+	// A limit that is not used cannot be validated, so do not grow it
+	if float64(inflight)*2 >= limit {
+		return limit + 1
+	}
+	return limit
+}
+
+// Gradient compares the latency of each request with the long term average.
+// While latency is stable the limit grows by about its square root, which
+// allows a small queue. When latency rises beyond Tolerance times the average,
+// the limit shrinks in proportion, by at most half per sample. Smoothing
+// dampens the changes.
+type Gradient struct {
+	Tolerance float64
+	Smoothing float64
+	// Number of samples the long term average covers
+	Window int
+
+	longRtt float64
+	samples int
+}
+
+// NewGradient returns a Gradient with a tolerance of 1.5, smoothing of 0.2
+// and a window of 100 samples.
+func NewGradient() *Gradient {
+	return &Gradient{Tolerance: 1.5, Smoothing: 0.2, Window: 100}
+}
+
+func (l *Gradient) Update(limit float64, rtt time.Duration, inflight int, dropped bool) float64 {
+	short := float64(rtt)
+	if dropped {
+		// Treat a drop as a request that took twice the tolerated latency
+		short = math.Max(short, 2*l.Tolerance*l.longRtt)
+	}
+	if short <= 0 {
+		return limit
+	}
+
+	// Warm up with a plain average, then move to an exponential one
+	l.samples++
+	if l.samples <= l.Window {
+		l.longRtt += (short - l.longRtt) / float64(l.samples)
+	} else {
+		l.longRtt += (short - l.longRtt) * 2 / float64(l.Window+1)
+	}
+	// After a lasting drop in latency, let the average catch up faster
+	if l.longRtt/short > 2 {
+		l.longRtt *= 0.95
+	}
+
+	// Little in flight says nothing about how far the limit can go
+	if !dropped && float64(inflight) < limit/2 {
+		return limit
+	}
+	gradient := math.Max(0.5, math.Min(1, l.Tolerance*l.longRtt/short))
+	next := limit*gradient + math.Sqrt(limit)
+	return limit*(1-l.Smoothing) + next*l.Smoothing
+}
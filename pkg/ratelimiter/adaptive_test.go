@@ -0,0 +1,102 @@
+// Copyright 2025 Jacob Philip. All rights reserved.
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestAdaptive(clock *fakeClock, opts ...AdaptiveOption) *AdaptiveLimiter {
+	a := NewAdaptiveLimiter(opts...)
+	a.clock = clock
+	return a
+}
+
+func TestAdaptiveLimiterShedsBeyondLimit(t *testing.T) {
+	clock := newFakeClock()
+	a := newTestAdaptive(clock, WithInitialLimit(2), WithLimitAlgorithm(NewAIMD()))
+
+	t1, ok := a.Acquire()
+	assert.True(t, ok)
+	t2, ok := a.Acquire()
+	assert.True(t, ok)
+	_, ok = a.Acquire()
+	assert.False(t, ok, "saturated")
+	assert.Equal(t, 2, a.InFlight())
+
+	// Completing twice does not release twice
+	t1.OnIgnore()
+	t1.OnIgnore()
+	assert.Equal(t, 1, a.InFlight())
+	assert.Equal(t, 2, a.Limit(), "ignored requests do not change the limit")
+
+	clock.Advance(10 * time.Millisecond)
+	t2.OnSuccess()
+	assert.Equal(t, 0, a.InFlight())
+	assert.Equal(t, 3, a.Limit(), "fully utilized, so the limit grows")
+}
+
+func TestAIMD(t *testing.T) {
+	clock := newFakeClock()
+	a := newTestAdaptive(clock, WithInitialLimit(10), WithLimitBounds(5, 12), WithLimitAlgorithm(NewAIMD()))
+
+	// Additive increase while the limit is used, up to the upper bound
+	for i := 0; i < 5; i++ {
+		var tokens []*Token
+		for a.InFlight() < a.Limit() {
+			tok, _ := a.Acquire()
+			tokens = append(tokens, tok)
+		}
+		for _, tok := range tokens {
+			tok.OnSuccess()
+		}
+	}
+	assert.Equal(t, 12, a.Limit())
+
+	// Requests in flight alone do not grow it
+	tok, _ := a.Acquire()
+	tok.OnSuccess()
+	assert.Equal(t, 12, a.Limit())
+
+	// Multiplicative decrease on drops and timeouts, down to the lower bound
+	tok, _ = a.Acquire()
+	tok.OnDropped()
+	assert.Equal(t, 10, a.Limit())
+	tok, _ = a.Acquire()
+	clock.Advance(6 * time.Second)
+	tok.OnSuccess()
+	assert.Equal(t, 9, a.Limit())
+	for i := 0; i < 20; i++ {
+		tok, _ = a.Acquire()
+		tok.OnDropped()
+	}
+	assert.Equal(t, 5, a.Limit())
+}
+
+func TestGradient(t *testing.T) {
+	g := NewGradient()
+	limit := 20.0
+
+	// Stable latency with the limit in use: the limit grows
+	for i := 0; i < 50; i++ {
+		limit = g.Update(limit, 10*time.Millisecond, int(limit), false)
+	}
+	assert.Greater(t, limit, 40.0)
+	grown := limit
+
+	// Mostly idle: no change
+	assert.Equal(t, grown, g.Update(grown, 10*time.Millisecond, 1, false))
+
+	// Latency degrades: the limit shrinks
+	for i := 0; i < 20; i++ {
+		limit = g.Update(limit, 100*time.Millisecond, int(limit), false)
+	}
+	assert.Less(t, limit, grown/2)
+
+	// Drops shrink it even if latency looks fine
+	before := limit
+	limit = g.Update(limit, time.Millisecond, int(limit), true)
+	assert.Less(t, limit, before)
+}
@@ -0,0 +1,117 @@
+// Copyright 2025 Jacob Philip. All rights reserved.
+package ratelimiter
+
+import (
+	"sync"
+	"time"
+)
+
+// fakeClock only moves when Advance is called.
+// Timers fire synchronously in time order: Advance returns once run has
+// received every due timer, so state is settled before the next request.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock   *fakeClock
+	c       chan time.Time
+	at      time.Time
+	stopped chan struct{}
+	fired   bool
+	halted  bool
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(0, 0)}
+}
+
+func (f *fakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *fakeClock) NewTimer(d time.Duration) Timer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t := &fakeTimer{
+		clock:   f,
+		c:       make(chan time.Time),
+		at:      f.now.Add(d),
+		stopped: make(chan struct{}),
+	}
+	f.timers = append(f.timers, t)
+	return t
+}
+
+// Advance moves the clock forward by d, firing due timers on the way.
+// Timers created while firing are honored if they are due before the target.
+func (f *fakeClock) Advance(d time.Duration) {
+	f.mu.Lock()
+	target := f.now.Add(d)
+	for {
+		var due *fakeTimer
+		for _, t := range f.timers {
+			if t.at.After(target) {
+				continue
+			}
+			if due == nil || t.at.Before(due.at) {
+				due = t
+			}
+		}
+		if due == nil {
+			break
+		}
+		f.removeLocked(due)
+		due.fired = true
+		if due.at.After(f.now) {
+			f.now = due.at
+		}
+		now := f.now
+		f.mu.Unlock()
+		// run may stop the timer instead of receiving from it
+		select {
+		case due.c <- now:
+		case <-due.stopped:
+		}
+		f.mu.Lock()
+	}
+	f.now = target
+	f.mu.Unlock()
+}
+
+// Pending returns the number of armed timers.
+func (f *fakeClock) Pending() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.timers)
+}
+
+func (f *fakeClock) removeLocked(t *fakeTimer) {
+	for i, other := range f.timers {
+		if other == t {
+			f.timers = append(f.timers[:i], f.timers[i+1:]...)
+			return
+		}
+	}
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+// Stop also releases an Advance that is blocked firing this timer.
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	if t.halted {
+		return false
+	}
+	t.halted = true
+	t.clock.removeLocked(t)
+	close(t.stopped)
+	return !t.fired
+}
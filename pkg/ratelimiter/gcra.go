@@ -0,0 +1,68 @@
+// Copyright 2025 Jacob Philip. All rights reserved.
+package ratelimiter
+
+import (
+	"sync"
+	"time"
+)
+
+// GCRA implements the Generic Cell Rate Algorithm.
+// It behaves like a token bucket but keeps a single timestamp, the theoretical
+// arrival time (TAT) of the next request, instead of a token count.
+// A request is allowed if it does not arrive earlier than TAT minus the burst tolerance.
+type GCRA struct {
+	mu sync.Mutex
+	// time between two requests at the configured rate
+	interval time.Duration
+	burst    int
+	clock    Clock
+	tat      time.Time
+}
+
+// NewGCRA allows rate requests per second and up to burst at once.
+func NewGCRA(rate int, opts ...Option) *GCRA {
+	cfg := newOptions(rate, opts)
+	return &GCRA{
+		interval: time.Second / time.Duration(cfg.rate),
+		burst:    cfg.burst,
+		clock:    cfg.clock,
+		tat:      cfg.clock.Now(),
+	}
+}
+
+// Take implements Limiter.
+func (g *GCRA) Take(n int) Decision {
+	n = max(n, 1)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.clock.Now()
+
+	tat := g.tat
+	if tat.Before(now) {
+		tat = now
+	}
+	// A full burst is allowed as long as TAT is at most burst intervals ahead
+	capacity := time.Duration(g.burst) * g.interval
+	newTat := tat.Add(time.Duration(n) * g.interval)
+	allowAt := newTat.Add(-capacity)
+
+	d := Decision{}
+	switch {
+	case n > g.burst:
+	case allowAt.After(now):
+		d.RetryAfter = allowAt.Sub(now)
+	default:
+		g.tat = newTat
+		tat = newTat
+		d.Allowed = true
+	}
+	d.Status = Status{
+		Limit:     g.burst,
+		Remaining: int((capacity - tat.Sub(now)) / g.interval),
+		Reset:     tat.Sub(now),
+	}
+	return d
+}
+
+// Shutdown implements Limiter. There is nothing to release.
+func (g *GCRA) Shutdown() {}
@@ -0,0 +1,123 @@
+// Copyright 2025 Jacob Philip. All rights reserved.
+package ratelimiter
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// Limiters replaced by Update are shut down once they were not handed out
+// for this long, callers that got them just before are done by then.
+const retireAfter = time.Minute
+
+// KeyedLimiter keeps a separate rate limiter per key, for example per client.
+// Limiters that were not used for idleTimeout are shut down and dropped.
+// Eviction is done lazily on Get, so there is no background goroutine and
+// nothing wakes up while the server is idle.
+type KeyedLimiter struct {
+	mu          sync.Mutex
+	limiters    map[string]*keyedEntry
+	newLimiter  func() Limiter
+	idleTimeout time.Duration
+	clock       Clock
+	lastSweep   time.Time
+	// replaced by Update, not yet shut down
+	retired []*keyedEntry
+}
+
+type keyedEntry struct {
+	limiter  Limiter
+	lastSeen time.Time
+}
+
+// NewKeyedLimiter creates limiters on demand with newLimiter.
+func NewKeyedLimiter(newLimiter func() Limiter, idleTimeout time.Duration) *KeyedLimiter {
+	return &KeyedLimiter{
+		limiters:    make(map[string]*keyedEntry),
+		newLimiter:  newLimiter,
+		idleTimeout: idleTimeout,
+		clock:       realClock{},
+	}
+}
+
+// Get returns the limiter for key, creating it if needed.
+func (k *KeyedLimiter) Get(key string) Limiter {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	now := k.clock.Now()
+	if k.idleTimeout > 0 && now.Sub(k.lastSweep) >= k.idleTimeout {
+		k.sweep(now)
+	}
+	k.reap(now)
+
+	e, ok := k.limiters[key]
+	if !ok {
+		e = &keyedEntry{limiter: k.newLimiter()}
+		k.limiters[key] = e
+	}
+	e.lastSeen = now
+	return e.limiter
+}
+
+// sweep evicts idle limiters. A limiter idle for longer than the timeout is
+// back at full capacity anyway (for sane timeouts), so evicting it does not
+// reset anyone's limit.
+func (k *KeyedLimiter) sweep(now time.Time) {
+	for key, e := range k.limiters {
+		if now.Sub(e.lastSeen) >= k.idleTimeout {
+			e.limiter.Shutdown()
+			delete(k.limiters, key)
+		}
+	}
+	k.lastSweep = now
+}
+
+// reap shuts down the retired limiters that were not handed out for
+// retireAfter. Shutting them down right away would reject the requests of
+// callers still holding them.
+func (k *KeyedLimiter) reap(now time.Time) {
+	k.retired = slices.DeleteFunc(k.retired, func(e *keyedEntry) bool {
+		if now.Sub(e.lastSeen) < retireAfter {
+			return false
+		}
+		e.limiter.Shutdown()
+		return true
+	})
+}
+
+// Update replaces the function creating new limiters and calls tune for every
+// live limiter. Limiters for which tune returns false are recreated on their
+// next Get, and shut down once callers are done with them.
+func (k *KeyedLimiter) Update(newLimiter func() Limiter, tune func(Limiter) bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.newLimiter = newLimiter
+	for key, e := range k.limiters {
+		if !tune(e.limiter) {
+			k.retired = append(k.retired, e)
+			delete(k.limiters, key)
+		}
+	}
+}
+
+// Len returns the number of live limiters.
+func (k *KeyedLimiter) Len() int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return len(k.limiters)
+}
+
+// Shutdown stops all limiters.
+func (k *KeyedLimiter) Shutdown() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for key, e := range k.limiters {
+		e.limiter.Shutdown()
+		delete(k.limiters, key)
+	}
+	for _, e := range k.retired {
+		e.limiter.Shutdown()
+	}
+	k.retired = nil
+}
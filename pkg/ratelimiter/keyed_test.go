@@ -0,0 +1,77 @@
+// Copyright 2025 Jacob Philip. All rights reserved.
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyedLimiter(t *testing.T) {
+	clock := newFakeClock()
+	k := NewKeyedLimiter(func() Limiter {
+		return NewRateLimiter(1, WithClock(clock))
+	}, time.Minute)
+	k.clock = clock
+	defer k.Shutdown()
+
+	// Keys have independent buckets
+	assert.True(t, k.Get("a").Take(1).Allowed)
+	assert.False(t, k.Get("a").Take(1).Allowed)
+	assert.True(t, k.Get("b").Take(1).Allowed)
+	assert.Equal(t, 2, k.Len())
+
+	clock.Advance(30 * time.Second)
+	k.Get("b")
+	// a is idle for a minute, b is not
+	clock.Advance(30 * time.Second)
+	old := k.Get("b")
+	assert.Equal(t, 1, k.Len())
+	assert.Same(t, old, k.Get("b"))
+
+	// Evicted limiter is shut down, a new one starts with a full bucket
+	assert.True(t, k.Get("a").Take(1).Allowed)
+	assert.Equal(t, 2, k.Len())
+}
+
+func TestKeyedLimiterUpdate(t *testing.T) {
+	clock := newFakeClock()
+	k := NewKeyedLimiter(func() Limiter {
+		return NewRateLimiter(1, WithClock(clock))
+	}, time.Minute)
+	k.clock = clock
+	defer k.Shutdown()
+
+	a := k.Get("a")
+	assert.True(t, a.Take(1).Allowed)
+	assert.False(t, a.Take(1).Allowed)
+
+	// Tunable limiters are kept and changed in place
+	k.Update(func() Limiter {
+		return NewRateLimiter(2, WithClock(clock))
+	}, func(l Limiter) bool {
+		t, ok := l.(Tunable)
+		if ok {
+			t.SetBurst(2)
+		}
+		return ok
+	})
+	assert.Same(t, a, k.Get("a"))
+	clock.Advance(2 * time.Second)
+	assert.True(t, a.Take(2).Allowed)
+
+	// Others are replaced, new ones come from the new function
+	k.Update(func() Limiter {
+		return NewGCRA(1, WithClock(clock))
+	}, func(Limiter) bool { return false })
+	assert.Equal(t, 0, k.Len())
+	assert.IsType(t, &GCRA{}, k.Get("a"))
+	// Replaced limiters keep working for callers that still hold them, until
+	// they are retired
+	clock.Advance(time.Second)
+	assert.True(t, a.Take(1).Allowed)
+	clock.Advance(retireAfter)
+	k.Get("a")
+	assert.False(t, a.Take(1).Allowed, "shut down")
+}
@@ -0,0 +1,158 @@
+// Copyright 2025 Jacob Philip. All rights reserved.
+package ratelimiter
+
+import (
+	"fmt"
+	"time"
+)
+
+// Limiter is a non blocking rate limiter.
+// All algorithms of this package implement it, so callers such as the HTTP
+// middleware can pick one through configuration.
+type Limiter interface {
+	// Take tries to take n units right away. It never blocks.
+	Take(n int) Decision
+	// Shutdown releases the resources of the limiter.
+	Shutdown()
+}
+
+// Tunable is implemented by limiters whose rate and burst can change at runtime.
+// Limiters that are not tunable have to be replaced to change their limits.
+type Tunable interface {
+	SetRate(rate int)
+	SetBurst(burst int)
+}
+
+// Decision is the outcome of Limiter.Take.
+type Decision struct {
+	Allowed bool
+	// RetryAfter is the time until the request would be allowed, zero when allowed
+	RetryAfter time.Duration
+	Status
+}
+
+// Status describes the limiter right after a request was decided.
+// It carries what HTTP rate limit headers need.
+type Status struct {
+	// Limit is the largest number of units that can be taken at once
+	Limit int
+	// Remaining is the number of units that can still be taken right now
+	Remaining int
+	// Reset is the time until the limiter is back to its full capacity
+	Reset time.Duration
+}
+
+type Algorithm string
+
+const (
+	AlgorithmTokenBucket          Algorithm = "token_bucket"
+	AlgorithmSlidingWindowLog     Algorithm = "sliding_window_log"
+	AlgorithmSlidingWindowCounter Algorithm = "sliding_window_counter"
+	AlgorithmGCRA                 Algorithm = "gcra"
+)
+
+// ParseAlgorithm validates an algorithm name. Empty means token bucket.
+func ParseAlgorithm(s string) (Algorithm, error) {
+	switch a := Algorithm(s); a {
+	case "":
+		return AlgorithmTokenBucket, nil
+	case AlgorithmTokenBucket, AlgorithmSlidingWindowLog, AlgorithmSlidingWindowCounter, AlgorithmGCRA:
+		return a, nil
+	}
+	return "", fmt.Errorf("ratelimiter: unknown algorithm %q", s)
+}
+
+// NewLimiter creates a limiter allowing rate units per second with the given algorithm.
+// WithBurst has the same meaning for all algorithms: at most burst units are
+// allowed at once, and the long run rate is rate per second. Window based
+// algorithms use a window of burst/rate seconds.
+func NewLimiter(a Algorithm, rate int, opts ...Option) (Limiter, error) {
+	switch a {
+	case AlgorithmTokenBucket, "":
+		return NewRateLimiter(rate, opts...), nil
+	case AlgorithmSlidingWindowLog:
+		return NewSlidingWindowLog(rate, opts...), nil
+	case AlgorithmSlidingWindowCounter:
+		return NewSlidingWindowCounter(rate, opts...), nil
+	case AlgorithmGCRA:
+		return NewGCRA(rate, opts...), nil
+	}
+	return nil, fmt.Errorf("ratelimiter: unknown algorithm %q", a)
+}
+
+// Option customizes a limiter. Options that do not apply to an algorithm are ignored.
+type Option func(*options)
+
+type options struct {
+	rate      int
+	burst     int
+	queueSize int
+	// queued requests per key, 0 for no limit beyond queueSize
+	keyQueueSize int
+	clock        Clock
+}
+
+func newOptions(rate int, opts []Option) options {
+	if rate <= 0 {
+		rate = 1
+	}
+	if rate > maxOpsPerSec {
+		rate = maxOpsPerSec
+	}
+	o := options{
+		rate:      rate,
+		burst:     rate,
+		queueSize: maxQueueSize,
+		clock:     realClock{},
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// window is the period in which window based algorithms allow burst units.
+func (o options) window() time.Duration {
+	return time.Duration(o.burst) * time.Second / time.Duration(o.rate)
+}
+
+// WithQueueSize limits the number of requests waiting for a token.
+// Requests beyond that fail with ErrQueueFull. Defaults to 10000.
+// Only the token bucket queues requests.
+func WithQueueSize(n int) Option {
+	return func(o *options) {
+		if n > 0 {
+			o.queueSize = n
+		}
+	}
+}
+
+// WithKeyQueueSize limits the number of requests a single key can have
+// waiting for a token, so that one client cannot fill the whole queue.
+// Requests beyond that fail with ErrQueueFull. Only the token bucket queues requests.
+func WithKeyQueueSize(n int) Option {
+	return func(o *options) {
+		if n > 0 {
+			o.keyQueueSize = n
+		}
+	}
+}
+
+// WithBurst sets the bucket size independently of the rate.
+// Defaults to the rate, i.e. one second worth of tokens.
+func WithBurst(b int) Option {
+	return func(o *options) {
+		if b > 0 {
+			o.burst = b
+		}
+	}
+}
+
+// WithClock replaces the wall clock. Intended for tests.
+func WithClock(c Clock) Option {
+	return func(o *options) {
+		if c != nil {
+			o.clock = c
+		}
+	}
+}
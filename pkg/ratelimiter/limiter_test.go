@@ -0,0 +1,110 @@
+// Copyright 2025 Jacob Philip. All rights reserved.
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Comparison suite: every algorithm must honor the same rate and burst contract.
+var algorithms = []Algorithm{
+	AlgorithmTokenBucket,
+	AlgorithmSlidingWindowLog,
+	AlgorithmSlidingWindowCounter,
+	AlgorithmGCRA,
+}
+
+const (
+	testRate  = 10
+	testBurst = 5
+)
+
+func newTestLimiter(t *testing.T, a Algorithm, clock *fakeClock) Limiter {
+	l, err := NewLimiter(a, testRate, WithBurst(testBurst), WithClock(clock))
+	assert.NoError(t, err)
+	t.Cleanup(l.Shutdown)
+	return l
+}
+
+func TestLimiterBurst(t *testing.T) {
+	for _, a := range algorithms {
+		t.Run(string(a), func(t *testing.T) {
+			clock := newFakeClock()
+			l := newTestLimiter(t, a, clock)
+
+			for i := 0; i < testBurst; i++ {
+				d := l.Take(1)
+				assert.True(t, d.Allowed, "request %d of the burst", i)
+				assert.Equal(t, testBurst, d.Limit)
+				assert.Equal(t, testBurst-i-1, d.Remaining)
+			}
+			d := l.Take(1)
+			assert.False(t, d.Allowed)
+			assert.Greater(t, d.RetryAfter, time.Duration(0))
+			assert.Greater(t, d.Reset, time.Duration(0))
+
+			// Retrying exactly after RetryAfter succeeds, a moment earlier does not
+			clock.Advance(d.RetryAfter - time.Nanosecond)
+			assert.False(t, l.Take(1).Allowed)
+			clock.Advance(time.Nanosecond)
+			assert.True(t, l.Take(1).Allowed)
+
+			assert.False(t, l.Take(testBurst+1).Allowed, "more than the burst is never allowed")
+
+			// After a long idle period the full burst is available again
+			clock.Advance(time.Minute)
+			assert.True(t, l.Take(testBurst).Allowed)
+		})
+	}
+}
+
+func TestLimiterSustainedRate(t *testing.T) {
+	for _, a := range algorithms {
+		t.Run(string(a), func(t *testing.T) {
+			clock := newFakeClock()
+			l := newTestLimiter(t, a, clock)
+
+			// A client hammering every 10ms for 10 seconds gets the burst plus
+			// the rate. The window counter is conservative under constant load:
+			// the fading previous window leaves room for burst-1 per window.
+			allowed := 0
+			for i := 0; i < 1000; i++ {
+				if l.Take(1).Allowed {
+					allowed++
+				}
+				clock.Advance(10 * time.Millisecond)
+			}
+			assert.LessOrEqual(t, allowed, 10*testRate+testBurst)
+			assert.GreaterOrEqual(t, allowed, 10*testRate*(testBurst-1)/testBurst)
+		})
+	}
+}
+
+func TestLimiterFairness(t *testing.T) {
+	for _, a := range algorithms {
+		t.Run(string(a), func(t *testing.T) {
+			clock := newFakeClock()
+			k := NewKeyedLimiter(func() Limiter {
+				l, _ := NewLimiter(a, testRate, WithBurst(testBurst), WithClock(clock))
+				return l
+			}, time.Minute)
+			k.clock = clock
+			defer k.Shutdown()
+
+			// A noisy client must not eat into the share of a quiet one
+			quiet := 0
+			for i := 0; i < 100; i++ {
+				for j := 0; j < 10; j++ {
+					k.Get("noisy").Take(1)
+				}
+				if i%20 == 0 && k.Get("quiet").Take(1).Allowed {
+					quiet++
+				}
+				clock.Advance(10 * time.Millisecond)
+			}
+			assert.Equal(t, 5, quiet)
+		})
+	}
+}
@@ -0,0 +1,234 @@
+// Copyright 2025 Jacob Philip. All rights reserved.
+package ratelimiter
+
+import (
+	"container/heap"
+	"fmt"
+)
+
+// Priority classes of queued requests. Higher classes are always served first.
+// The zero value is PriorityNormal.
+type Priority int
+
+const (
+	// PriorityLow is meant for background work such as bulk imports
+	PriorityLow Priority = iota - 1
+	PriorityNormal
+	// PriorityHigh is meant for interactive or admin traffic
+	PriorityHigh
+
+	numPriorities = int(PriorityHigh-PriorityLow) + 1
+)
+
+// Priorities lists all classes, lowest first.
+var Priorities = []Priority{PriorityLow, PriorityNormal, PriorityHigh}
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityLow:
+		return "low"
+	case PriorityNormal:
+		return "normal"
+	case PriorityHigh:
+		return "high"
+	}
+	return fmt.Sprintf("priority(%d)", int(p))
+}
+
+func (p Priority) valid() bool {
+	return p >= PriorityLow && p <= PriorityHigh
+}
+
+// class returns the index into waitQueue.classes.
+func (p Priority) class() int {
+	return int(p - PriorityLow)
+}
+
+// waitQueue holds the waiters of a RateLimiter. It is owned by run, no locking.
+//
+// Waiters are grouped by priority class and classes are served strictly in order.
+// Within a class, weighted fair queuing across keys decides the order: every
+// waiter gets a virtual finish time of
+//
+//	max(class virtual time, finish time of the previous waiter of the same key) + n/weight
+//
+// and the smallest finish time is served first. A key that queues many requests
+// pushes its own finish times out, so it cannot starve keys with few requests.
+type waitQueue struct {
+	classes [numPriorities]classQueue
+	// queued waiters and tokens over all classes
+	len    int
+	tokens int
+	// queued waiters per key over all classes
+	perKey map[string]int
+}
+
+type classQueue struct {
+	waiters waiterHeap
+	tokens  int
+	// finish time of the last served waiter
+	virtual    float64
+	lastFinish map[string]float64
+}
+
+func newWaitQueue() waitQueue {
+	q := waitQueue{perKey: make(map[string]int)}
+	for i := range q.classes {
+		q.classes[i].lastFinish = make(map[string]float64)
+	}
+	return q
+}
+
+// tag assigns the virtual finish time, without changing the queue.
+func (q *waitQueue) tag(w *waiter) {
+	c := &q.classes[w.priority.class()]
+	start := max(c.virtual, c.lastFinish[w.key])
+	w.finish = start + float64(w.n)/float64(w.weight)
+}
+
+// ahead returns the tokens requested by queued waiters that are served before w.
+func (q *waitQueue) ahead(w *waiter) int {
+	tokens := 0
+	for p := numPriorities - 1; p > w.priority.class(); p-- {
+		tokens += q.classes[p].tokens
+	}
+	for _, other := range q.classes[w.priority.class()].waiters {
+		if other != w && other.before(w) {
+			tokens += other.n
+		}
+	}
+	return tokens
+}
+
+// grant records the service given to a tagged waiter that is served without
+// queueing. Nobody queued in its class is served before it, including earlier
+// waiters of the same key, so the class virtual time moves on as if it was
+// popped. A key using the limiter without contention is not penalized later on.
+func (q *waitQueue) grant(w *waiter) {
+	c := &q.classes[w.priority.class()]
+	c.virtual = max(c.virtual, w.finish)
+	delete(c.lastFinish, w.key)
+}
+
+func (q *waitQueue) push(w *waiter) {
+	c := &q.classes[w.priority.class()]
+	c.lastFinish[w.key] = w.finish
+	heap.Push(&c.waiters, w)
+	c.tokens += w.n
+	q.len++
+	q.tokens += w.n
+	q.perKey[w.key]++
+}
+
+// peek returns the next waiter to serve, or nil.
+func (q *waitQueue) peek() *waiter {
+	for p := numPriorities - 1; p >= 0; p-- {
+		if len(q.classes[p].waiters) > 0 {
+			return q.classes[p].waiters[0]
+		}
+	}
+	return nil
+}
+
+// pop removes the waiter returned by peek and advances the class virtual time.
+func (q *waitQueue) pop() *waiter {
+	w := q.peek()
+	if w == nil {
+		return nil
+	}
+	c := &q.classes[w.priority.class()]
+	heap.Pop(&c.waiters)
+	c.virtual = max(c.virtual, w.finish)
+	q.forget(w)
+	return w
+}
+
+// remove drops a queued waiter. Returns false if w is not queued.
+func (q *waitQueue) remove(w *waiter) bool {
+	if w.index < 0 {
+		return false
+	}
+	heap.Remove(&q.classes[w.priority.class()].waiters, w.index)
+	q.forget(w)
+	return true
+}
+
+func (q *waitQueue) forget(w *waiter) {
+	c := &q.classes[w.priority.class()]
+	c.tokens -= w.n
+	q.len--
+	q.tokens -= w.n
+	if q.perKey[w.key]--; q.perKey[w.key] <= 0 {
+		delete(q.perKey, w.key)
+		// Nothing queued for the key anymore, its finish time is in the past
+		// for served waiters. Dropping it keeps the map bounded.
+		if c.lastFinish[w.key] <= c.virtual {
+			delete(c.lastFinish, w.key)
+		}
+	}
+}
+
+// drain removes and returns all waiters.
+func (q *waitQueue) drain() []*waiter {
+	var all []*waiter
+	for w := q.pop(); w != nil; w = q.pop() {
+		all = append(all, w)
+	}
+	return all
+}
+
+// drop removes and returns the waiters matching f.
+func (q *waitQueue) drop(f func(*waiter) bool) []*waiter {
+	var dropped []*waiter
+	for i := range q.classes {
+		for _, w := range q.classes[i].waiters {
+			if f(w) {
+				dropped = append(dropped, w)
+			}
+		}
+	}
+	for _, w := range dropped {
+		q.remove(w)
+	}
+	return dropped
+}
+
+// depth returns the number of queued waiters of a class.
+func (q *waitQueue) depth(p Priority) int {
+	return len(q.classes[p.class()].waiters)
+}
+
+// before orders waiters of a class by finish time, then arrival.
+func (w *waiter) before(other *waiter) bool {
+	if w.finish != other.finish {
+		return w.finish < other.finish
+	}
+	return w.seq < other.seq
+}
+
+// waiterHeap implements heap.Interface, keeping waiter.index up to date for remove.
+type waiterHeap []*waiter
+
+func (h waiterHeap) Len() int           { return len(h) }
+func (h waiterHeap) Less(i, j int) bool { return h[i].before(h[j]) }
+func (h waiterHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *waiterHeap) Push(x any) {
+	w := x.(*waiter)
+	w.index = len(*h)
+	*h = append(*h, w)
+}
+
+func (h *waiterHeap) Pop() any {
+	old := *h
+	n := len(old)
+	w := old[n-1]
+	old[n-1] = nil
+	w.index = -1
+	*h = old[:n-1]
+	return w
+}
@@ -1,10 +1,29 @@
 // Copyright 2025 Jacob Philip. All rights reserved.
-// Super simple rate limiter using a token bucket algorithm.
-// Requests to Allow waits until a token is available.
+// Rate limiters. All algorithms implement the Limiter interface:
+// token bucket (RateLimiter, this file), sliding window log, sliding window
+// counter and GCRA. Only the token bucket can queue waiters.
+//
+// Token bucket:
+// Tokens are not added by a ticker. The bucket is refilled from the time elapsed
+// since the last refill, so high rates are exact and an idle limiter never wakes up.
+// A timer is armed only while requests are queued, for the moment the request at
+// the head of the queue can be served.
+// Requests to Wait block until a token is available, the context is done or
+// the limiter is shut down. Queued requests are served by priority class, and
+// within a class by weighted fair queuing across keys (see Acquire), so one
+// noisy client cannot starve everyone else.
+// TryAllow and Reserve never block, which suits callers that prefer rejecting
+// a request over queueing it. SetRate and SetBurst change the limits at
+// runtime, queued waiters included.
 // No busy looping! Uses the magic of Go channels!
 package ratelimiter
 
 import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -13,70 +32,477 @@ const (
 	maxOpsPerSec = 1000000
 )
 
+var (
+	// ErrQueueFull is returned when too many requests are already waiting for a token.
+	ErrQueueFull = errors.New("ratelimiter: queue is full")
+	// ErrClosed is returned once the rate limiter is shut down.
+	ErrClosed = errors.New("ratelimiter: closed")
+	// ErrExceedsBurst is returned when a request asks for more tokens than the bucket can hold.
+	ErrExceedsBurst = errors.New("ratelimiter: requested tokens exceed bucket size")
+
+	errWouldBlock = errors.New("ratelimiter: no token available")
+)
+
 type RateLimiter struct {
-	operationsPerSecond int
-	tokens              int
-	check               chan chan struct{}
-	done                chan struct{}
-	requests            []chan struct{}
+	// state below is owned by run
+	// tokens added per second
+	rate float64
+	// bucket size, the largest number of tokens that can be taken at once
+	burst        int
+	tokens       float64
+	last         time.Time
+	queueSize    int
+	keyQueueSize int
+	clock        Clock
+	check        chan *waiter
+	cancel       chan *waiter
+	tune         chan tuning
+	done         chan struct{}
+	closeOnce    sync.Once
+	queue        waitQueue
+	// arrival order of waiters, breaks ties in the queue
+	seq uint64
+	// queued waiters per priority class, written by run after every event
+	depth [numPriorities]atomic.Int64
 }
 
-func NewRateLimiter(o int) *RateLimiter {
-	if o <= 0 {
-		o = 1
-	}
-	if o > maxOpsPerSec {
-		o = maxOpsPerSec
-	}
+// Request describes a claim on n tokens for Acquire.
+type Request struct {
+	// Tokens to take, defaults to 1
+	N int
+	// Class of the request. Queued requests of a higher class are always served first.
+	Priority Priority
+	// Key identifies the client. Within a class, queued requests are shared
+	// fairly across keys instead of first come first served.
+	Key string
+	// Share of a key relative to other keys of the class, defaults to 1.
+	// A key with weight 2 is served twice as many tokens as a key with weight 1
+	// while both have requests queued.
+	Weight int
+}
+
+// tuning is a change of rate or burst, zero fields are left alone.
+// run closes done once the change is applied.
+type tuning struct {
+	rate  int
+	burst int
+	done  chan struct{}
+}
+
+// waiter is a request for n tokens.
+// run answers every waiter on ack: err and timeToAct are set before ack is closed.
+// If the waiter is queued, the outcome is sent later on result.
+// result is buffered, so that run never blocks on a waiter that went away.
+// nil is sent when the tokens are granted, an error when the request is rejected.
+type waiter struct {
+	n         int
+	priority  Priority
+	key       string
+	weight    int
+	noWait    bool
+	ack       chan struct{}
+	err       error
+	timeToAct time.Time
+	status    Status
+	result    chan error
+	// queue state, see waitQueue
+	finish float64
+	seq    uint64
+	index  int
+}
+
+// NewRateLimiter returns a limiter allowing o operations per second.
+// The bucket starts full.
+func NewRateLimiter(o int, opts ...Option) *RateLimiter {
+	cfg := newOptions(o, opts)
 	rl := &RateLimiter{
-		operationsPerSecond: o,
-		tokens:              o,
-		check:               make(chan chan struct{}),
-		done:                make(chan struct{}),
-		requests:            make([]chan struct{}, 0),
+		rate:         float64(cfg.rate),
+		burst:        cfg.burst,
+		tokens:       float64(cfg.burst),
+		last:         cfg.clock.Now(),
+		queueSize:    cfg.queueSize,
+		keyQueueSize: cfg.keyQueueSize,
+		clock:        cfg.clock,
+		check:        make(chan *waiter),
+		cancel:       make(chan *waiter),
+		tune:         make(chan tuning),
+		done:         make(chan struct{}),
+		queue:        newWaitQueue(),
 	}
 	go rl.run()
 	return rl
 }
 
 func (rl *RateLimiter) run() {
-	tokenAllot := time.NewTicker(time.Second / time.Duration(rl.operationsPerSecond))
-	heartBeat := time.NewTicker(time.Millisecond)
-	defer tokenAllot.Stop()
-	defer heartBeat.Stop()
+	// wakeup is nil while nothing is queued, a nil channel never fires
+	var timer Timer
+	var wakeup <-chan time.Time
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
 
 	for {
 		select {
-		case <-heartBeat.C:
-			for rl.tokens > 0 && len(rl.requests) > 0 {
-				req := rl.requests[0]
-				rl.requests[0] = nil
-				rl.requests = rl.requests[1:]
-				req <- struct{}{}
-				rl.tokens--
-			}
-		case <-tokenAllot.C:
-			if rl.tokens < rl.operationsPerSecond {
-				rl.tokens++
-			}
-		case <-rl.done:
-			return
+		case now := <-wakeup:
+			timer, wakeup = nil, nil
+			rl.refill(now)
 		case req := <-rl.check:
-			if len(rl.requests) > maxQueueSize {
-				continue // Drop request if too many pending
+			rl.admit(req)
+			close(req.ack)
+		case req := <-rl.cancel:
+			rl.remove(req)
+		case t := <-rl.tune:
+			rl.apply(t)
+			close(t.done)
+		case <-rl.done:
+			for _, req := range rl.queue.drain() {
+				req.result <- ErrClosed
 			}
-			rl.requests = append(rl.requests, req)
+			rl.updateDepth()
+			return
+		}
+
+		rl.dispatch()
+		rl.updateDepth()
+		if timer != nil {
+			timer.Stop()
+			timer, wakeup = nil, nil
+		}
+		if d, ok := rl.nextWakeup(); ok {
+			timer = rl.clock.NewTimer(d)
+			wakeup = timer.C()
+		}
+	}
+}
+
+// refill adds the tokens earned since the last refill, up to the bucket size.
+func (rl *RateLimiter) refill(now time.Time) {
+	if !now.After(rl.last) {
+		return
+	}
+	elapsed := now.Sub(rl.last)
+	rl.tokens = math.Min(float64(rl.burst), rl.tokens+float64(elapsed)*rl.rate/float64(time.Second))
+	rl.last = now
+}
+
+// apply changes rate and burst. Tokens earned so far are accounted at the old
+// rate. A smaller bucket drops the tokens that do not fit anymore, and queued
+// waiters that ask for more than the new bucket size fail with ErrExceedsBurst.
+// Waiters are served at the new rate from now on, run re-arms the timer.
+func (rl *RateLimiter) apply(t tuning) {
+	rl.refill(rl.clock.Now())
+	if t.rate > 0 {
+		rl.rate = float64(min(t.rate, maxOpsPerSec))
+	}
+	if t.burst > 0 {
+		rl.burst = t.burst
+		rl.tokens = math.Min(rl.tokens, float64(rl.burst))
+		for _, req := range rl.queue.drop(func(w *waiter) bool { return w.n > rl.burst }) {
+			req.result <- ErrExceedsBurst
+		}
+	}
+}
+
+// durationFor returns the time needed to earn the given number of tokens.
+// Rounded up, so that a waiter is never woken up a moment too early.
+func (rl *RateLimiter) durationFor(tokens float64) time.Duration {
+	if tokens <= 0 {
+		return 0
+	}
+	return time.Duration(math.Ceil(tokens * float64(time.Second) / rl.rate))
+}
+
+// dispatch serves queued waiters in order while there are enough tokens.
+// The head of the queue is not skipped for smaller requests behind it,
+// a large request would starve otherwise.
+func (rl *RateLimiter) dispatch() {
+	for req := rl.queue.peek(); req != nil && rl.tokens >= float64(req.n); req = rl.queue.peek() {
+		rl.queue.pop()
+		rl.tokens -= float64(req.n)
+		req.result <- nil
+	}
+}
+
+// nextWakeup returns when the head of the queue can be served.
+func (rl *RateLimiter) nextWakeup() (time.Duration, bool) {
+	req := rl.queue.peek()
+	if req == nil {
+		return 0, false
+	}
+	return max(rl.durationFor(float64(req.n)-rl.tokens), 1), true
+}
+
+func (rl *RateLimiter) updateDepth() {
+	for _, p := range Priorities {
+		rl.depth[p.class()].Store(int64(rl.queue.depth(p)))
+	}
+}
+
+// admit grants the tokens right away if nobody would be served before the
+// waiter and enough tokens are available. Otherwise the waiter is queued,
+// unless it asked not to wait.
+func (rl *RateLimiter) admit(req *waiter) {
+	now := rl.clock.Now()
+	rl.refill(now)
+	defer func() {
+		// Queued tokens are as good as taken
+		left := rl.tokens - float64(rl.queue.tokens)
+		req.status = Status{
+			Limit:     rl.burst,
+			Remaining: max(int(left), 0),
+			Reset:     rl.durationFor(float64(rl.burst) - left),
+		}
+	}()
+	if req.n > rl.burst {
+		req.err = ErrExceedsBurst
+		return
+	}
+	rl.seq++
+	req.seq = rl.seq
+	rl.queue.tag(req)
+	// Tokens of the queued waiters served first. A higher class or a key
+	// that had less than its share recently can go ahead of the queue.
+	ahead := rl.queue.ahead(req)
+	if ahead == 0 && rl.tokens >= float64(req.n) {
+		rl.queue.grant(req)
+		rl.tokens -= float64(req.n)
+		req.timeToAct = now
+		req.result <- nil
+		return
+	}
+	// An estimate: requests of a higher class arriving later go first
+	deficit := float64(ahead+req.n) - rl.tokens
+	req.timeToAct = now.Add(rl.durationFor(deficit))
+	if req.noWait {
+		req.err = errWouldBlock
+		return
+	}
+	if rl.queue.len >= rl.queueSize ||
+		(rl.keyQueueSize > 0 && rl.queue.perKey[req.key] >= rl.keyQueueSize) {
+		req.err = ErrQueueFull
+		return
+	}
+	rl.queue.push(req)
+}
+
+// remove drops a cancelled waiter from the queue.
+// If the waiter is not queued anymore, it was either granted its tokens or rejected.
+// Granted tokens are returned to the bucket, since the caller is not going to use them.
+func (rl *RateLimiter) remove(req *waiter) {
+	if rl.queue.remove(req) {
+		return
+	}
+	select {
+	case err := <-req.result:
+		if err == nil {
+			rl.tokens = math.Min(rl.tokens+float64(req.n), float64(rl.burst))
+		}
+	default:
+	}
+}
+
+// submit hands the waiter to run and waits for the acknowledgement.
+func (rl *RateLimiter) submit(req *waiter) error {
+	select {
+	case rl.check <- req:
+	case <-rl.done:
+		return ErrClosed
+	}
+	<-req.ack
+	return req.err
+}
+
+func newWaiter(r Request, noWait bool) *waiter {
+	if !r.Priority.valid() {
+		r.Priority = PriorityNormal
+	}
+	return &waiter{
+		n:        max(r.N, 1),
+		priority: r.Priority,
+		key:      r.Key,
+		weight:   max(r.Weight, 1),
+		noWait:   noWait,
+		ack:      make(chan struct{}),
+		result:   make(chan error, 1),
+		index:    -1,
+	}
+}
+
+// Wait blocks until a token is available.
+// Returns the context error if ctx is done first, ErrQueueFull if too many
+// requests are pending and ErrClosed after Shutdown.
+func (rl *RateLimiter) Wait(ctx context.Context) error {
+	return rl.AllowN(ctx, 1)
+}
+
+// AllowN blocks until n tokens are available. Errors are the same as Wait,
+// in addition ErrExceedsBurst is returned if n can never be satisfied.
+func (rl *RateLimiter) AllowN(ctx context.Context, n int) error {
+	if n <= 0 {
+		return ctx.Err()
+	}
+	return rl.Acquire(ctx, Request{N: n})
+}
 
+// Acquire blocks until the tokens of the request are available.
+// Errors are the same as AllowN. Priority, Key and Weight decide the
+// order in which queued requests are served.
+func (rl *RateLimiter) Acquire(ctx context.Context, req Request) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r := rl.ReserveRequest(req)
+	if r.err != nil {
+		return r.err
+	}
+
+	select {
+	case err := <-r.w.result:
+		return err
+	case <-ctx.Done():
+		r.Cancel()
+		return ctx.Err()
+	case <-rl.done:
+		// The tokens may have been granted right before the shutdown
+		select {
+		case err := <-r.w.result:
+			return err
+		default:
+			return ErrClosed
 		}
 	}
 }
 
-func (rl *RateLimiter) Allow() {
-	req := make(chan struct{})
-	rl.check <- req
-	<-req
+// Allow blocks until a token is available.
+// It is equivalent to Wait with a background context.
+func (rl *RateLimiter) Allow() error {
+	return rl.Wait(context.Background())
+}
+
+// TryAllow takes a token if one is available right now and nobody is queued
+// ahead of it.
+// It never blocks on the token bucket.
+func (rl *RateLimiter) TryAllow() bool {
+	return rl.Take(1).Allowed
+}
+
+// Take implements Limiter. Like TryAllow, it does not jump the queue.
+func (rl *RateLimiter) Take(n int) Decision {
+	w := newWaiter(Request{N: n}, true)
+	err := rl.submit(w)
+	d := Decision{
+		Allowed: err == nil,
+		Status:  w.status,
+	}
+	if err == errWouldBlock {
+		d.RetryAfter = max(w.timeToAct.Sub(rl.clock.Now()), 0)
+	}
+	return d
+}
+
+// Reservation is a claim on future tokens, returned by Reserve.
+type Reservation struct {
+	rl        *RateLimiter
+	w         *waiter
+	err       error
+	timeToAct time.Time
+	status    Status
+}
+
+// Reserve claims n tokens without blocking.
+// The reservation tells how long the caller has to wait before acting (Delay).
+// A caller that decides not to act, for example to reject a request with 429,
+// must Cancel the reservation so that the tokens go to someone else.
+func (rl *RateLimiter) Reserve(n int) *Reservation {
+	return rl.ReserveRequest(Request{N: n})
+}
+
+// ReserveRequest is Reserve with the priority and key of the request.
+func (rl *RateLimiter) ReserveRequest(req Request) *Reservation {
+	w := newWaiter(req, false)
+	err := rl.submit(w)
+	return &Reservation{
+		rl:        rl,
+		w:         w,
+		err:       err,
+		timeToAct: w.timeToAct,
+		status:    w.status,
+	}
+}
+
+// OK reports whether the reservation was accepted.
+// It is false when the queue is full, n exceeds the bucket size or the limiter is closed.
+func (r *Reservation) OK() bool {
+	return r.err == nil
+}
+
+// Err returns the reason a reservation was not accepted.
+func (r *Reservation) Err() error {
+	return r.err
+}
+
+// Status returns the state of the bucket once this reservation was made.
+func (r *Reservation) Status() Status {
+	return r.status
+}
+
+// Delay returns the estimated time until the reserved tokens are available.
+// Zero means the caller can act right away.
+func (r *Reservation) Delay() time.Duration {
+	if r.err != nil {
+		return 0
+	}
+	return max(r.timeToAct.Sub(r.rl.clock.Now()), 0)
+}
+
+// Cancel gives up the reservation. Queued reservations leave the queue and
+// granted tokens are returned to the bucket.
+func (r *Reservation) Cancel() {
+	if r.err != nil {
+		return
+	}
+	select {
+	case r.rl.cancel <- r.w:
+	case <-r.rl.done:
+	}
+}
+
+// QueueDepth returns the number of requests waiting for tokens per priority class.
+func (rl *RateLimiter) QueueDepth() map[Priority]int {
+	depth := make(map[Priority]int, numPriorities)
+	for _, p := range Priorities {
+		depth[p] = int(rl.depth[p.class()].Load())
+	}
+	return depth
+}
+
+// SetRate changes the number of tokens added per second.
+// It takes effect for queued waiters as well.
+func (rl *RateLimiter) SetRate(o int) {
+	rl.setLimits(tuning{rate: max(o, 1)})
+}
+
+// SetBurst changes the bucket size. Queued waiters asking for more tokens
+// than the new size fail with ErrExceedsBurst.
+func (rl *RateLimiter) SetBurst(b int) {
+	rl.setLimits(tuning{burst: max(b, 1)})
+}
+
+func (rl *RateLimiter) setLimits(t tuning) {
+	t.done = make(chan struct{})
+	select {
+	case rl.tune <- t:
+		<-t.done
+	case <-rl.done:
+	}
 }
 
+// Shutdown stops the rate limiter. Pending and future requests fail with ErrClosed.
+// It is safe to call Shutdown more than once.
 func (rl *RateLimiter) Shutdown() {
-	close(rl.done)
+	rl.closeOnce.Do(func() {
+		close(rl.done)
+	})
 }
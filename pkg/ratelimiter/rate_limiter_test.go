@@ -0,0 +1,404 @@
+// Copyright 2025 Jacob Philip. All rights reserved.
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWait(t *testing.T) {
+	rl := NewRateLimiter(1)
+	defer rl.Shutdown()
+
+	// Bucket starts full
+	assert.NoError(t, rl.Wait(context.Background()))
+
+	// Next token is a second away
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, rl.Wait(ctx), context.DeadlineExceeded)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, rl.Wait(ctx), context.Canceled)
+}
+
+func TestWaitCancelRemovesWaiter(t *testing.T) {
+	rl := NewRateLimiter(1, WithQueueSize(1))
+	defer rl.Shutdown()
+	assert.NoError(t, rl.Wait(context.Background()))
+
+	// Cancelled waiters must free their slot in the queue
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		assert.ErrorIs(t, rl.Wait(ctx), context.DeadlineExceeded)
+		cancel()
+	}
+}
+
+func TestWaitQueueFull(t *testing.T) {
+	// Fake clock never moves, so the queued waiter is never served
+	clock := newFakeClock()
+	rl := NewRateLimiter(1, WithQueueSize(1), WithClock(clock))
+	defer rl.Shutdown()
+	assert.NoError(t, rl.Wait(context.Background()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	queued := make(chan error, 1)
+	go func() {
+		queued <- rl.Wait(ctx)
+	}()
+	// A timer is armed only while a waiter is queued
+	assert.Eventually(t, func() bool {
+		return clock.Pending() == 1
+	}, time.Second, time.Millisecond)
+
+	assert.ErrorIs(t, rl.Wait(context.Background()), ErrQueueFull)
+
+	cancel()
+	assert.ErrorIs(t, <-queued, context.Canceled)
+}
+
+func TestWaitAfterShutdown(t *testing.T) {
+	rl := NewRateLimiter(1)
+	assert.NoError(t, rl.Wait(context.Background()))
+
+	pending := make(chan error, 1)
+	go func() {
+		pending <- rl.Wait(context.Background())
+	}()
+
+	rl.Shutdown()
+	rl.Shutdown()
+	assert.ErrorIs(t, <-pending, ErrClosed)
+	assert.ErrorIs(t, rl.Wait(context.Background()), ErrClosed)
+}
+
+func TestTryAllow(t *testing.T) {
+	clock := newFakeClock()
+	rl := NewRateLimiter(10, WithClock(clock))
+	defer rl.Shutdown()
+
+	for i := 0; i < 10; i++ {
+		assert.True(t, rl.TryAllow(), "token %d should be available", i)
+	}
+	assert.False(t, rl.TryAllow())
+
+	// One token every 100ms
+	clock.Advance(100 * time.Millisecond)
+	assert.True(t, rl.TryAllow())
+	assert.False(t, rl.TryAllow())
+
+	// Bucket never holds more than its size
+	clock.Advance(5 * time.Second)
+	for i := 0; i < 10; i++ {
+		assert.True(t, rl.TryAllow())
+	}
+	assert.False(t, rl.TryAllow())
+}
+
+func TestReserve(t *testing.T) {
+	clock := newFakeClock()
+	rl := NewRateLimiter(10, WithClock(clock))
+	defer rl.Shutdown()
+
+	r := rl.Reserve(10)
+	assert.True(t, r.OK())
+	assert.Equal(t, time.Duration(0), r.Delay())
+
+	r = rl.Reserve(3)
+	assert.True(t, r.OK())
+	assert.Equal(t, 300*time.Millisecond, r.Delay())
+
+	// Queued behind the first reservation
+	r2 := rl.Reserve(1)
+	assert.Equal(t, 400*time.Millisecond, r2.Delay())
+
+	clock.Advance(100 * time.Millisecond)
+	assert.Equal(t, 300*time.Millisecond, r2.Delay())
+
+	// Cancelling the first reservation lets the second one move up
+	r.Cancel()
+	r2.Cancel()
+	r3 := rl.Reserve(1)
+	assert.Equal(t, time.Duration(0), r3.Delay())
+
+	r = rl.Reserve(11)
+	assert.False(t, r.OK())
+	assert.ErrorIs(t, r.Err(), ErrExceedsBurst)
+}
+
+func TestReserveCancelReturnsTokens(t *testing.T) {
+	clock := newFakeClock()
+	rl := NewRateLimiter(2, WithClock(clock))
+	defer rl.Shutdown()
+
+	r := rl.Reserve(2)
+	assert.Equal(t, time.Duration(0), r.Delay())
+	assert.False(t, rl.TryAllow())
+
+	r.Cancel()
+	assert.True(t, rl.TryAllow())
+	assert.True(t, rl.TryAllow())
+	assert.False(t, rl.TryAllow())
+}
+
+func TestAllowN(t *testing.T) {
+	clock := newFakeClock()
+	rl := NewRateLimiter(10, WithClock(clock))
+	defer rl.Shutdown()
+
+	assert.NoError(t, rl.AllowN(context.Background(), 10))
+	assert.ErrorIs(t, rl.AllowN(context.Background(), 11), ErrExceedsBurst)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- rl.AllowN(context.Background(), 5)
+	}()
+
+	// The waiter may not be queued yet, keep advancing until it is served
+	assert.Eventually(t, func() bool {
+		clock.Advance(100 * time.Millisecond)
+		select {
+		case err := <-done:
+			return assert.NoError(t, err)
+		default:
+			return false
+		}
+	}, time.Second, time.Millisecond)
+}
+
+func TestBurst(t *testing.T) {
+	clock := newFakeClock()
+	rl := NewRateLimiter(10, WithBurst(2), WithClock(clock))
+	defer rl.Shutdown()
+
+	assert.True(t, rl.TryAllow())
+	assert.True(t, rl.TryAllow())
+	assert.False(t, rl.TryAllow())
+	assert.ErrorIs(t, rl.Reserve(3).Err(), ErrExceedsBurst)
+
+	// Refill is capped at the burst, not at the rate
+	clock.Advance(time.Second)
+	assert.True(t, rl.TryAllow())
+	assert.True(t, rl.TryAllow())
+	assert.False(t, rl.TryAllow())
+}
+
+func TestHighRate(t *testing.T) {
+	clock := newFakeClock()
+	rl := NewRateLimiter(maxOpsPerSec, WithClock(clock))
+	defer rl.Shutdown()
+
+	assert.NoError(t, rl.AllowN(context.Background(), maxOpsPerSec))
+	assert.False(t, rl.TryAllow())
+
+	// A million per second is a token per microsecond
+	clock.Advance(time.Millisecond)
+	allowed := 0
+	for rl.TryAllow() {
+		allowed++
+	}
+	assert.Equal(t, 1000, allowed)
+
+	r := rl.Reserve(500)
+	assert.Equal(t, 500*time.Microsecond, r.Delay())
+}
+
+func TestNoIdleWakeups(t *testing.T) {
+	clock := newFakeClock()
+	rl := NewRateLimiter(10, WithClock(clock))
+	defer rl.Shutdown()
+
+	assert.NoError(t, rl.AllowN(context.Background(), 10))
+	assert.Equal(t, 0, clock.Pending(), "an idle limiter must not arm timers")
+
+	done := make(chan error, 1)
+	go func() {
+		done <- rl.AllowN(context.Background(), 2)
+	}()
+	// Exactly one timer while the waiter is queued
+	assert.Eventually(t, func() bool {
+		return clock.Pending() == 1
+	}, time.Second, time.Millisecond)
+
+	clock.Advance(199 * time.Millisecond)
+	select {
+	case <-done:
+		t.Fatal("waiter served before its tokens were earned")
+	default:
+	}
+	clock.Advance(time.Millisecond)
+	assert.NoError(t, <-done)
+	assert.Equal(t, 0, clock.Pending())
+}
+
+func TestSetRate(t *testing.T) {
+	clock := newFakeClock()
+	rl := NewRateLimiter(1, WithClock(clock))
+	defer rl.Shutdown()
+	assert.True(t, rl.TryAllow())
+
+	r := rl.Reserve(1)
+	assert.Equal(t, time.Second, r.Delay())
+	clock.Advance(50 * time.Millisecond)
+
+	// The queued waiter is served at the new rate, tokens earned so far count
+	// 0.05 tokens earned at the old rate, 0.95 take 95ms at the new one
+	rl.SetRate(10)
+	clock.Advance(94 * time.Millisecond)
+	assert.Len(t, r.w.result, 0)
+	clock.Advance(time.Millisecond)
+	assert.Equal(t, "r", served(t, map[string]*Reservation{"r": r}))
+}
+
+func TestSetBurst(t *testing.T) {
+	clock := newFakeClock()
+	rl := NewRateLimiter(10, WithClock(clock))
+	defer rl.Shutdown()
+	assert.True(t, rl.Take(10).Allowed)
+	r := rl.Reserve(8)
+	assert.True(t, r.OK())
+
+	// Queued waiters that do not fit anymore are rejected
+	rl.SetBurst(5)
+	assert.ErrorIs(t, <-r.w.result, ErrExceedsBurst)
+
+	clock.Advance(time.Minute)
+	d := rl.Take(6)
+	assert.False(t, d.Allowed)
+	assert.Equal(t, 5, d.Limit)
+	assert.True(t, rl.Take(5).Allowed)
+}
+
+// served waits for the next reservation to be granted and returns its name.
+func served(t *testing.T, pending map[string]*Reservation) string {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		for name, r := range pending {
+			select {
+			case err := <-r.w.result:
+				assert.NoError(t, err)
+				delete(pending, name)
+				return name
+			default:
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("no reservation was served")
+	return ""
+}
+
+func TestPriorityAndFairQueuing(t *testing.T) {
+	clock := newFakeClock()
+	rl := NewRateLimiter(1, WithClock(clock))
+	defer rl.Shutdown()
+	assert.True(t, rl.TryAllow())
+
+	pending := make(map[string]*Reservation)
+	reserve := func(name string, req Request) {
+		r := rl.ReserveRequest(req)
+		assert.True(t, r.OK())
+		pending[name] = r
+	}
+	// A noisy client queues first, a quiet one and the other classes later
+	for _, name := range []string{"a1", "a2", "a3", "a4"} {
+		reserve(name, Request{Key: "a"})
+	}
+	reserve("b1", Request{Key: "b"})
+	reserve("low", Request{Key: "c", Priority: PriorityLow})
+	reserve("high", Request{Key: "c", Priority: PriorityHigh})
+	assert.Eventually(t, func() bool {
+		d := rl.QueueDepth()
+		return d[PriorityLow] == 1 && d[PriorityNormal] == 5 && d[PriorityHigh] == 1
+	}, time.Second, time.Millisecond)
+
+	// Only higher classes count against the estimate of the high priority request
+	assert.Equal(t, time.Second, pending["high"].Delay())
+
+	var order []string
+	for len(pending) > 0 {
+		clock.Advance(time.Second)
+		order = append(order, served(t, pending))
+	}
+	assert.Equal(t, []string{"high", "a1", "b1", "a2", "a3", "a4", "low"}, order)
+	assert.Eventually(t, func() bool {
+		return rl.QueueDepth()[PriorityNormal] == 0
+	}, time.Second, time.Millisecond)
+}
+
+func TestWeightedFairQueuing(t *testing.T) {
+	clock := newFakeClock()
+	rl := NewRateLimiter(1, WithClock(clock))
+	defer rl.Shutdown()
+	assert.True(t, rl.TryAllow())
+
+	pending := make(map[string]*Reservation)
+	for i := 1; i <= 4; i++ {
+		pending[fmt.Sprintf("a%d", i)] = rl.ReserveRequest(Request{Key: "a", Weight: 2})
+	}
+	for i := 1; i <= 4; i++ {
+		pending[fmt.Sprintf("b%d", i)] = rl.ReserveRequest(Request{Key: "b"})
+	}
+
+	var order []string
+	for len(pending) > 0 {
+		clock.Advance(time.Second)
+		order = append(order, served(t, pending))
+	}
+	// Twice the share for a while both are queued
+	assert.Equal(t, []string{"a1", "a2", "b1", "a3", "a4", "b2", "b3", "b4"}, order)
+}
+
+func TestKeyQueueSize(t *testing.T) {
+	clock := newFakeClock()
+	rl := NewRateLimiter(1, WithKeyQueueSize(2), WithClock(clock))
+	defer rl.Shutdown()
+	assert.True(t, rl.TryAllow())
+
+	assert.True(t, rl.ReserveRequest(Request{Key: "a"}).OK())
+	assert.True(t, rl.ReserveRequest(Request{Key: "a"}).OK())
+	assert.ErrorIs(t, rl.ReserveRequest(Request{Key: "a"}).Err(), ErrQueueFull)
+	// Other clients can still queue
+	assert.True(t, rl.ReserveRequest(Request{Key: "b"}).OK())
+}
+
+func BenchmarkTryAllow(b *testing.B) {
+	rl := NewRateLimiter(maxOpsPerSec)
+	defer rl.Shutdown()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rl.TryAllow()
+	}
+}
+
+func BenchmarkWaitParallel(b *testing.B) {
+	rl := NewRateLimiter(maxOpsPerSec)
+	defer rl.Shutdown()
+	ctx := context.Background()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := rl.Wait(ctx); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func BenchmarkReserveCancel(b *testing.B) {
+	rl := NewRateLimiter(1)
+	defer rl.Shutdown()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rl.Reserve(1).Cancel()
+	}
+}
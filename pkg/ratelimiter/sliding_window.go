@@ -0,0 +1,171 @@
+// Copyright 2025 Jacob Philip. All rights reserved.
+package ratelimiter
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// SlidingWindowLog keeps the time of every allowed request in the last window.
+// It is exact: no window of the configured length ever holds more than the limit,
+// at the cost of memory proportional to the limit.
+type SlidingWindowLog struct {
+	mu     sync.Mutex
+	limit  int
+	window time.Duration
+	clock  Clock
+	// allowed requests, oldest first
+	log   []logEntry
+	count int
+}
+
+type logEntry struct {
+	at time.Time
+	n  int
+}
+
+// NewSlidingWindowLog allows burst requests per window of burst/rate seconds.
+func NewSlidingWindowLog(rate int, opts ...Option) *SlidingWindowLog {
+	cfg := newOptions(rate, opts)
+	return &SlidingWindowLog{
+		limit:  cfg.burst,
+		window: cfg.window(),
+		clock:  cfg.clock,
+	}
+}
+
+// Take implements Limiter.
+func (l *SlidingWindowLog) Take(n int) Decision {
+	n = max(n, 1)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.clock.Now()
+
+	// Drop entries that left the window
+	i := 0
+	for ; i < len(l.log) && !l.log[i].at.Add(l.window).After(now); i++ {
+		l.count -= l.log[i].n
+	}
+	l.log = l.log[i:]
+
+	d := Decision{}
+	if n <= l.limit && l.count+n <= l.limit {
+		l.log = append(l.log, logEntry{at: now, n: n})
+		l.count += n
+		d.Allowed = true
+	} else if n <= l.limit {
+		// Wait until enough of the oldest entries expire
+		free := l.limit - l.count
+		for _, e := range l.log {
+			free += e.n
+			if free >= n {
+				d.RetryAfter = e.at.Add(l.window).Sub(now)
+				break
+			}
+		}
+	}
+	d.Status = Status{Limit: l.limit, Remaining: l.limit - l.count}
+	if len(l.log) > 0 {
+		d.Reset = l.log[len(l.log)-1].at.Add(l.window).Sub(now)
+	}
+	return d
+}
+
+// Shutdown implements Limiter. There is nothing to release.
+func (l *SlidingWindowLog) Shutdown() {}
+
+// SlidingWindowCounter approximates the sliding window log with two counters:
+// the current fixed window and the previous one. The previous count is weighted
+// by how much of it still overlaps the sliding window.
+// Memory is constant, the price is that it assumes requests were evenly spread
+// over the previous window.
+type SlidingWindowCounter struct {
+	mu     sync.Mutex
+	limit  int
+	window time.Duration
+	clock  Clock
+	// start of the current fixed window
+	start time.Time
+	prev  int
+	curr  int
+}
+
+// NewSlidingWindowCounter allows burst requests per window of burst/rate seconds.
+func NewSlidingWindowCounter(rate int, opts ...Option) *SlidingWindowCounter {
+	cfg := newOptions(rate, opts)
+	return &SlidingWindowCounter{
+		limit:  cfg.burst,
+		window: cfg.window(),
+		clock:  cfg.clock,
+		start:  cfg.clock.Now(),
+	}
+}
+
+// Take implements Limiter.
+func (l *SlidingWindowCounter) Take(n int) Decision {
+	n = max(n, 1)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.clock.Now()
+
+	// Roll the fixed windows forward
+	if elapsed := now.Sub(l.start); elapsed >= l.window {
+		windows := elapsed / l.window
+		if windows == 1 {
+			l.prev = l.curr
+		} else {
+			l.prev = 0
+		}
+		l.curr = 0
+		l.start = l.start.Add(windows * l.window)
+	}
+
+	elapsed := now.Sub(l.start)
+	d := Decision{}
+	if n <= l.limit && l.estimate(elapsed)+float64(n) <= float64(l.limit) {
+		l.curr += n
+		d.Allowed = true
+	} else if n <= l.limit {
+		d.RetryAfter = l.retryAfter(elapsed, n)
+	}
+
+	d.Status = Status{
+		Limit:     l.limit,
+		Remaining: max(int(math.Floor(float64(l.limit)-l.estimate(elapsed))), 0),
+	}
+	// The previous window fades out at the end of the current one,
+	// the current window at the end of the next one
+	switch {
+	case l.curr > 0:
+		d.Reset = 2*l.window - elapsed
+	case l.prev > 0:
+		d.Reset = l.window - elapsed
+	}
+	return d
+}
+
+func (l *SlidingWindowCounter) estimate(elapsed time.Duration) float64 {
+	overlap := 1 - float64(elapsed)/float64(l.window)
+	return float64(l.prev)*overlap + float64(l.curr)
+}
+
+// retryAfter solves the estimate for the moment n more requests fit.
+func (l *SlidingWindowCounter) retryAfter(elapsed time.Duration, n int) time.Duration {
+	w := float64(l.window)
+	// Still in the current window, if the previous window fades out fast enough
+	if free := float64(l.limit - l.curr - n); free >= 0 && l.prev > 0 {
+		at := w * (1 - free/float64(l.prev))
+		return time.Duration(math.Ceil(at - float64(elapsed)))
+	}
+	// In the next window the current count becomes the fading one
+	free := float64(l.limit - n)
+	at := 0.0
+	if l.curr > 0 {
+		at = math.Max(w*(1-free/float64(l.curr)), 0)
+	}
+	return time.Duration(math.Ceil(w - float64(elapsed) + at))
+}
+
+// Shutdown implements Limiter. There is nothing to release.
+func (l *SlidingWindowCounter) Shutdown() {}